@@ -9,10 +9,16 @@ import (
 	"github.com/txsociety/spice-harvester/internal/config"
 	"github.com/txsociety/spice-harvester/pkg/api"
 	"github.com/txsociety/spice-harvester/pkg/blockchain"
+	"github.com/txsociety/spice-harvester/pkg/confirmer"
 	"github.com/txsociety/spice-harvester/pkg/core"
 	"github.com/txsociety/spice-harvester/pkg/db"
+	"github.com/txsociety/spice-harvester/pkg/email"
 	"github.com/txsociety/spice-harvester/pkg/indexer"
 	"github.com/txsociety/spice-harvester/pkg/notifier"
+	"github.com/txsociety/spice-harvester/pkg/rates"
+	"github.com/txsociety/spice-harvester/pkg/stream"
+	"github.com/txsociety/spice-harvester/pkg/telegram"
+	"github.com/txsociety/spice-harvester/pkg/tracker"
 	"github.com/txsociety/spice-harvester/pkg/webhook"
 	"golang.org/x/crypto/ed25519"
 	"log/slog"
@@ -58,73 +64,137 @@ func main() {
 	wg := new(sync.WaitGroup)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	dbClient, err := db.New(ctx, cfg.PostgresURI, cfg.Recipient)
+	dbClient, err := db.New(ctx, cfg.PostgresURI)
 	if err != nil {
 		slog.Error("db connection", "error", err)
 		os.Exit(1)
 	}
-	err = dbClient.SaveCurrencies(ctx, cfg.Currencies)
+	err = dbClient.SaveDefaultMerchant(ctx, cfg.Recipient)
 	if err != nil {
-		slog.Error("save currencies", "error", err)
+		slog.Error("save default merchant", "error", err)
+		os.Exit(1)
+	}
+	currencies, err := tracker.LoadCurrencies(ctx, dbClient, cfg.Currencies)
+	if err != nil {
+		slog.Error("load currencies", "error", err)
 		os.Exit(1)
 	}
 	cancel()
 
 	ctx, cancel = context.WithCancel(context.Background())
 
-	var wh *webhook.Client
+	senders := make(map[string]notifier.Sender)
+	var alerters []confirmer.Alerter
 	if len(cfg.WebhookEndpoint) > 0 {
-		wh, err = webhook.NewClient(cfg.WebhookEndpoint)
+		wh, err := webhook.NewClient(cfg.WebhookEndpoint, cfg.WebhookSecrets)
 		if err != nil {
 			slog.Error("webhook connection", "error", err)
 			os.Exit(1)
 		}
+		senders[webhook.EndpointSender] = wh
+	}
+	if len(cfg.TelegramBotToken) > 0 {
+		templates := telegram.DefaultTemplates
+		if len(cfg.TelegramTemplates) > 0 {
+			templates, err = telegram.LoadTemplates(cfg.TelegramTemplates)
+			if err != nil {
+				slog.Error("load telegram templates", "error", err)
+				os.Exit(1)
+			}
+		}
+		tg, err := telegram.NewClient(cfg.TelegramAPIURL, cfg.TelegramBotToken, cfg.TelegramChatIDs, templates, currencies)
+		if err != nil {
+			slog.Error("telegram client creation", "error", err)
+			os.Exit(1)
+		}
+		senders[telegram.Sender] = tg
+		alerters = append(alerters, tg)
+	}
+	if len(cfg.SMTPAddr) > 0 {
+		templates := email.DefaultTemplates
+		if len(cfg.SMTPTemplates) > 0 {
+			templates, err = email.LoadTemplates(cfg.SMTPTemplates)
+			if err != nil {
+				slog.Error("load email templates", "error", err)
+				os.Exit(1)
+			}
+		}
+		mailer, err := email.NewClient(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom, cfg.SMTPMerchantEmails, templates, currencies)
+		if err != nil {
+			slog.Error("email client creation", "error", err)
+			os.Exit(1)
+		}
+		senders[email.Sender] = mailer
+		if receipts := mailer.Receipts(); receipts != nil {
+			senders[email.ReceiptSender] = receipts
+		}
+		alerters = append(alerters, mailer)
 	}
 
-	bcClient, err := blockchain.New(cfg.LiteServers)
+	var initBlock *ton.BlockIDExt
+	if cfg.InitBlock.Seqno > 0 {
+		initBlock = &cfg.InitBlock
+	}
+	bcClient, err := blockchain.New(cfg.LiteServers, initBlock)
 	if err != nil {
 		slog.Error("blockchain connection", "error", err)
 		os.Exit(1)
 	}
-	bcClient.RunBlockWatcher(ctx, dbClient, wg)
+	err = bcClient.RunBlockWatcher(ctx, dbClient, wg)
+	if err != nil {
+		slog.Error("run block watcher", "error", err)
+		os.Exit(1)
+	}
 
 	indexerProc, err := indexer.New(bcClient, dbClient)
 	if err != nil {
 		slog.Error("processor creation", "error", err)
 		os.Exit(1)
 	}
-
-	var notifierProc *notifier.Notifier
-	if wh != nil {
-		notifierProc = notifier.New(wh, cfg.Currencies, adnlAddr, cfg.PaymentPrefixes, dbClient)
-	} else {
-		notifierProc = notifier.New(nil, cfg.Currencies, adnlAddr, cfg.PaymentPrefixes, dbClient)
+	if len(cfg.ConfirmationLiteServers) > 0 {
+		txConfirmer, err := confirmer.New(cfg.ConfirmationLiteServers, cfg.Confirmations, alerters)
+		if err != nil {
+			slog.Error("confirmer creation", "error", err)
+			os.Exit(1)
+		}
+		indexerProc.SetConfirmer(txConfirmer)
 	}
 
+	hub := stream.NewHub() // feeds event streams of the API with the events saved by the notifier
+	notifierProc := notifier.New(senders, webhook.NewDispatcher(), hub, currencies, adnlAddr, cfg.PaymentPrefixes, dbClient,
+		notifier.DeliveryConfig{Concurrency: cfg.DeliveryConcurrency, BatchSize: cfg.DeliveryBatchSize})
+
 	accountsChan := indexerProc.Run(ctx, wg)
 	notifierProc.Run(ctx, wg)
 
+	accountTracker := tracker.New(currencies, dbClient, bcClient, indexerProc, accountsChan)
 	ctx1, cancel1 := context.WithTimeout(context.Background(), 60*time.Second)
-	accounts, err := getAccountsForTracking(ctx1, dbClient, bcClient, cfg.Recipient, cfg.Currencies)
+	err = accountTracker.Start(ctx1)
 	cancel1()
 	if err != nil {
-		slog.Error("get accounts for tracking", "error", err)
+		slog.Error("start account tracking", "error", err)
 		os.Exit(1)
 	}
-	for acc, info := range accounts {
-		accountsChan <- core.Account{
-			AccountID: acc,
-			Info:      info,
-		}
+
+	var rateProvider rates.Provider
+	if len(cfg.RatesURL) > 0 {
+		rateProvider, err = rates.NewHTTPProvider(cfg.RatesURL, cfg.RatesTTL)
+	} else if len(cfg.RatesFile) > 0 {
+		rateProvider, err = rates.LoadStaticProvider(cfg.RatesFile)
+	}
+	if err != nil {
+		slog.Error("rate provider creation", "error", err)
+		os.Exit(1)
 	}
 
 	mux := http.NewServeMux()
-	handler := api.NewHandler(dbClient, cfg.Currencies, adnlAddr, cfg.PaymentPrefixes, ourEncryptionKey, cfg.Domain)
+	handler := api.NewHandler(dbClient, currencies, accountTracker, adnlAddr, cfg.PaymentPrefixes, ourEncryptionKey, cfg.Domain, rateProvider, hub, indexerProc)
 	api.RegisterHandlers(mux, handler, cfg.Token)
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%v", cfg.Port),
 		Handler: mux,
 	}
+	srv.RegisterOnShutdown(hub.Close) // event streams last until the client disconnects, so they are finished explicitly
 	go func() {
 		slog.Info("running api server", "port", cfg.Port)
 		err = srv.ListenAndServe()
@@ -136,9 +206,11 @@ func main() {
 
 	sig := <-ch
 	slog.Info("shut down", "signal", sig.String())
-	if err := srv.Shutdown(ctx); err != nil {
+	ctx1, cancel1 = context.WithTimeout(context.Background(), 10*time.Second)
+	if err := srv.Shutdown(ctx1); err != nil {
 		slog.Error("server shutdown", "error", err)
 	}
+	cancel1()
 	slog.Info("api stopped")
 	cancel()
 	wg.Wait()
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/caarlos0/env/v6"
 	"github.com/tonkeeper/tongo/config"
@@ -10,6 +12,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -18,11 +21,36 @@ type Config struct {
 	PostgresURI     string              `env:"POSTGRES_URI,required"`
 	Token           string              `env:"TOKEN,required"`
 	LiteServers     []config.LiteServer `env:"LITE_SERVERS"`
+	InitBlock       ton.BlockIDExt      `env:"INIT_BLOCK"` // trusted masterchain block for checking liteserver proofs, not set if Seqno is 0
 	Recipient       ton.AccountID       `env:"RECIPIENT,required"`
 	Jettons         []jetton            `env:"JETTONS"`
 	WebhookEndpoint string              `env:"WEBHOOK_ENDPOINT"`
+	WebhookSecrets  []string            `env:"WEBHOOK_SECRETS"` // current secret and optionally the previous one during rotation
 	PaymentPrefixes prefixes            `env:"PAYMENT_PREFIXES"`
 	Domain          string              `env:"DOMAIN"`
+	// Exchange rates for fiat priced invoices. RatesURL has priority over RatesFile
+	RatesFile string        `env:"RATES_FILE"`
+	RatesURL  string        `env:"RATES_URL"`
+	RatesTTL  time.Duration `env:"RATES_TTL" envDefault:"1m"`
+	// Notification delivery: number of deliveries sent in parallel and taken from the database at once
+	DeliveryConcurrency int `env:"DELIVERY_CONCURRENCY" envDefault:"10"`
+	DeliveryBatchSize   int `env:"DELIVERY_BATCH_SIZE" envDefault:"100"`
+	// Telegram notifications, TelegramTemplates is a path to JSON file with message templates by event type
+	TelegramBotToken  string   `env:"TELEGRAM_BOT_TOKEN"`
+	TelegramChatIDs   []string `env:"TELEGRAM_CHAT_IDS"`
+	TelegramTemplates string   `env:"TELEGRAM_TEMPLATES"`
+	TelegramAPIURL    string   `env:"TELEGRAM_API_URL" envDefault:"https://api.telegram.org"`
+	// Email notifications, SMTPAddr is host:port of SMTP server, SMTPTemplates is a path to JSON file with email templates
+	SMTPAddr           string   `env:"SMTP_ADDR"`
+	SMTPUsername       string   `env:"SMTP_USERNAME"`
+	SMTPPassword       string   `env:"SMTP_PASSWORD"`
+	SMTPFrom           string   `env:"SMTP_FROM"`
+	SMTPMerchantEmails []string `env:"SMTP_MERCHANT_EMAILS"`
+	SMTPTemplates      string   `env:"SMTP_TEMPLATES"`
+	// Payment confirmations: transactions with payments must be the same on Confirmations of ConfirmationLiteServers,
+	// the majority of them if Confirmations is 0. Disabled if ConfirmationLiteServers is empty
+	ConfirmationLiteServers []config.LiteServer `env:"CONFIRMATION_LITE_SERVERS"`
+	Confirmations           int                 `env:"CONFIRMATIONS"`
 	// Key for generating a private key for metadata encryption and obtaining the adnl address of the proxy server
 	Key        string `env:"KEY"` // 32 bytes in hex representation,
 	Currencies map[string]core.ExtendedCurrency
@@ -61,6 +89,36 @@ func Load() Config {
 			}
 			return servers, nil
 		},
+		reflect.TypeOf(ton.BlockIDExt{}): func(v string) (interface{}, error) {
+			// the same format as validator.init_block of global config
+			var block struct {
+				Workchain int32  `json:"workchain"`
+				Shard     int64  `json:"shard"`
+				Seqno     uint32 `json:"seqno"`
+				RootHash  string `json:"root_hash"`
+				FileHash  string `json:"file_hash"`
+			}
+			err := json.Unmarshal([]byte(v), &block)
+			if err != nil {
+				return nil, fmt.Errorf("invalid init block: %w", err)
+			}
+			rootHash, err := base64.StdEncoding.DecodeString(block.RootHash)
+			if err != nil || len(rootHash) != 32 {
+				return nil, fmt.Errorf("invalid init block root hash: %s", block.RootHash)
+			}
+			fileHash, err := base64.StdEncoding.DecodeString(block.FileHash)
+			if err != nil || len(fileHash) != 32 {
+				return nil, fmt.Errorf("invalid init block file hash: %s", block.FileHash)
+			}
+			if block.Workchain != -1 || uint64(block.Shard) != 0x8000000000000000 || block.Seqno == 0 {
+				return nil, fmt.Errorf("init block must be a masterchain block: %s", v)
+			}
+			return ton.BlockIDExt{
+				BlockID:  ton.BlockID{Workchain: block.Workchain, Shard: uint64(block.Shard), Seqno: block.Seqno},
+				RootHash: ton.Bits256(rootHash),
+				FileHash: ton.Bits256(fileHash),
+			}, nil
+		},
 		reflect.TypeOf(ton.AccountID{}): func(v string) (interface{}, error) {
 			addr, err := ton.ParseAccountID(v)
 			if err != nil {
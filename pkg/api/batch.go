@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"net/http"
+)
+
+const maxBatchSize = 500
+
+type NewInvoiceBatch struct {
+	Invoices []NewInvoice `json:"invoices"`
+}
+
+type InvoiceIDBatch struct {
+	IDs []string `json:"ids"`
+}
+
+// BatchResult is a result of one batch item: the invoice or the error
+type BatchResult struct {
+	Invoice *core.PrivateInvoicePrintable `json:"invoice,omitempty"`
+	Error   string                        `json:"error,omitempty"`
+}
+
+func (h *Handler) createInvoices(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data NewInvoiceBatch
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid invoices data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data.Invoices) == 0 || len(data.Invoices) > maxBatchSize {
+		writeHttpError(w, fmt.Sprintf("batch must contain from 1 to %d invoices", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+	merchant := merchantFromContext(r.Context())
+	var (
+		results  = make([]BatchResult, len(data.Invoices))
+		invoices []core.Invoice
+		indexes  []int // index of the invoice in the batch
+	)
+	for i, newInvoice := range data.Invoices {
+		invoice, err := h.convertNewInvoice(r.Context(), newInvoice, merchant)
+		if err != nil {
+			results[i].Error = "invoice data parsing error: " + err.Error()
+			continue
+		}
+		invoices = append(invoices, *invoice)
+		indexes = append(indexes, i)
+	}
+	if len(invoices) > 0 {
+		errs, err := h.db.CreateInvoices(r.Context(), invoices)
+		if err != nil {
+			writeHttpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for j, invoice := range invoices {
+			res := &results[indexes[j]]
+			created := &invoice
+			err = errs[j]
+			if err != nil && errors.Is(err, core.ErrAlreadyExists) {
+				created, err = h.getOriginalInvoice(r.Context(), invoice)
+			}
+			if err != nil {
+				res.Error = err.Error()
+				continue
+			}
+			printable, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, *created, h.currencies.All(), h.adnlAddress)
+			if err != nil {
+				res.Error = err.Error()
+				continue
+			}
+			res.Invoice = &printable
+		}
+	}
+	writeBatchResults(w, results)
+}
+
+func (h *Handler) cancelInvoices(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data InvoiceIDBatch
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid ids data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data.IDs) == 0 || len(data.IDs) > maxBatchSize {
+		writeHttpError(w, fmt.Sprintf("batch must contain from 1 to %d ids", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+	ids := make([]core.InvoiceID, 0, len(data.IDs))
+	unique := make(map[core.InvoiceID]struct{}, len(data.IDs))
+	for _, idS := range data.IDs {
+		id, err := core.ParseInvoiceID(idS)
+		if err != nil {
+			writeHttpError(w, "invalid id: "+idS, http.StatusBadRequest)
+			return
+		}
+		if _, ok := unique[id]; ok {
+			writeHttpError(w, "duplicated id: "+idS, http.StatusBadRequest)
+			return
+		}
+		unique[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	invoices, errs, err := h.db.CancelInvoices(r.Context(), merchantFromContext(r.Context()).Recipient, ids)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	results := make([]BatchResult, len(ids))
+	for i := range ids {
+		if errs[i] != nil && errors.Is(errs[i], core.ErrNotFound) {
+			results[i].Error = "no waiting payment invoice found"
+			continue
+		} else if errs[i] != nil {
+			results[i].Error = errs[i].Error()
+			continue
+		}
+		printable, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, invoices[i], h.currencies.All(), h.adnlAddress)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Invoice = &printable
+	}
+	writeBatchResults(w, results)
+}
+
+func writeBatchResults(w http.ResponseWriter, results []BatchResult) {
+	res := struct {
+		Results []BatchResult `json:"results"`
+	}{
+		Results: results,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode batch results", "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+const maxTickerLen = 16
+
+type NewCurrency struct {
+	Ticker   string `json:"ticker"`
+	Address  string `json:"address"` // Jetton master
+	Decimals int    `json:"decimals"`
+}
+
+func (h *Handler) getCurrencies(w http.ResponseWriter, r *http.Request) {
+	currencies := h.currencies.List()
+	res := struct {
+		Currencies []core.CurrencyPrintable `json:"currencies"`
+	}{
+		Currencies: make([]core.CurrencyPrintable, 0, len(currencies)),
+	}
+	for _, c := range currencies {
+		res.Currencies = append(res.Currencies, core.ConvertCurrencyToPrintable(c))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode currencies", "error", err)
+	}
+}
+
+// addCurrency adds the jetton and starts tracking its wallet without the restart. A disabled jetton is enabled again.
+func (h *Handler) addCurrency(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data NewCurrency
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid currency data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	master, err := convertNewCurrency(data)
+	if err != nil {
+		writeHttpError(w, "currency data parsing error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	currency, err := h.tracker.AddJetton(r.Context(), data.Ticker, master, data.Decimals)
+	if err != nil && errors.Is(err, core.ErrAlreadyExists) {
+		writeHttpError(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeCurrency(w, currency)
+}
+
+// disableCurrency stops tracking the jetton wallet, new invoices in the currency are rejected
+func (h *Handler) disableCurrency(w http.ResponseWriter, r *http.Request) {
+	ticker := r.PathValue("ticker")
+	currency, ok := h.currencies.Lookup(ticker)
+	if !ok {
+		writeHttpError(w, "currency not found", http.StatusNotFound)
+		return
+	}
+	if currency.Type != core.Jetton {
+		writeHttpError(w, "only jettons can be disabled", http.StatusBadRequest)
+		return
+	}
+	currency, err := h.tracker.DisableCurrency(r.Context(), ticker)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeCurrency(w, currency)
+}
+
+func convertNewCurrency(data NewCurrency) (ton.AccountID, error) {
+	if len(data.Ticker) == 0 || len(data.Ticker) > maxTickerLen {
+		return ton.AccountID{}, fmt.Errorf("ticker must be 1..%d characters", maxTickerLen)
+	}
+	if strings.IndexFunc(data.Ticker, func(r rune) bool { return unicode.IsSpace(r) || r == ',' || r == '/' }) >= 0 {
+		return ton.AccountID{}, fmt.Errorf("ticker must not contain spaces, commas and slashes")
+	}
+	if data.Ticker == core.DefaultTonTicker {
+		return ton.AccountID{}, fmt.Errorf("ticker %s is reserved", core.DefaultTonTicker)
+	}
+	if data.Decimals < 0 || data.Decimals > 255 {
+		return ton.AccountID{}, fmt.Errorf("invalid jetton decimals (must be 0..255)")
+	}
+	master, err := ton.ParseAccountID(data.Address)
+	if err != nil {
+		return ton.AccountID{}, fmt.Errorf("invalid jetton address: %w", err)
+	}
+	return master, nil
+}
+
+func writeCurrency(w http.ResponseWriter, currency core.RegisteredCurrency) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(core.ConvertCurrencyToPrintable(currency))
+	if err != nil {
+		slog.Error("encode currency", "error", err)
+	}
+}
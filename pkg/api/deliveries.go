@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) getDeliveries(w http.ResponseWriter, r *http.Request) {
+	filter := core.DeliveryFilter{MerchantID: merchantFromContext(r.Context()).ID, Limit: 20}
+	query := r.URL.Query()
+	var err error
+	if limitQuery := query.Get("limit"); len(limitQuery) > 0 {
+		filter.Limit, err = strconv.ParseInt(limitQuery, 10, 64)
+		if err != nil {
+			writeHttpError(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if afterQuery := query.Get("after"); len(afterQuery) > 0 {
+		filter.After, err = uuid.Parse(afterQuery)
+		if err != nil {
+			writeHttpError(w, "invalid delivery ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if statusQuery := query.Get("status"); len(statusQuery) > 0 {
+		status, ok := core.ParseDeliveryStatus(statusQuery)
+		if !ok {
+			writeHttpError(w, "invalid status: "+statusQuery, http.StatusBadRequest)
+			return
+		}
+		filter.Status = &status
+	}
+	if senderQuery := query.Get("sender"); len(senderQuery) > 0 {
+		filter.Sender = &senderQuery
+	}
+	if webhookQuery := query.Get("webhook_id"); len(webhookQuery) > 0 {
+		webhookID, err := uuid.Parse(webhookQuery)
+		if err != nil {
+			writeHttpError(w, "invalid webhook ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.WebhookID = &webhookID
+	}
+	if eventQuery := query.Get("event_id"); len(eventQuery) > 0 {
+		eventID, err := uuid.Parse(eventQuery)
+		if err != nil {
+			writeHttpError(w, "invalid event ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.EventID = &eventID
+	}
+	deliveries, err := h.db.GetDeliveries(r.Context(), filter)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		Deliveries []core.DeliveryPrintable `json:"deliveries"`
+	}{
+		Deliveries: make([]core.DeliveryPrintable, 0, len(deliveries)),
+	}
+	for _, d := range deliveries {
+		res.Deliveries = append(res.Deliveries, core.ConvertDeliveryToPrintable(d, false, nil))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode deliveries", "error", err)
+	}
+}
+
+// getDelivery returns the delivery with the payload and the attempt log
+func (h *Handler) getDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	delivery, err := h.getMerchantDelivery(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeDelivery(w, r, delivery)
+}
+
+// resendDelivery schedules the delivery (usually dead one) for sending right now
+func (h *Handler) resendDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	_, err = h.getMerchantDelivery(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delivery, err := h.db.ResendDelivery(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeDelivery(w, r, delivery)
+}
+
+// getMerchantDelivery returns the delivery of the request merchant, deliveries of other merchants are not found
+func (h *Handler) getMerchantDelivery(ctx context.Context, id uuid.UUID) (core.Delivery, error) {
+	delivery, err := h.db.GetDelivery(ctx, id)
+	if err != nil {
+		return core.Delivery{}, err
+	}
+	if delivery.MerchantID != merchantFromContext(ctx).ID {
+		return core.Delivery{}, core.ErrNotFound
+	}
+	return delivery, nil
+}
+
+func (h *Handler) writeDelivery(w http.ResponseWriter, r *http.Request, delivery core.Delivery) {
+	attempts, err := h.db.GetDeliveryAttempts(r.Context(), delivery.ID)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(core.ConvertDeliveryToPrintable(delivery, true, attempts))
+	if err != nil {
+		slog.Error("encode delivery", "error", err)
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	streamPingInterval     = 15 * time.Second
+	streamReplayPage       = 100
+)
+
+// streamEvents streams notifications of the merchant from the event log. With Last-Event-ID header (or last_event_id query)
+// missed events are replayed first, otherwise only new events are sent.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		writeHttpError(w, "event streams are not supported", http.StatusNotImplemented)
+		return
+	}
+	var (
+		lastSeq int64
+		resume  bool
+		err     error
+	)
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if len(lastEventID) == 0 {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	if len(lastEventID) > 0 {
+		lastSeq, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			writeHttpError(w, "invalid last event ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		resume = true
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHttpError(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	merchantID := merchantFromContext(r.Context()).ID
+	// subscribe before the replay to not miss events saved in between
+	events, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+
+	writeStreamHeaders(w)
+	if resume {
+		for {
+			replay, err := h.db.GetEvents(r.Context(), merchantID, lastSeq, streamReplayPage)
+			if err != nil {
+				slog.Error("get events for replay", "error", err)
+				return
+			}
+			for _, event := range replay {
+				if err := writeStreamEvent(w, event.Seq, event.Type, event.Payload); err != nil {
+					return
+				}
+				lastSeq = event.Seq
+			}
+			if len(replay) < streamReplayPage {
+				break
+			}
+		}
+	}
+	flusher.Flush()
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return // the client is too slow or the server is stopping, it can reconnect with Last-Event-ID
+			}
+			if event.MerchantID != merchantID || (resume && event.Seq <= lastSeq) {
+				continue // another merchant or already replayed
+			}
+			if err := writeStreamEvent(w, event.Seq, event.Type, event.Payload); err != nil {
+				return
+			}
+			lastSeq = event.Seq
+			flusher.Flush()
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// streamInvoicePublic streams the public state of the invoice: the current one at once and then after every change.
+// The stream is closed after the invoice reaches a final status.
+func (h *Handler) streamInvoicePublic(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		writeHttpError(w, "event streams are not supported", http.StatusNotImplemented)
+		return
+	}
+	id, err := core.ParseInvoiceID(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHttpError(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+
+	invoice, err := h.db.GetInvoice(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, "invoice not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeStreamHeaders(w)
+	var seq int64
+	for {
+		res, err := core.ConvertInvoiceToPrintablePublic(h.paymentPrefixes, invoice, h.currencies.All(), h.adnlAddress)
+		if err != nil {
+			slog.Error("convert invoice to printable", "error", err)
+			return
+		}
+		payload, err := json.Marshal(res)
+		if err != nil {
+			slog.Error("encode invoice", "error", err)
+			return
+		}
+		if err := writeStreamEvent(w, seq, "invoice", payload); err != nil {
+			return
+		}
+		flusher.Flush()
+		if isFinalInvoiceStatus(invoice.Status) {
+			return
+		}
+		seq, err = h.waitInvoiceEvent(w, r, flusher, events, id)
+		if err != nil {
+			return
+		}
+		invoice, err = h.db.GetInvoice(r.Context(), id)
+		if err != nil {
+			slog.Error("get invoice for stream", "error", err)
+			return
+		}
+	}
+}
+
+// waitInvoiceEvent waits for the next event of the invoice keeping the connection alive
+func (h *Handler) waitInvoiceEvent(w http.ResponseWriter, r *http.Request, flusher http.Flusher, events <-chan core.Event, id core.InvoiceID) (int64, error) {
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return 0, r.Context().Err()
+		case event, ok := <-events:
+			if !ok {
+				return 0, errors.New("stream subscriber dropped")
+			}
+			if event.InvoiceID != nil && *event.InvoiceID == id {
+				return event.Seq, nil
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return 0, err
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func isFinalInvoiceStatus(status core.InvoiceStatus) bool {
+	return status == core.PaidInvoiceStatus || status == core.CanceledInvoiceStatus || status == core.ExpiredInvoiceStatus
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), eventStreamContentType)
+}
+
+func writeStreamHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disables buffering in nginx
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeStreamEvent writes SSE event, zero seq is omitted. The data is a single line JSON.
+func writeStreamEvent(w http.ResponseWriter, seq int64, eventType string, data []byte) error {
+	var b strings.Builder
+	if seq > 0 {
+		fmt.Fprintf(&b, "id: %d\n", seq)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", eventType, data)
+	_, err := fmt.Fprint(w, b.String())
+	return err
+}
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"context"
 	"crypto/ed25519"
 	"embed"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/tonkeeper/tongo/ton"
 	"github.com/tonkeeper/tongo/toncrypto"
 	"github.com/tonkeeper/tongo/wallet"
@@ -14,7 +16,9 @@ import (
 	"log/slog"
 	"math/big"
 	"net/http"
+	"net/mail"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,19 +29,29 @@ type Handler struct {
 	db               storage
 	adnlAddress      *ton.Bits256
 	paymentPrefixes  map[string]string
-	currencies       map[string]core.ExtendedCurrency
+	currencies       *core.CurrencyRegistry
+	tracker          currencyTracker
 	ourEncryptionKey ed25519.PrivateKey
 	domain           string
+	rates            rateProvider
+	events           eventSource
+	workers          workerReporter
 }
 
-func NewHandler(db storage, currencies map[string]core.ExtendedCurrency, adnlAddress *ton.Bits256, paymentPrefixes map[string]string, ourEncryptionKey ed25519.PrivateKey, domain string) *Handler {
+// NewHandler creates API handler. rates can be nil if fiat priced invoices are not supported,
+// events can be nil if event streams are not supported.
+func NewHandler(db storage, currencies *core.CurrencyRegistry, tracker currencyTracker, adnlAddress *ton.Bits256, paymentPrefixes map[string]string, ourEncryptionKey ed25519.PrivateKey, domain string, rates rateProvider, events eventSource, workers workerReporter) *Handler {
 	return &Handler{
 		db:               db,
 		currencies:       currencies,
+		tracker:          tracker,
 		adnlAddress:      adnlAddress,
 		paymentPrefixes:  paymentPrefixes,
 		ourEncryptionKey: ourEncryptionKey,
 		domain:           domain,
+		rates:            rates,
+		events:           events,
+		workers:          workers,
 	}
 }
 
@@ -47,6 +61,30 @@ type NewInvoice struct {
 	LifeTime    int64                      `json:"life_time"`
 	PrivateInfo map[string]json.RawMessage `json:"private_info,omitempty"`
 	Metadata    core.InvoiceMetadata       `json:"metadata"`
+	// ExternalReference can be also passed via Idempotency-Key header
+	ExternalReference string `json:"external_reference,omitempty"`
+	// BuyerEmail receives the receipt after the payment if the email sender is configured
+	BuyerEmail string `json:"buyer_email,omitempty"`
+	// Options are alternative currencies. If amount and currency are omitted, the first option is used instead.
+	Options []NewInvoiceOption `json:"options,omitempty"`
+	// FiatCurrency makes amount a decimal price in fiat currency (e.g. "12.50" USD).
+	// Amounts in crypto currencies are calculated by the current rate, options must not have amounts.
+	FiatCurrency string `json:"fiat_currency,omitempty"`
+}
+
+type NewInvoiceOption struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+const (
+	maxExternalReferenceLen = 128
+	maxBuyerEmailLen        = 254
+	maxFiatCurrencyLen      = 16
+)
+
+type AttachPayment struct {
+	InvoiceID string `json:"invoice_id"`
 }
 
 type NewKey struct {
@@ -66,22 +104,32 @@ func (h *Handler) createInvoice(w http.ResponseWriter, r *http.Request) {
 		writeHttpError(w, "invalid invoice data: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	recipient, err := h.db.GetRecipient(r.Context())
-	if err != nil {
-		writeHttpError(w, err.Error(), http.StatusInternalServerError)
-		return
+	if key := r.Header.Get("Idempotency-Key"); len(key) > 0 {
+		if len(data.ExternalReference) > 0 && data.ExternalReference != key {
+			writeHttpError(w, "external_reference and Idempotency-Key mismatch", http.StatusBadRequest)
+			return
+		}
+		data.ExternalReference = key
 	}
-	invoice, err := h.convertNewInvoice(data, recipient)
+	invoice, err := h.convertNewInvoice(r.Context(), data, merchantFromContext(r.Context()))
 	if err != nil {
 		writeHttpError(w, "invoice data parsing error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 	err = h.db.CreateInvoice(r.Context(), *invoice)
+	if err != nil && errors.Is(err, core.ErrAlreadyExists) {
+		// repeated request, return the original invoice
+		invoice, err = h.getOriginalInvoice(r.Context(), *invoice)
+		if err != nil && errors.Is(err, errReferenceConflict) {
+			writeHttpError(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
 	if err != nil {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	res, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, *invoice, h.currencies, h.adnlAddress)
+	res, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, *invoice, h.currencies.All(), h.adnlAddress)
 	if err != nil {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -93,13 +141,36 @@ func (h *Handler) createInvoice(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+var errReferenceConflict = errors.New("invoice with the same external reference has different amount or currency")
+
+// getOriginalInvoice returns the already created invoice of the same merchant with the same external reference
+func (h *Handler) getOriginalInvoice(ctx context.Context, invoice core.Invoice) (*core.Invoice, error) {
+	original, err := h.db.GetInvoiceByReference(ctx, invoice.Recipient, *invoice.ExternalReference)
+	if err != nil {
+		return nil, err
+	}
+	if !samePrice(original, invoice) {
+		return nil, errReferenceConflict
+	}
+	return &original, nil
+}
+
+// samePrice compares prices of the invoices. Amounts of fiat priced invoices depend on the rate so the fiat price is compared.
+func samePrice(a, b core.Invoice) bool {
+	if a.Fiat != nil || b.Fiat != nil {
+		return a.Fiat != nil && b.Fiat != nil && a.Currency == b.Currency &&
+			a.Fiat.Currency == b.Fiat.Currency && a.Fiat.Amount.Cmp(b.Fiat.Amount) == 0
+	}
+	return a.Currency == b.Currency && a.Amount.Cmp(b.Amount) == 0
+}
+
 func (h *Handler) getInvoice(w http.ResponseWriter, r *http.Request) {
 	id, err := core.ParseInvoiceID(r.PathValue("id"))
 	if err != nil {
 		writeHttpError(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	invoice, err := h.db.GetInvoice(r.Context(), id)
+	invoice, err := h.getMerchantInvoice(r.Context(), id)
 	if err != nil && errors.Is(err, core.ErrNotFound) {
 		writeHttpError(w, err.Error(), http.StatusNotFound)
 		return
@@ -107,7 +178,7 @@ func (h *Handler) getInvoice(w http.ResponseWriter, r *http.Request) {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	res, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, invoice, h.currencies, h.adnlAddress)
+	res, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, invoice, h.currencies.All(), h.adnlAddress)
 	if err != nil {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -119,13 +190,25 @@ func (h *Handler) getInvoice(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getMerchantInvoice returns the invoice of the request merchant, invoices of other merchants are not found
+func (h *Handler) getMerchantInvoice(ctx context.Context, id core.InvoiceID) (core.Invoice, error) {
+	invoice, err := h.db.GetInvoice(ctx, id)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	if invoice.Recipient != merchantFromContext(ctx).Recipient {
+		return core.Invoice{}, core.ErrNotFound
+	}
+	return invoice, nil
+}
+
 func (h *Handler) cancelInvoice(w http.ResponseWriter, r *http.Request) {
 	id, err := core.ParseInvoiceID(r.PathValue("id"))
 	if err != nil {
 		writeHttpError(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	invoice, err := h.db.CancelInvoice(r.Context(), id)
+	invoice, err := h.db.CancelInvoice(r.Context(), merchantFromContext(r.Context()).Recipient, id)
 	if err != nil && errors.Is(err, core.ErrNotFound) {
 		writeHttpError(w, "no waiting payment invoice found", http.StatusNotFound)
 		return
@@ -133,7 +216,7 @@ func (h *Handler) cancelInvoice(w http.ResponseWriter, r *http.Request) {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	res, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, invoice, h.currencies, h.adnlAddress)
+	res, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, invoice, h.currencies.All(), h.adnlAddress)
 	if err != nil {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -223,9 +306,187 @@ func (h *Handler) getEncryptedData(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) getInvoiceHistory(w http.ResponseWriter, r *http.Request) {
+	filter, err := h.parseInvoiceFilter(r)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recipient := merchantFromContext(r.Context()).Recipient
+	filter.Recipient = &recipient
+	if filter.After != (core.InvoiceID{}) {
+		_, err = h.getMerchantInvoice(r.Context(), filter.After)
+		if err != nil && errors.Is(err, core.ErrNotFound) {
+			writeHttpError(w, "unknown invoice ID", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			writeHttpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	invoices, total, err := h.db.GetInvoices(r.Context(), filter)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		Invoices []core.PrivateInvoicePrintable `json:"invoices"`
+		Total    int64                          `json:"total"`
+	}{
+		Invoices: make([]core.PrivateInvoicePrintable, 0),
+		Total:    total,
+	}
+	for _, inv := range invoices {
+		invoice, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, inv, h.currencies.All(), h.adnlAddress)
+		if err != nil {
+			writeHttpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Invoices = append(res.Invoices, invoice)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode invoices", "error", err)
+	}
+}
+
+func (h *Handler) parseInvoiceFilter(r *http.Request) (core.InvoiceFilter, error) {
+	var (
+		query  = r.URL.Query()
+		filter = core.InvoiceFilter{Limit: 20} // after = empty ID
+		err    error
+	)
+	if limitQuery := query.Get("limit"); len(limitQuery) > 0 {
+		filter.Limit, err = strconv.ParseInt(limitQuery, 10, 64)
+		if err != nil {
+			return core.InvoiceFilter{}, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	if afterQuery := query.Get("after"); len(afterQuery) > 0 {
+		filter.After, err = core.ParseInvoiceID(afterQuery)
+		if err != nil {
+			return core.InvoiceFilter{}, fmt.Errorf("invalid invoice ID: %w", err)
+		}
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return core.InvoiceFilter{}, fmt.Errorf("invalid order: %s", order)
+	}
+	if statusQuery := query.Get("status"); len(statusQuery) > 0 {
+		for _, s := range strings.Split(statusQuery, ",") {
+			status := core.InvoiceStatus(s)
+			switch status {
+			case core.WaitingInvoiceStatus, core.PartiallyPaidInvoiceStatus, core.PaidInvoiceStatus,
+				core.CanceledInvoiceStatus, core.ExpiredInvoiceStatus:
+				filter.Statuses = append(filter.Statuses, status)
+			default:
+				return core.InvoiceFilter{}, fmt.Errorf("invalid status: %s", s)
+			}
+		}
+	}
+	if currencyQuery := query.Get("currency"); len(currencyQuery) > 0 {
+		cur, ok := h.currencies.Lookup(currencyQuery)
+		if !ok {
+			return core.InvoiceFilter{}, fmt.Errorf("currency ticker %s not found", currencyQuery)
+		}
+		filter.Currency = &cur.Currency
+	}
+	for name, dst := range map[string]**time.Time{
+		"created_from": &filter.CreatedFrom,
+		"created_to":   &filter.CreatedTo,
+		"paid_from":    &filter.PaidFrom,
+		"paid_to":      &filter.PaidTo,
+	} {
+		timeQuery := query.Get(name)
+		if len(timeQuery) == 0 {
+			continue
+		}
+		unix, err := strconv.ParseInt(timeQuery, 10, 64)
+		if err != nil {
+			return core.InvoiceFilter{}, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		t := time.Unix(unix, 0)
+		*dst = &t
+	}
+	if paidByQuery := query.Get("paid_by"); len(paidByQuery) > 0 {
+		paidBy, err := ton.ParseAccountID(paidByQuery)
+		if err != nil {
+			return core.InvoiceFilter{}, fmt.Errorf("invalid paid_by: %w", err)
+		}
+		filter.PaidBy = &paidBy
+	}
+	if privateInfoQuery := query.Get("private_info"); len(privateInfoQuery) > 0 {
+		err = json.Unmarshal([]byte(privateInfoQuery), &filter.PrivateInfo)
+		if err != nil {
+			return core.InvoiceFilter{}, fmt.Errorf("invalid private_info: %w", err)
+		}
+	}
+	if referenceQuery := query.Get("external_reference"); len(referenceQuery) > 0 {
+		filter.ExternalReference = &referenceQuery
+	}
+	if subscriptionQuery := query.Get("subscription_id"); len(subscriptionQuery) > 0 {
+		subscriptionID, err := uuid.Parse(subscriptionQuery)
+		if err != nil {
+			return core.InvoiceFilter{}, fmt.Errorf("invalid subscription_id: %w", err)
+		}
+		filter.SubscriptionID = &subscriptionID
+	}
+	if requestQuery := query.Get("payment_request_id"); len(requestQuery) > 0 {
+		requestID, err := uuid.Parse(requestQuery)
+		if err != nil {
+			return core.InvoiceFilter{}, fmt.Errorf("invalid payment_request_id: %w", err)
+		}
+		filter.PaymentRequestID = &requestID
+	}
+	return filter, nil
+}
+
+func (h *Handler) getInvoicePayments(w http.ResponseWriter, r *http.Request) {
+	id, err := core.ParseInvoiceID(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	_, err = h.getMerchantInvoice(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	payments, err := h.db.GetInvoicePayments(r.Context(), id)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		Payments []core.PaymentPrintable `json:"payments"`
+	}{
+		Payments: make([]core.PaymentPrintable, 0, len(payments)),
+	}
+	for _, p := range payments {
+		payment, err := core.ConvertPaymentToPrintable(p, h.currencies.All())
+		if err != nil {
+			writeHttpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Payments = append(res.Payments, payment)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode payments", "error", err)
+	}
+}
+
+func (h *Handler) getUnmatchedPayments(w http.ResponseWriter, r *http.Request) {
 	var (
-		limit int64          = 20
-		after core.InvoiceID // empty ID
+		limit int64     = 20
+		after uuid.UUID // empty ID
 		err   error
 	)
 	if limitQuery := r.URL.Query().Get("limit"); len(limitQuery) > 0 {
@@ -236,43 +497,75 @@ func (h *Handler) getInvoiceHistory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if afterQuery := r.URL.Query().Get("after"); len(afterQuery) > 0 {
-		id, err := core.ParseInvoiceID(afterQuery)
+		after, err = uuid.Parse(afterQuery)
 		if err != nil {
-			writeHttpError(w, "invalid invoice ID: "+err.Error(), http.StatusBadRequest)
+			writeHttpError(w, "invalid payment ID: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		_, err = h.db.GetInvoice(r.Context(), id)
-		if err != nil && errors.Is(err, core.ErrNotFound) {
-			writeHttpError(w, "unknown invoice ID", http.StatusBadRequest)
-			return
-		} else if err != nil {
-			writeHttpError(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		after = id
-	} // else: after = empty ID
-	invoices, err := h.db.GetInvoices(r.Context(), after, limit)
+	}
+	payments, err := h.db.GetUnmatchedPayments(r.Context(), merchantFromContext(r.Context()).Recipient, after, limit)
 	if err != nil {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	res := struct {
-		Invoices []core.PrivateInvoicePrintable `json:"invoices"`
+		Payments []core.UnmatchedPaymentPrintable `json:"payments"`
 	}{
-		Invoices: make([]core.PrivateInvoicePrintable, 0),
+		Payments: make([]core.UnmatchedPaymentPrintable, 0, len(payments)),
 	}
-	for _, inv := range invoices {
-		invoice, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, inv, h.currencies, h.adnlAddress)
+	for _, p := range payments {
+		payment, err := core.ConvertUnmatchedPaymentToPrintable(p, h.currencies.All())
 		if err != nil {
 			writeHttpError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		res.Invoices = append(res.Invoices, invoice)
+		res.Payments = append(res.Payments, payment)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(res)
 	if err != nil {
-		slog.Error("encode invoices", "error", err)
+		slog.Error("encode payments", "error", err)
+	}
+}
+
+func (h *Handler) attachUnmatchedPayment(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data AttachPayment
+	err = json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid attach data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	invoiceID, err := core.ParseInvoiceID(data.InvoiceID)
+	if err != nil {
+		writeHttpError(w, "invalid invoice ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	invoice, err := h.db.AttachUnmatchedPayment(r.Context(), merchantFromContext(r.Context()).Recipient, id, invoiceID)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := core.ConvertInvoiceToPrintablePrivate(h.paymentPrefixes, invoice, h.currencies.All(), h.adnlAddress)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode invoice", "error", err)
 	}
 }
 
@@ -290,7 +583,7 @@ func (h *Handler) getInvoicePublic(w http.ResponseWriter, r *http.Request) {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	res, err := core.ConvertInvoiceToPrintablePublic(h.paymentPrefixes, invoice, h.currencies, h.adnlAddress)
+	res, err := core.ConvertInvoiceToPrintablePublic(h.paymentPrefixes, invoice, h.currencies.All(), h.adnlAddress)
 	if err != nil {
 		writeHttpError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -328,15 +621,56 @@ func handleStatic(h http.Handler) http.Handler {
 }
 
 func (h *Handler) getInvoiceRender(w http.ResponseWriter, r *http.Request) {
+	if acceptsEventStream(r) {
+		h.streamInvoicePublic(w, r)
+		return
+	}
 	http.ServeFileFS(w, r, staticFiles, "static/index.html")
 }
 
+// RegisterHandlers registers API endpoints. The token is the admin token of the default merchant,
+// other merchants use their own tokens.
 func RegisterHandlers(mux *http.ServeMux, h *Handler, token string) {
+	auth := authenticator{adminToken: token, db: h.db}
 	// private endpoints
-	mux.HandleFunc("POST /tonpay/private/api/v1/invoice", recoverMiddleware(authMiddleware(h.createInvoice, token)))
-	mux.HandleFunc("GET /tonpay/private/api/v1/invoices", recoverMiddleware(authMiddleware(h.getInvoiceHistory, token)))
-	mux.HandleFunc("GET /tonpay/private/api/v1/invoices/{id}", recoverMiddleware(authMiddleware(h.getInvoice, token)))
-	mux.HandleFunc("POST /tonpay/private/api/v1/invoices/{id}/cancel", recoverMiddleware(authMiddleware(h.cancelInvoice, token)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/invoice", recoverMiddleware(authMiddleware(h.createInvoice, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/invoices", recoverMiddleware(authMiddleware(h.getInvoiceHistory, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/invoices/batch", recoverMiddleware(authMiddleware(h.createInvoices, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/invoices/batch/cancel", recoverMiddleware(authMiddleware(h.cancelInvoices, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/invoices/{id}", recoverMiddleware(authMiddleware(h.getInvoice, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/invoices/{id}/cancel", recoverMiddleware(authMiddleware(h.cancelInvoice, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/invoices/{id}/payments", recoverMiddleware(authMiddleware(h.getInvoicePayments, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/subscriptions", recoverMiddleware(authMiddleware(h.createSubscription, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/subscriptions", recoverMiddleware(authMiddleware(h.getSubscriptions, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/subscriptions/{id}", recoverMiddleware(authMiddleware(h.getSubscription, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/subscriptions/{id}/cancel", recoverMiddleware(authMiddleware(h.cancelSubscription, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/payment-requests", recoverMiddleware(authMiddleware(h.createPaymentRequest, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/payment-requests", recoverMiddleware(authMiddleware(h.getPaymentRequests, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/payment-requests/{id}", recoverMiddleware(authMiddleware(h.getPaymentRequest, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/payment-requests/{id}/disable", recoverMiddleware(authMiddleware(h.disablePaymentRequest, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/webhooks", recoverMiddleware(authMiddleware(h.createWebhook, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/webhooks", recoverMiddleware(authMiddleware(h.getWebhooks, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/webhooks/{id}", recoverMiddleware(authMiddleware(h.getWebhook, auth)))
+	mux.HandleFunc("PUT /tonpay/private/api/v1/webhooks/{id}", recoverMiddleware(authMiddleware(h.updateWebhook, auth)))
+	mux.HandleFunc("DELETE /tonpay/private/api/v1/webhooks/{id}", recoverMiddleware(authMiddleware(h.deleteWebhook, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/deliveries", recoverMiddleware(authMiddleware(h.getDeliveries, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/deliveries/{id}", recoverMiddleware(authMiddleware(h.getDelivery, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/deliveries/{id}/resend", recoverMiddleware(authMiddleware(h.resendDelivery, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/events", recoverMiddleware(authMiddleware(h.streamEvents, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/currencies", recoverMiddleware(authMiddleware(h.getCurrencies, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/currencies", recoverMiddleware(adminMiddleware(h.addCurrency, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/currencies/{ticker}/disable", recoverMiddleware(adminMiddleware(h.disableCurrency, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/indexer/workers", recoverMiddleware(adminMiddleware(h.getWorkers, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/merchant", recoverMiddleware(authMiddleware(h.getCurrentMerchant, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/merchants", recoverMiddleware(adminMiddleware(h.createMerchant, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/merchants", recoverMiddleware(adminMiddleware(h.getMerchants, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/merchants/{id}", recoverMiddleware(adminMiddleware(h.getMerchant, auth)))
+	mux.HandleFunc("PUT /tonpay/private/api/v1/merchants/{id}", recoverMiddleware(adminMiddleware(h.updateMerchant, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/merchants/{id}/tokens", recoverMiddleware(adminMiddleware(h.createMerchantToken, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/merchants/{id}/tokens", recoverMiddleware(adminMiddleware(h.getMerchantTokens, auth)))
+	mux.HandleFunc("DELETE /tonpay/private/api/v1/merchants/{id}/tokens/{token_id}", recoverMiddleware(adminMiddleware(h.deleteMerchantToken, auth)))
+	mux.HandleFunc("GET /tonpay/private/api/v1/payments/unmatched", recoverMiddleware(authMiddleware(h.getUnmatchedPayments, auth)))
+	mux.HandleFunc("POST /tonpay/private/api/v1/payments/unmatched/{id}/attach", recoverMiddleware(authMiddleware(h.attachUnmatchedPayment, auth)))
 	// public endpoints
 	mux.HandleFunc("GET /tonpay/public/api/v1/invoices/{id}/metadata", recoverMiddleware(h.getEncryptedData))
 	mux.HandleFunc("POST /tonpay/public/api/v1/keys/{account}/commit", recoverMiddleware(h.commitKey))
@@ -346,42 +680,138 @@ func RegisterHandlers(mux *http.ServeMux, h *Handler, token string) {
 	mux.HandleFunc("GET /tonpay/public/invoice/{id}", recoverMiddleware(h.getInvoiceRender))
 }
 
-func (h *Handler) convertNewInvoice(newInvoice NewInvoice, recipient ton.AccountID) (*core.Invoice, error) {
-	amount, ok := new(big.Int).SetString(newInvoice.Amount, 10)
-	if !ok {
-		return nil, errors.New("can not parse amount string")
+func (h *Handler) convertNewInvoice(ctx context.Context, newInvoice NewInvoice, merchant core.Merchant) (*core.Invoice, error) {
+	if len(newInvoice.Amount) == 0 && len(newInvoice.Currency) == 0 && len(newInvoice.Options) > 0 {
+		newInvoice.Amount = newInvoice.Options[0].Amount
+		newInvoice.Currency = newInvoice.Options[0].Currency
+		newInvoice.Options = newInvoice.Options[1:]
 	}
-	if amount.Cmp(big.NewInt(0)) != 1 {
-		return nil, errors.New("amount must be positive integer")
+	var (
+		fiat *core.FiatPrice
+		err  error
+	)
+	if len(newInvoice.FiatCurrency) > 0 {
+		fiat, err = h.convertFiatAmount(newInvoice.Amount, newInvoice.FiatCurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	amount, cur, rate, err := h.convertInvoiceAmount(ctx, merchant, newInvoice.Amount, newInvoice.Currency, fiat)
+	if err != nil {
+		return nil, err
+	}
+	if fiat != nil {
+		fiat.Rate = rate
 	}
 	if newInvoice.LifeTime <= 0 {
 		return nil, errors.New("life time must be positive integer")
 	}
-	now := time.Now()
-	cur, ok := h.currencies[newInvoice.Currency]
-	if !ok {
-		return nil, fmt.Errorf("currency ticker %s not found", newInvoice.Currency)
+	options := make([]core.InvoiceOption, 0, len(newInvoice.Options))
+	usedCurrencies := map[core.Currency]struct{}{cur: {}}
+	for _, o := range newInvoice.Options {
+		if fiat != nil && len(o.Amount) > 0 {
+			return nil, errors.New("option amount must be empty for fiat priced invoice")
+		}
+		optionAmount, optionCur, optionRate, err := h.convertInvoiceAmount(ctx, merchant, o.Amount, o.Currency, fiat)
+		if err != nil {
+			return nil, fmt.Errorf("option: %w", err)
+		}
+		if _, ok := usedCurrencies[optionCur]; ok {
+			return nil, fmt.Errorf("duplicated currency %s", o.Currency)
+		}
+		usedCurrencies[optionCur] = struct{}{}
+		options = append(options, core.InvoiceOption{
+			Currency: optionCur,
+			Amount:   optionAmount,
+			Received: big.NewInt(0),
+			Rate:     optionRate,
+		})
 	}
+	now := time.Now()
 	res := core.Invoice{
 		ID:          core.NewInvoiceID(),
 		Status:      core.WaitingInvoiceStatus,
 		Amount:      amount,
-		Currency:    cur.Currency,
+		Currency:    cur,
 		CreatedAt:   now,
 		ExpireAt:    now.Add(time.Second * time.Duration(newInvoice.LifeTime)),
 		PrivateInfo: newInvoice.PrivateInfo,
 		UpdatedAt:   now,
 		Overpayment: big.NewInt(0),
-		Recipient:   recipient,
+		Received:    big.NewInt(0),
+		Recipient:   merchant.Recipient,
+		Options:     options,
+		Fiat:        fiat,
+	}
+	res.Metadata, err = convertMetadata(newInvoice.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(newInvoice.ExternalReference) > maxExternalReferenceLen {
+		return nil, fmt.Errorf("external reference must be at most %d characters", maxExternalReferenceLen)
+	}
+	if len(newInvoice.ExternalReference) > 0 {
+		res.ExternalReference = &newInvoice.ExternalReference
+	}
+	if len(newInvoice.BuyerEmail) > 0 {
+		if err := validateEmail(newInvoice.BuyerEmail); err != nil {
+			return nil, err
+		}
+		res.BuyerEmail = &newInvoice.BuyerEmail
+	}
+	if res.PrivateInfo == nil {
+		res.PrivateInfo = make(map[string]json.RawMessage)
+	}
+	return &res, nil
+}
+
+// convertInvoiceAmount returns amount in currency units. For fiat priced invoice amount is calculated by the current rate.
+func (h *Handler) convertInvoiceAmount(ctx context.Context, merchant core.Merchant, amountS, ticker string, fiat *core.FiatPrice) (*big.Int, core.Currency, *big.Rat, error) {
+	if fiat == nil {
+		amount, cur, err := h.convertAmount(merchant, amountS, ticker)
+		return amount, cur, nil, err
+	}
+	cur, err := h.acceptedCurrency(merchant, ticker)
+	if err != nil {
+		return nil, core.Currency{}, nil, err
+	}
+	rate, err := h.rates.GetRate(ctx, fiat.Currency, ticker)
+	if err != nil {
+		return nil, core.Currency{}, nil, fmt.Errorf("get rate: %w", err)
+	}
+	amount := core.ConvertFiatAmount(fiat.Amount, rate, cur.Decimals())
+	if amount.Sign() != 1 {
+		return nil, core.Currency{}, nil, errors.New("amount must be positive")
+	}
+	return amount, cur.Currency, rate, nil
+}
+
+func (h *Handler) convertFiatAmount(amountS, fiatCurrency string) (*core.FiatPrice, error) {
+	if h.rates == nil {
+		return nil, errors.New("fiat priced invoices are not supported: rate provider is not configured")
+	}
+	if len(fiatCurrency) > maxFiatCurrencyLen {
+		return nil, fmt.Errorf("fiat currency must be at most %d characters", maxFiatCurrencyLen)
 	}
-	if newInvoice.Metadata.Goods == nil {
-		newInvoice.Metadata.Goods = make([]core.InvoiceItem, 0)
+	amount, ok := new(big.Rat).SetString(amountS)
+	if !ok || strings.ContainsAny(amountS, "/eE") {
+		return nil, errors.New("can not parse fiat amount string")
 	}
-	err := validateMetadata(newInvoice.Metadata)
+	if amount.Sign() != 1 {
+		return nil, errors.New("amount must be positive")
+	}
+	return &core.FiatPrice{Currency: fiatCurrency, Amount: amount}, nil
+}
+
+func convertMetadata(metadata core.InvoiceMetadata) (map[string]json.RawMessage, error) {
+	if metadata.Goods == nil {
+		metadata.Goods = make([]core.InvoiceItem, 0)
+	}
+	err := validateMetadata(metadata)
 	if err != nil {
 		return nil, fmt.Errorf("metadata validation: %w", err)
 	}
-	metaBytes, err := json.Marshal(newInvoice.Metadata)
+	metaBytes, err := json.Marshal(metadata)
 	if err != nil {
 		return nil, fmt.Errorf("can not marshal metadata: %w", err)
 	}
@@ -390,11 +820,34 @@ func (h *Handler) convertNewInvoice(newInvoice NewInvoice, recipient ton.Account
 	if err != nil {
 		return nil, fmt.Errorf("can not unmarshal metadata: %w", err)
 	}
-	res.Metadata = raw
-	if res.PrivateInfo == nil {
-		res.PrivateInfo = make(map[string]json.RawMessage)
+	return raw, nil
+}
+
+func (h *Handler) convertAmount(merchant core.Merchant, amountS, ticker string) (*big.Int, core.Currency, error) {
+	amount, ok := new(big.Int).SetString(amountS, 10)
+	if !ok {
+		return nil, core.Currency{}, errors.New("can not parse amount string")
 	}
-	return &res, nil
+	if amount.Cmp(big.NewInt(0)) != 1 {
+		return nil, core.Currency{}, errors.New("amount must be positive integer")
+	}
+	cur, err := h.acceptedCurrency(merchant, ticker)
+	if err != nil {
+		return nil, core.Currency{}, err
+	}
+	return amount, cur.Currency, nil
+}
+
+// acceptedCurrency returns the enabled currency if the merchant accepts it
+func (h *Handler) acceptedCurrency(merchant core.Merchant, ticker string) (core.ExtendedCurrency, error) {
+	cur, ok := h.currencies.Get(ticker)
+	if !ok {
+		return core.ExtendedCurrency{}, fmt.Errorf("currency ticker %s not found or disabled", ticker)
+	}
+	if !merchant.Accepts(ticker) {
+		return core.ExtendedCurrency{}, fmt.Errorf("currency %s is not accepted by the merchant", ticker)
+	}
+	return cur, nil
 }
 
 func convertNewKey(account ton.AccountID, newKey NewKey) ([]byte, error) {
@@ -444,6 +897,18 @@ func validateMetadata(meta core.InvoiceMetadata) error {
 	return nil
 }
 
+// validateEmail accepts only a bare address like buyer@example.com
+func validateEmail(email string) error {
+	if len(email) > maxBuyerEmailLen {
+		return fmt.Errorf("buyer email must be at most %d characters", maxBuyerEmailLen)
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return fmt.Errorf("invalid buyer email: %s", email)
+	}
+	return nil
+}
+
 func encryptData(receiverPubkey []byte, data []byte, ourEncryptionKey ed25519.PrivateKey) ([]byte, error) {
 	acc := ton.MustParseAccountID("0:0") // TODO: clarify salt
 	salt := []byte(acc.ToHuman(true, false))
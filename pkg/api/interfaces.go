@@ -2,16 +2,71 @@ package api
 
 import (
 	"context"
+	"github.com/google/uuid"
 	"github.com/tonkeeper/tongo/ton"
 	"github.com/txsociety/spice-harvester/pkg/core"
+	"math/big"
 )
 
 type storage interface {
 	CreateInvoice(ctx context.Context, newInvoice core.Invoice) error
+	CreateInvoices(ctx context.Context, invoices []core.Invoice) ([]error, error)
 	GetInvoice(ctx context.Context, id core.InvoiceID) (core.Invoice, error)
-	CancelInvoice(ctx context.Context, id core.InvoiceID) (core.Invoice, error)
+	GetInvoiceByReference(ctx context.Context, recipient ton.AccountID, reference string) (core.Invoice, error)
+	CancelInvoice(ctx context.Context, recipient ton.AccountID, id core.InvoiceID) (core.Invoice, error)
+	CancelInvoices(ctx context.Context, recipient ton.AccountID, ids []core.InvoiceID) ([]core.Invoice, []error, error)
 	SaveEncryptionKey(ctx context.Context, account ton.AccountID, encryptionKey []byte) error
 	GetEncryptionKey(ctx context.Context, account ton.AccountID) ([]byte, error)
-	GetInvoices(ctx context.Context, after core.InvoiceID, limit int64) ([]core.Invoice, error)
-	GetRecipient(ctx context.Context) (ton.AccountID, error)
+	GetInvoices(ctx context.Context, filter core.InvoiceFilter) ([]core.Invoice, int64, error)
+	GetInvoicePayments(ctx context.Context, invoiceID core.InvoiceID) ([]core.Payment, error)
+	GetUnmatchedPayments(ctx context.Context, recipient ton.AccountID, after uuid.UUID, limit int64) ([]core.UnmatchedPayment, error)
+	AttachUnmatchedPayment(ctx context.Context, recipient ton.AccountID, id uuid.UUID, invoiceID core.InvoiceID) (core.Invoice, error)
+	CreateSubscription(ctx context.Context, s core.Subscription) error
+	GetSubscription(ctx context.Context, id uuid.UUID) (core.Subscription, error)
+	GetSubscriptions(ctx context.Context, recipient ton.AccountID, after uuid.UUID, limit int64) ([]core.Subscription, error)
+	CancelSubscription(ctx context.Context, recipient ton.AccountID, id uuid.UUID) (core.Subscription, error)
+	CreatePaymentRequest(ctx context.Context, r core.PaymentRequest) error
+	GetPaymentRequest(ctx context.Context, id uuid.UUID) (core.PaymentRequest, error)
+	GetPaymentRequests(ctx context.Context, recipient ton.AccountID, after uuid.UUID, limit int64) ([]core.PaymentRequest, error)
+	DisablePaymentRequest(ctx context.Context, recipient ton.AccountID, id uuid.UUID) (core.PaymentRequest, error)
+	CreateWebhook(ctx context.Context, w core.Webhook) error
+	GetWebhook(ctx context.Context, id uuid.UUID) (core.Webhook, error)
+	GetMerchantWebhooks(ctx context.Context, merchantID uuid.UUID) ([]core.Webhook, error)
+	UpdateWebhook(ctx context.Context, w core.Webhook) error
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	GetDeliveries(ctx context.Context, filter core.DeliveryFilter) ([]core.Delivery, error)
+	GetDelivery(ctx context.Context, id uuid.UUID) (core.Delivery, error)
+	GetDeliveryAttempts(ctx context.Context, deliveryID uuid.UUID) ([]core.DeliveryAttempt, error)
+	ResendDelivery(ctx context.Context, id uuid.UUID) (core.Delivery, error)
+	GetEvents(ctx context.Context, merchantID uuid.UUID, afterSeq int64, limit int) ([]core.Event, error)
+	merchantStorage
+}
+
+type merchantStorage interface {
+	CreateMerchant(ctx context.Context, m core.Merchant) error
+	GetMerchant(ctx context.Context, id uuid.UUID) (core.Merchant, error)
+	GetMerchants(ctx context.Context) ([]core.Merchant, error)
+	UpdateMerchant(ctx context.Context, m core.Merchant) error
+	CreateMerchantToken(ctx context.Context, t core.MerchantToken) error
+	GetMerchantTokens(ctx context.Context, merchantID uuid.UUID) ([]core.MerchantToken, error)
+	DeleteMerchantToken(ctx context.Context, merchantID, id uuid.UUID) error
+	GetMerchantByToken(ctx context.Context, hash []byte) (core.Merchant, error)
+}
+
+type rateProvider interface {
+	GetRate(ctx context.Context, fiat, ticker string) (*big.Rat, error)
+}
+
+type currencyTracker interface {
+	AddJetton(ctx context.Context, ticker string, master ton.AccountID, decimals int) (core.RegisteredCurrency, error)
+	DisableCurrency(ctx context.Context, ticker string) (core.RegisteredCurrency, error)
+	TrackMerchant(ctx context.Context, merchant core.Merchant) error
+}
+
+type workerReporter interface {
+	WorkerStates() []core.WorkerState
+}
+
+type eventSource interface {
+	Subscribe() (<-chan core.Event, func())
 }
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	maxMerchantNameLen = 128
+	maxTokenNameLen    = 128
+)
+
+type NewMerchant struct {
+	Name string `json:"name"`
+	// Recipient is the wallet which receives payments of the merchant, it can not be changed after creation
+	Recipient  string   `json:"recipient"`
+	Currencies []string `json:"currencies,omitempty"` // all currencies if empty
+}
+
+type NewMerchantToken struct {
+	Name string `json:"name"`
+}
+
+func (h *Handler) getCurrentMerchant(w http.ResponseWriter, r *http.Request) {
+	writeMerchant(w, merchantFromContext(r.Context()))
+}
+
+// createMerchant creates the merchant and starts tracking accounts of its recipient
+func (h *Handler) createMerchant(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data NewMerchant
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid merchant data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	recipient, err := ton.ParseAccountID(data.Recipient)
+	if err != nil {
+		writeHttpError(w, "merchant data parsing error: invalid recipient: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	merchant := core.Merchant{ID: id, Recipient: recipient, CreatedAt: now}
+	err = h.applyNewMerchant(&merchant, data, now)
+	if err != nil {
+		writeHttpError(w, "merchant data parsing error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.db.CreateMerchant(r.Context(), merchant)
+	if err != nil && errors.Is(err, core.ErrAlreadyExists) {
+		writeHttpError(w, "recipient is used by another merchant", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = h.tracker.TrackMerchant(r.Context(), merchant)
+	if err != nil {
+		writeHttpError(w, "merchant is created, but its accounts are not tracked: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeMerchant(w, merchant)
+}
+
+func (h *Handler) getMerchants(w http.ResponseWriter, r *http.Request) {
+	merchants, err := h.db.GetMerchants(r.Context())
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		Merchants []core.MerchantPrintable `json:"merchants"`
+	}{
+		Merchants: make([]core.MerchantPrintable, 0, len(merchants)),
+	}
+	for _, m := range merchants {
+		res.Merchants = append(res.Merchants, core.ConvertMerchantToPrintable(m))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode merchants", "error", err)
+	}
+}
+
+func (h *Handler) getMerchant(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	merchant, err := h.db.GetMerchant(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeMerchant(w, merchant)
+}
+
+// updateMerchant replaces the name and currencies of the merchant. Jetton wallets of currencies which are
+// no longer accepted are not tracked anymore.
+func (h *Handler) updateMerchant(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data NewMerchant
+	err = json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid merchant data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	merchant, err := h.db.GetMerchant(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(data.Recipient) > 0 {
+		recipient, err := ton.ParseAccountID(data.Recipient)
+		if err != nil || recipient != merchant.Recipient {
+			writeHttpError(w, "recipient can not be changed", http.StatusBadRequest)
+			return
+		}
+	}
+	err = h.applyNewMerchant(&merchant, data, time.Now())
+	if err != nil {
+		writeHttpError(w, "merchant data parsing error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.db.UpdateMerchant(r.Context(), merchant)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = h.tracker.TrackMerchant(r.Context(), merchant)
+	if err != nil {
+		writeHttpError(w, "merchant is updated, but its accounts are not tracked: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeMerchant(w, merchant)
+}
+
+// createMerchantToken issues a new API token of the merchant. The token is returned only in this response.
+func (h *Handler) createMerchantToken(w http.ResponseWriter, r *http.Request) {
+	merchantID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var data NewMerchantToken
+	if r.Body != nil {
+		err = json.NewDecoder(r.Body).Decode(&data)
+		if err != nil {
+			writeHttpError(w, "invalid token data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(data.Name) > maxTokenNameLen {
+		writeHttpError(w, fmt.Sprintf("token name must be at most %d characters", maxTokenNameLen), http.StatusBadRequest)
+		return
+	}
+	_, err = h.db.GetMerchant(r.Context(), merchantID)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t, token, err := core.NewMerchantToken(merchantID, data.Name)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = h.db.CreateMerchantToken(r.Context(), t)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(core.ConvertMerchantTokenToPrintable(t, token))
+	if err != nil {
+		slog.Error("encode token", "error", err)
+	}
+}
+
+func (h *Handler) getMerchantTokens(w http.ResponseWriter, r *http.Request) {
+	merchantID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	_, err = h.db.GetMerchant(r.Context(), merchantID)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tokens, err := h.db.GetMerchantTokens(r.Context(), merchantID)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		Tokens []core.MerchantTokenPrintable `json:"tokens"`
+	}{
+		Tokens: make([]core.MerchantTokenPrintable, 0, len(tokens)),
+	}
+	for _, t := range tokens {
+		res.Tokens = append(res.Tokens, core.ConvertMerchantTokenToPrintable(t, ""))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode tokens", "error", err)
+	}
+}
+
+// deleteMerchantToken revokes the token
+func (h *Handler) deleteMerchantToken(w http.ResponseWriter, r *http.Request) {
+	merchantID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("token_id"))
+	if err != nil {
+		writeHttpError(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+	err = h.db.DeleteMerchantToken(r.Context(), merchantID, id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeMerchant(w http.ResponseWriter, merchant core.Merchant) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(core.ConvertMerchantToPrintable(merchant))
+	if err != nil {
+		slog.Error("encode merchant", "error", err)
+	}
+}
+
+// applyNewMerchant validates and applies the name and currencies of the merchant
+func (h *Handler) applyNewMerchant(merchant *core.Merchant, data NewMerchant, now time.Time) error {
+	if len(data.Name) == 0 || len(data.Name) > maxMerchantNameLen {
+		return fmt.Errorf("name must be 1..%d characters", maxMerchantNameLen)
+	}
+	unique := make(map[string]struct{}, len(data.Currencies))
+	for _, ticker := range data.Currencies {
+		if _, ok := h.currencies.Lookup(ticker); !ok {
+			return fmt.Errorf("currency ticker %s not found", ticker)
+		}
+		if _, ok := unique[ticker]; ok {
+			return fmt.Errorf("duplicated currency %s", ticker)
+		}
+		unique[ticker] = struct{}{}
+	}
+	merchant.Name = data.Name
+	merchant.Currencies = data.Currencies
+	merchant.UpdatedAt = now
+	return nil
+}
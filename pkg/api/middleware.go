@@ -1,8 +1,11 @@
 package api
 
 import (
+	"context"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"github.com/txsociety/spice-harvester/pkg/core"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
@@ -21,25 +24,87 @@ func recoverMiddleware(next func(http.ResponseWriter, *http.Request)) func(http.
 	}
 }
 
-func authMiddleware(next func(http.ResponseWriter, *http.Request), token string) func(http.ResponseWriter, *http.Request) {
+type merchantContextKey struct{}
+
+// authenticator resolves the merchant of the request by the bearer token. The configured admin token acts
+// on behalf of the default merchant and also grants access to the administrative endpoints.
+type authenticator struct {
+	adminToken string
+	db         merchantStorage
+}
+
+// authMiddleware passes the request to the handler with the merchant of the token in the context
+func authMiddleware(next func(http.ResponseWriter, *http.Request), auth authenticator) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !checkToken(r, token) {
-			w.WriteHeader(http.StatusUnauthorized)
+		merchant, _, ok := auth.authenticate(w, r)
+		if !ok {
 			return
 		}
-		next(w, r)
+		next(w, r.WithContext(context.WithValue(r.Context(), merchantContextKey{}, merchant)))
 	}
 }
 
-func checkToken(req *http.Request, token string) bool {
+// adminMiddleware passes only requests with the admin token
+func adminMiddleware(next func(http.ResponseWriter, *http.Request), auth authenticator) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		merchant, admin, ok := auth.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if !admin {
+			writeHttpError(w, "admin token required", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), merchantContextKey{}, merchant)))
+	}
+}
+
+// authenticate writes the error response and returns false if the request is not authorized
+func (a authenticator) authenticate(w http.ResponseWriter, r *http.Request) (core.Merchant, bool, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return core.Merchant{}, false, false
+	}
+	admin := checkToken(token, a.adminToken)
+	var (
+		merchant core.Merchant
+		err      error
+	)
+	if admin {
+		merchant, err = a.db.GetMerchant(r.Context(), core.DefaultMerchantID)
+	} else {
+		merchant, err = a.db.GetMerchantByToken(r.Context(), core.HashMerchantToken(token))
+	}
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return core.Merchant{}, false, false
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return core.Merchant{}, false, false
+	}
+	return merchant, admin, true
+}
+
+// merchantFromContext returns the merchant set by the auth middleware
+func merchantFromContext(ctx context.Context) core.Merchant {
+	merchant, _ := ctx.Value(merchantContextKey{}).(core.Merchant)
+	return merchant
+}
+
+func bearerToken(req *http.Request) (string, bool) {
 	auth := strings.Split(req.Header.Get("authorization"), " ")
 	if len(auth) != 2 {
-		return false
+		return "", false
 	}
-	if auth[0] != "Bearer" {
-		return false
+	if auth[0] != "Bearer" || len(auth[1]) == 0 {
+		return "", false
 	}
-	if x := subtle.ConstantTimeCompare([]byte(auth[1]), []byte(token)); x == 1 {
+	return auth[1], true
+}
+
+func checkToken(received, token string) bool {
+	if x := subtle.ConstantTimeCompare([]byte(received), []byte(token)); x == 1 {
 		return true
 	} // constant time comparison to prevent time attack
 	return false
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type NewPaymentRequest struct {
+	Currency string `json:"currency"`
+	// MinAmount is a minimal accepted payment, any positive amount is accepted if empty
+	MinAmount string `json:"min_amount,omitempty"`
+	// Amount is a suggested amount for payment links, the payer chooses it if empty
+	Amount      string                     `json:"amount,omitempty"`
+	PrivateInfo map[string]json.RawMessage `json:"private_info,omitempty"`
+	Metadata    core.InvoiceMetadata       `json:"metadata"`
+}
+
+func (h *Handler) createPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data NewPaymentRequest
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid payment request data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	merchant := merchantFromContext(r.Context())
+	request, err := h.convertNewPaymentRequest(merchant, data)
+	if err != nil {
+		writeHttpError(w, "payment request data parsing error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	request.Recipient = merchant.Recipient
+	err = h.db.CreatePaymentRequest(r.Context(), request)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writePaymentRequest(w, request)
+}
+
+func (h *Handler) getPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	request, err := h.db.GetPaymentRequest(r.Context(), id)
+	if err == nil && request.Recipient != merchantFromContext(r.Context()).Recipient {
+		err = core.ErrNotFound
+	}
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writePaymentRequest(w, request)
+}
+
+func (h *Handler) getPaymentRequests(w http.ResponseWriter, r *http.Request) {
+	var (
+		limit int64     = 20
+		after uuid.UUID // empty ID
+		err   error
+	)
+	if limitQuery := r.URL.Query().Get("limit"); len(limitQuery) > 0 {
+		limit, err = strconv.ParseInt(limitQuery, 10, 64)
+		if err != nil {
+			writeHttpError(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if afterQuery := r.URL.Query().Get("after"); len(afterQuery) > 0 {
+		after, err = uuid.Parse(afterQuery)
+		if err != nil {
+			writeHttpError(w, "invalid payment request ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	requests, err := h.db.GetPaymentRequests(r.Context(), merchantFromContext(r.Context()).Recipient, after, limit)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		PaymentRequests []core.PaymentRequestPrintable `json:"payment_requests"`
+	}{
+		PaymentRequests: make([]core.PaymentRequestPrintable, 0, len(requests)),
+	}
+	for _, pr := range requests {
+		request, err := core.ConvertPaymentRequestToPrintable(h.paymentPrefixes, pr, h.currencies.All(), h.adnlAddress)
+		if err != nil {
+			writeHttpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.PaymentRequests = append(res.PaymentRequests, request)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode payment requests", "error", err)
+	}
+}
+
+func (h *Handler) disablePaymentRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	request, err := h.db.DisablePaymentRequest(r.Context(), merchantFromContext(r.Context()).Recipient, id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, "no active payment request found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writePaymentRequest(w, request)
+}
+
+func (h *Handler) writePaymentRequest(w http.ResponseWriter, request core.PaymentRequest) {
+	res, err := core.ConvertPaymentRequestToPrintable(h.paymentPrefixes, request, h.currencies.All(), h.adnlAddress)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode payment request", "error", err)
+	}
+}
+
+func (h *Handler) convertNewPaymentRequest(merchant core.Merchant, newRequest NewPaymentRequest) (core.PaymentRequest, error) {
+	ext, err := h.acceptedCurrency(merchant, newRequest.Currency)
+	if err != nil {
+		return core.PaymentRequest{}, err
+	}
+	minAmount := big.NewInt(1)
+	if len(newRequest.MinAmount) > 0 {
+		var err error
+		minAmount, _, err = h.convertAmount(merchant, newRequest.MinAmount, newRequest.Currency)
+		if err != nil {
+			return core.PaymentRequest{}, fmt.Errorf("min amount: %w", err)
+		}
+	}
+	var amount *big.Int
+	if len(newRequest.Amount) > 0 {
+		var err error
+		amount, _, err = h.convertAmount(merchant, newRequest.Amount, newRequest.Currency)
+		if err != nil {
+			return core.PaymentRequest{}, err
+		}
+		if amount.Cmp(minAmount) < 0 {
+			return core.PaymentRequest{}, errors.New("amount must not be less than min amount")
+		}
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return core.PaymentRequest{}, err
+	}
+	metadata, err := convertMetadata(newRequest.Metadata)
+	if err != nil {
+		return core.PaymentRequest{}, err
+	}
+	now := time.Now()
+	res := core.PaymentRequest{
+		ID:          id,
+		Status:      core.ActivePaymentRequestStatus,
+		Currency:    ext.Currency,
+		MinAmount:   minAmount,
+		Amount:      amount,
+		PrivateInfo: newRequest.PrivateInfo,
+		Metadata:    metadata,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if res.PrivateInfo == nil {
+		res.PrivateInfo = make(map[string]json.RawMessage)
+	}
+	return res, nil
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultNotifyBefore = 24 * 60 * 60 // one day in seconds
+
+type NewSubscription struct {
+	Template NewSubscriptionTemplate `json:"template"`
+	Period   string                  `json:"period"`
+	Interval int                     `json:"interval,omitempty"`
+	StartAt  int64                   `json:"start_at,omitempty"` // now if empty
+	EndAt    int64                   `json:"end_at,omitempty"`
+	// NotifyBefore is how many seconds before the cycle the upcoming notification is sent, negative value disables it
+	NotifyBefore *int64 `json:"notify_before,omitempty"`
+}
+
+type NewSubscriptionTemplate struct {
+	Amount      string                     `json:"amount"`
+	Currency    string                     `json:"currency"`
+	LifeTime    int64                      `json:"life_time"`
+	PrivateInfo map[string]json.RawMessage `json:"private_info,omitempty"`
+	Metadata    core.InvoiceMetadata       `json:"metadata"`
+}
+
+func (h *Handler) createSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data NewSubscription
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid subscription data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	subscription, err := h.convertNewSubscription(merchantFromContext(r.Context()), data)
+	if err != nil {
+		writeHttpError(w, "subscription data parsing error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.db.CreateSubscription(r.Context(), subscription)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeSubscription(w, subscription)
+}
+
+func (h *Handler) getSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	subscription, err := h.db.GetSubscription(r.Context(), id)
+	if err == nil && subscription.Recipient != merchantFromContext(r.Context()).Recipient {
+		err = core.ErrNotFound
+	}
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeSubscription(w, subscription)
+}
+
+func (h *Handler) getSubscriptions(w http.ResponseWriter, r *http.Request) {
+	var (
+		limit int64     = 20
+		after uuid.UUID // empty ID
+		err   error
+	)
+	if limitQuery := r.URL.Query().Get("limit"); len(limitQuery) > 0 {
+		limit, err = strconv.ParseInt(limitQuery, 10, 64)
+		if err != nil {
+			writeHttpError(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if afterQuery := r.URL.Query().Get("after"); len(afterQuery) > 0 {
+		after, err = uuid.Parse(afterQuery)
+		if err != nil {
+			writeHttpError(w, "invalid subscription ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	subscriptions, err := h.db.GetSubscriptions(r.Context(), merchantFromContext(r.Context()).Recipient, after, limit)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		Subscriptions []core.SubscriptionPrintable `json:"subscriptions"`
+	}{
+		Subscriptions: make([]core.SubscriptionPrintable, 0, len(subscriptions)),
+	}
+	for _, s := range subscriptions {
+		subscription, err := core.ConvertSubscriptionToPrintable(s, h.currencies.All())
+		if err != nil {
+			writeHttpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Subscriptions = append(res.Subscriptions, subscription)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode subscriptions", "error", err)
+	}
+}
+
+func (h *Handler) cancelSubscription(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	subscription, err := h.db.CancelSubscription(r.Context(), merchantFromContext(r.Context()).Recipient, id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, "no active subscription found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeSubscription(w, subscription)
+}
+
+func (h *Handler) writeSubscription(w http.ResponseWriter, subscription core.Subscription) {
+	res, err := core.ConvertSubscriptionToPrintable(subscription, h.currencies.All())
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode subscription", "error", err)
+	}
+}
+
+func (h *Handler) convertNewSubscription(merchant core.Merchant, newSubscription NewSubscription) (core.Subscription, error) {
+	amount, cur, err := h.convertAmount(merchant, newSubscription.Template.Amount, newSubscription.Template.Currency)
+	if err != nil {
+		return core.Subscription{}, err
+	}
+	if newSubscription.Template.LifeTime <= 0 {
+		return core.Subscription{}, errors.New("life time must be positive integer")
+	}
+	period, err := core.ParseSubscriptionPeriod(newSubscription.Period)
+	if err != nil {
+		return core.Subscription{}, err
+	}
+	if newSubscription.Interval == 0 {
+		newSubscription.Interval = 1
+	}
+	if newSubscription.Interval < 0 {
+		return core.Subscription{}, errors.New("interval must be positive integer")
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return core.Subscription{}, err
+	}
+	now := time.Now()
+	res := core.Subscription{
+		ID:        id,
+		Status:    core.ActiveSubscriptionStatus,
+		Recipient: merchant.Recipient,
+		Template: core.SubscriptionTemplate{
+			Amount:      amount,
+			Currency:    cur,
+			LifeTime:    time.Second * time.Duration(newSubscription.Template.LifeTime),
+			PrivateInfo: newSubscription.Template.PrivateInfo,
+		},
+		Period:       period,
+		Interval:     newSubscription.Interval,
+		StartAt:      now,
+		NotifyBefore: defaultNotifyBefore * time.Second,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if newSubscription.StartAt != 0 {
+		res.StartAt = time.Unix(newSubscription.StartAt, 0)
+		// every missed cycle is issued, so the start must not be older than one period
+		if res.CycleAt(1).Before(now) {
+			return core.Subscription{}, errors.New("start must not be earlier than one period ago")
+		}
+	}
+	if newSubscription.EndAt != 0 {
+		endAt := time.Unix(newSubscription.EndAt, 0)
+		if !endAt.After(res.StartAt) {
+			return core.Subscription{}, errors.New("end must be after start")
+		}
+		res.EndAt = &endAt
+	}
+	if newSubscription.NotifyBefore != nil {
+		res.NotifyBefore = max(time.Second*time.Duration(*newSubscription.NotifyBefore), 0)
+	}
+	if res.Template.LifeTime > res.CycleAt(1).Sub(res.StartAt) {
+		return core.Subscription{}, errors.New("life time must not exceed subscription period")
+	}
+	res.Template.Metadata, err = convertMetadata(newSubscription.Template.Metadata)
+	if err != nil {
+		return core.Subscription{}, err
+	}
+	if res.Template.PrivateInfo == nil {
+		res.Template.PrivateInfo = make(map[string]json.RawMessage)
+	}
+	return res, nil
+}
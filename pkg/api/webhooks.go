@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+type NewWebhook struct {
+	URL string `json:"url"`
+	// Secret signs deliveries. It is generated on creation and kept on update if empty
+	Secret string `json:"secret,omitempty"`
+	// PreviousSecret also signs deliveries during the secret rotation
+	PreviousSecret string   `json:"previous_secret,omitempty"`
+	EventTypes     []string `json:"event_types,omitempty"`
+	Currencies     []string `json:"currencies,omitempty"`
+	Enabled        *bool    `json:"enabled,omitempty"` // true if empty
+}
+
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data NewWebhook
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid webhook data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	webhook := core.Webhook{ID: id, MerchantID: merchantFromContext(r.Context()).ID, CreatedAt: now}
+	err = h.applyNewWebhook(&webhook, data, now)
+	if err != nil {
+		writeHttpError(w, "webhook data parsing error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.db.CreateWebhook(r.Context(), webhook)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeWebhook(w, webhook, true)
+}
+
+func (h *Handler) getWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	webhook, err := h.getMerchantWebhook(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeWebhook(w, webhook, false)
+}
+
+// getMerchantWebhook returns the webhook of the request merchant, webhooks of other merchants are not found
+func (h *Handler) getMerchantWebhook(ctx context.Context, id uuid.UUID) (core.Webhook, error) {
+	webhook, err := h.db.GetWebhook(ctx, id)
+	if err != nil {
+		return core.Webhook{}, err
+	}
+	if webhook.MerchantID != merchantFromContext(ctx).ID {
+		return core.Webhook{}, core.ErrNotFound
+	}
+	return webhook, nil
+}
+
+func (h *Handler) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.db.GetMerchantWebhooks(r.Context(), merchantFromContext(r.Context()).ID)
+	if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		Webhooks []core.WebhookPrintable `json:"webhooks"`
+	}{
+		Webhooks: make([]core.WebhookPrintable, 0, len(webhooks)),
+	}
+	for _, webhook := range webhooks {
+		res.Webhooks = append(res.Webhooks, core.ConvertWebhookToPrintable(webhook, false))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode webhooks", "error", err)
+	}
+}
+
+// updateWebhook replaces all webhook settings except for the secret which is kept if not set
+func (h *Handler) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if r.Body == nil {
+		writeHttpError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	var data NewWebhook
+	err = json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		writeHttpError(w, "invalid webhook data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	webhook, err := h.getMerchantWebhook(r.Context(), id)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	secrets := webhook.Secrets()
+	err = h.applyNewWebhook(&webhook, data, time.Now())
+	if err != nil {
+		writeHttpError(w, "webhook data parsing error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.db.UpdateWebhook(r.Context(), webhook)
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeWebhook(w, webhook, !slices.Equal(secrets, webhook.Secrets())) // secrets are shown after the rotation only
+}
+
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeHttpError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	_, err = h.getMerchantWebhook(r.Context(), id)
+	if err == nil {
+		err = h.db.DeleteWebhook(r.Context(), id)
+	}
+	if err != nil && errors.Is(err, core.ErrNotFound) {
+		writeHttpError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeWebhook(w http.ResponseWriter, webhook core.Webhook, withSecrets bool) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(core.ConvertWebhookToPrintable(webhook, withSecrets))
+	if err != nil {
+		slog.Error("encode webhook", "error", err)
+	}
+}
+
+func (h *Handler) applyNewWebhook(webhook *core.Webhook, data NewWebhook, now time.Time) error {
+	u, err := url.ParseRequestURI(data.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid url: %s", data.URL)
+	}
+	for _, eventType := range data.EventTypes {
+		if err := core.ValidateWebhookEventType(eventType); err != nil {
+			return err
+		}
+	}
+	for _, ticker := range data.Currencies {
+		if _, ok := h.currencies.Lookup(ticker); !ok {
+			return fmt.Errorf("currency ticker %s not found", ticker)
+		}
+	}
+	// masked secrets returned by the API are kept, so the webhook can be updated with the data it was read with
+	if data.Secret == core.MaskWebhookSecret(webhook.Secret) {
+		data.Secret = ""
+	}
+	if webhook.PreviousSecret != nil && data.PreviousSecret == core.MaskWebhookSecret(*webhook.PreviousSecret) {
+		data.PreviousSecret = *webhook.PreviousSecret
+	}
+	switch {
+	case len(data.Secret) > 0:
+		webhook.Secret = data.Secret
+	case len(webhook.Secret) == 0:
+		webhook.Secret, err = generateWebhookSecret()
+		if err != nil {
+			return err
+		}
+	}
+	webhook.PreviousSecret = nil
+	if len(data.PreviousSecret) > 0 {
+		webhook.PreviousSecret = &data.PreviousSecret
+	}
+	webhook.URL = data.URL
+	webhook.EventTypes = data.EventTypes
+	webhook.Currencies = data.Currencies
+	webhook.Enabled = data.Enabled == nil || *data.Enabled
+	webhook.UpdatedAt = now
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
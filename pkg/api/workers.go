@@ -0,0 +1,26 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"net/http"
+)
+
+// getWorkers reports states of the loader and indexer workers of tracked accounts
+func (h *Handler) getWorkers(w http.ResponseWriter, r *http.Request) {
+	states := h.workers.WorkerStates()
+	res := struct {
+		Workers []core.WorkerStatePrintable `json:"workers"`
+	}{
+		Workers: make([]core.WorkerStatePrintable, 0, len(states)),
+	}
+	for _, s := range states {
+		res.Workers = append(res.Workers, core.ConvertWorkerStateToPrintable(s))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(res)
+	if err != nil {
+		slog.Error("encode workers", "error", err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/tonkeeper/tongo/tvm"
 	"github.com/tonkeeper/tongo/txemulator"
 	"github.com/txsociety/spice-harvester/pkg/core"
+	"github.com/txsociety/spice-harvester/pkg/proof"
 	"log/slog"
 	"strings"
 	"sync"
@@ -23,9 +24,13 @@ import (
 
 type Client struct {
 	connection *liteapi.Client
+	initBlock  *ton.BlockIDExt
+	// keyBlock is the last proven key block or the trusted block, new blocks are proven starting from it.
+	// It is saved as the trusted block for the next start and used by the block watcher only.
+	keyBlock *ton.BlockIDExt
 
 	lastMasterchainBlockLock sync.RWMutex
-	lastMasterchainBlock     *ton.BlockIDExt
+	lastMasterchainBlock     *ton.BlockIDExt // proven from the trusted block
 }
 
 type storage interface {
@@ -33,7 +38,12 @@ type storage interface {
 	GetLastTrustedBlock(ctx context.Context) (*ton.BlockIDExt, error)
 }
 
-func New(ls []config.LiteServer) (*Client, error) {
+// New creates the client of liteservers. The init block is the trust anchor instead of the block saved in the storage,
+// it must be a masterchain key block taken from a trusted source.
+func New(ls []config.LiteServer, initBlock *ton.BlockIDExt) (*Client, error) {
+	if initBlock != nil && !proof.IsMasterchainBlock(*initBlock) {
+		return nil, errors.New("init block must be a masterchain block")
+	}
 	options := make([]liteapi.Option, 0)
 	if len(ls) > 0 {
 		options = append(options, liteapi.WithLiteServers(ls))
@@ -42,23 +52,38 @@ func New(ls []config.LiteServer) (*Client, error) {
 		options = append(options, liteapi.Mainnet())
 		slog.Warn("liteservers are not set, retrieving liteservers from global config")
 	}
-	// TODO: set trustedBlock
 	api, err := liteapi.NewClient(options...)
 	if err != nil {
 		return nil, err
 	}
 	c := &Client{
 		connection: api,
+		initBlock:  initBlock,
 	}
 	return c, nil
 }
 
-func (c *Client) RunBlockWatcher(ctx context.Context, storage storage, wg *sync.WaitGroup) {
-	slog.Info("initializing client. Can require few minutes for checking proofs")
+// RunBlockWatcher proves the latest masterchain block starting from the trusted block and keeps it updated.
+// The trusted block is the init block if it is set or the last proven key block saved in the storage.
+func (c *Client) RunBlockWatcher(ctx context.Context, storage storage, wg *sync.WaitGroup) error {
+	trusted := c.initBlock
+	if trusted == nil {
+		var err error
+		trusted, err = storage.GetLastTrustedBlock(ctx)
+		if err != nil {
+			return fmt.Errorf("can not get trusted block: %w", err)
+		}
+	}
+	if trusted == nil {
+		return errors.New("trusted block is not set, INIT_BLOCK is required")
+	}
+	c.keyBlock = trusted
+	slog.Info("initializing client. Can require few minutes for checking proofs", "trusted_block", trusted.Seqno)
 	wait := make(chan struct{})
 	go c.runBlockWatcher(ctx, storage, wg, wait)
 	<-wait
 	slog.Info("client initialized")
+	return nil
 }
 
 func (c *Client) runBlockWatcher(ctx context.Context, storage storage, wg *sync.WaitGroup, wait chan struct{}) {
@@ -99,12 +124,52 @@ func (c *Client) updateMasterchainBlock(ctx context.Context, storage storage, ti
 		return fmt.Errorf("can not get masterchain info: %w", err)
 	}
 	block := info.Last.ToBlockIdExt()
+	last, err := c.getLastMasterchainBlock()
+	if err == nil && block.Seqno <= last.Seqno {
+		return nil
+	}
+	err = c.proveMasterchainBlock(ctx1, block)
+	if err != nil {
+		return fmt.Errorf("can not prove block %v: %w", block.Seqno, err)
+	}
 	c.lastMasterchainBlockLock.Lock()
 	c.lastMasterchainBlock = &block
 	c.lastMasterchainBlockLock.Unlock()
-	err = storage.SetLastTrustedBlock(ctx1, block)
+	// links from the latest block are accepted only from a key block, so the key block is the trust anchor
+	err = storage.SetLastTrustedBlock(ctx1, *c.keyBlock)
 	if err != nil {
-		return fmt.Errorf("can not save last block: %w", err)
+		return fmt.Errorf("can not save trusted block: %w", err)
+	}
+	return nil
+}
+
+// proveMasterchainBlock checks the chain of block links from the last proven key block to the target block
+func (c *Client) proveMasterchainBlock(ctx context.Context, target ton.BlockIDExt) error {
+	if !proof.IsMasterchainBlock(target) {
+		return errors.New("not a masterchain block")
+	}
+	from := *c.keyBlock
+	for from != target {
+		links, err := c.connection.GetBlockProofRaw(ctx, from, &target)
+		if err != nil {
+			return err
+		}
+		if len(links.Steps) == 0 {
+			return errors.New("empty proof")
+		}
+		for _, step := range links.Steps {
+			to, isKey, err := proof.VerifyLink(from, step)
+			if err != nil {
+				return fmt.Errorf("invalid link from %v to %v: %w", from.Seqno, to.Seqno, err)
+			}
+			if isKey {
+				c.keyBlock = &to
+			}
+			from = to
+		}
+		if links.Complete && from != target {
+			return errors.New("proof does not reach the block")
+		}
 	}
 	return nil
 }
@@ -118,6 +183,8 @@ func (c *Client) getLastMasterchainBlock() (ton.BlockIDExt, error) {
 	return *c.lastMasterchainBlock, nil
 }
 
+// GetTransactions returns transactions of the account starting from the transaction with the hash.
+// Transactions are proven by the chain of hashes if the hash is taken from the proven account state.
 func (c *Client) GetTransactions(ctx context.Context, a ton.AccountID, lt, maxDepthLt uint64, hash ton.Bits256) ([]core.Transaction, error) {
 	var transactions []core.Transaction
 	txs, err := c.connection.GetTransactions(ctx, 16, a, lt, hash)
@@ -139,20 +206,56 @@ func (c *Client) GetTransactions(ctx context.Context, a ton.AccountID, lt, maxDe
 	return transactions, nil
 }
 
+// GetAccountState returns the account state proven by the last proven masterchain block and the seqno of the block
 func (c *Client) GetAccountState(ctx context.Context, accountID ton.AccountID) (tlb.ShardAccount, uint32, error) {
 	block, err := c.getLastMasterchainBlock()
 	if err != nil {
 		return tlb.ShardAccount{}, 0, err
 	}
-	shardAcc, err := c.connection.WithBlock(block).GetAccountState(ctx, accountID)
+	res, err := c.connection.WithBlock(block).GetAccountStateRaw(ctx, accountID)
 	if err != nil {
 		return tlb.ShardAccount{}, 0, err
 	}
+	shardAcc, err := proof.VerifyAccountState(block, accountID, res)
+	if err != nil {
+		return tlb.ShardAccount{}, 0, fmt.Errorf("invalid account state proof: %w", err)
+	}
 	return shardAcc, block.Seqno, nil
 }
 
+// GetLibraries returns public libraries, they are checked by their hashes
 func (c *Client) GetLibraries(ctx context.Context, libraryList []ton.Bits256) (map[ton.Bits256]*boc.Cell, error) {
-	return c.connection.GetLibraries(ctx, libraryList)
+	libs, err := c.connection.GetLibraries(ctx, libraryList)
+	if err != nil {
+		return nil, err
+	}
+	for hash, lib := range libs {
+		h, err := lib.Hash256()
+		if err != nil {
+			return nil, err
+		}
+		if ton.Bits256(h) != hash {
+			return nil, fmt.Errorf("library hash mismatch: %v", hash.Hex())
+		}
+	}
+	return libs, nil
+}
+
+// getConfig returns the blockchain config proven by the last proven masterchain block
+func (c *Client) getConfig(ctx context.Context) (tlb.ConfigParams, error) {
+	block, err := c.getLastMasterchainBlock()
+	if err != nil {
+		return tlb.ConfigParams{}, err
+	}
+	res, err := c.connection.WithBlock(block).GetConfigAllRaw(ctx, 0)
+	if err != nil {
+		return tlb.ConfigParams{}, err
+	}
+	configParams, err := proof.VerifyConfig(block, res)
+	if err != nil {
+		return tlb.ConfigParams{}, fmt.Errorf("invalid config proof: %w", err)
+	}
+	return configParams, nil
 }
 
 func (c *Client) RunSmcMethodByID(ctx context.Context, accountID ton.AccountID, methodID int, params tlb.VmStack) (uint32, tlb.VmStack, error) {
@@ -176,7 +279,7 @@ func (c *Client) RunSmcMethodByID(ctx context.Context, accountID ton.AccountID,
 	data = &state.Account.Account.Storage.State.AccountActive.StateInit.Data.Value.Value
 
 	cfg := boc.NewCell()
-	configParams, err := c.connection.GetConfigAll(ctx, 0)
+	configParams, err := c.getConfig(ctx)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -0,0 +1,163 @@
+package confirmer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tonkeeper/tongo/config"
+	"github.com/tonkeeper/tongo/liteapi"
+	"github.com/tonkeeper/tongo/ton"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAlerted is the number of the latest alerted transactions which are not alerted again
+	maxAlerted = 1000
+	// checkTimeout bounds the request to one liteserver, so a hung liteserver does not block saving of payments
+	checkTimeout = 10 * time.Second
+)
+
+// Alerter notifies the operator about problems which require attention
+type Alerter interface {
+	Alert(ctx context.Context, text string) error
+}
+
+var errMismatch = errors.New("transaction mismatch")
+
+type liteServer interface {
+	GetTransactions(ctx context.Context, count uint32, accountID ton.AccountID, lt uint64, hash ton.Bits256) ([]ton.Transaction, error)
+}
+
+type confirmationServer struct {
+	host       string
+	connection liteServer
+}
+
+// Confirmer checks transactions against independent liteservers, so payments are not accepted from the data of one node
+type Confirmer struct {
+	servers  []confirmationServer
+	required int
+	alerters []Alerter
+	timeout  time.Duration // of the request to one liteserver
+
+	alertedLock  sync.Mutex
+	alerted      map[ton.Bits256]struct{} // transactions with alerted disagreements
+	alertedOrder []ton.Bits256            // alerted transactions from the oldest one, at most maxAlerted
+}
+
+// New creates the confirmer which requires the same transaction from the required number of the liteservers,
+// the majority of liteservers is required if it is 0. Disagreements of liteservers are sent to the alerters.
+func New(ls []config.LiteServer, required int, alerters []Alerter) (*Confirmer, error) {
+	var servers []confirmationServer
+	for _, server := range ls {
+		// the server is used alone, so a failure of one server does not affect others
+		api, err := liteapi.NewClient(
+			liteapi.WithLiteServers([]config.LiteServer{server}),
+			liteapi.WithMaxConnectionsNumber(1),
+			liteapi.WithAsyncConnectionsInit())
+		if err != nil {
+			return nil, fmt.Errorf("liteserver %s: %w", server.Host, err)
+		}
+		servers = append(servers, confirmationServer{host: server.Host, connection: api})
+	}
+	return newConfirmer(servers, required, alerters)
+}
+
+func newConfirmer(servers []confirmationServer, required int, alerters []Alerter) (*Confirmer, error) {
+	if required == 0 {
+		required = len(servers)/2 + 1
+	}
+	if required < 1 || required > len(servers) {
+		return nil, fmt.Errorf("required confirmations must be 1..%d", len(servers))
+	}
+	return &Confirmer{
+		servers:  servers,
+		required: required,
+		alerters: alerters,
+		timeout:  checkTimeout,
+		alerted:  make(map[ton.Bits256]struct{}),
+	}, nil
+}
+
+// ConfirmTransaction returns an error if the transaction is not confirmed by the required number of liteservers.
+// Liteservers which return another transaction are logged and alerted even if the transaction is confirmed,
+// liteservers which do not have the transaction yet are not. Liteservers which do not answer in time are not counted.
+func (c *Confirmer) ConfirmTransaction(ctx context.Context, account ton.AccountID, lt uint64, hash ton.Bits256) error {
+	results := make([]error, len(c.servers))
+	var wg sync.WaitGroup
+	for i, server := range c.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			results[i] = server.check(checkCtx, account, lt, hash)
+		}()
+	}
+	wg.Wait()
+	confirmed := 0
+	var disagreements []string
+	for i, err := range results {
+		switch {
+		case err == nil:
+			confirmed++
+		case errors.Is(err, errMismatch):
+			disagreements = append(disagreements, c.servers[i].host+": "+err.Error())
+		default:
+			slog.Warn("can not confirm transaction", "account", account.ToRaw(), "lt", lt, "liteserver", c.servers[i].host, "error", err)
+		}
+	}
+	if len(disagreements) > 0 {
+		slog.Error("liteservers disagree on transaction", "account", account.ToRaw(), "lt", lt, "hash", hash.Hex(),
+			"confirmations", confirmed, "disagreements", disagreements)
+		c.alert(ctx, account, lt, hash, disagreements)
+	}
+	if confirmed < c.required {
+		return fmt.Errorf("transaction is confirmed by %d of %d liteservers, %d required", confirmed, len(c.servers), c.required)
+	}
+	return nil
+}
+
+// alert sends the disagreement to the alerters once per transaction
+func (c *Confirmer) alert(ctx context.Context, account ton.AccountID, lt uint64, hash ton.Bits256, disagreements []string) {
+	c.alertedLock.Lock()
+	_, ok := c.alerted[hash]
+	if !ok {
+		if len(c.alertedOrder) == maxAlerted {
+			delete(c.alerted, c.alertedOrder[0])
+			c.alertedOrder = c.alertedOrder[1:]
+		}
+		c.alerted[hash] = struct{}{}
+		c.alertedOrder = append(c.alertedOrder, hash)
+	}
+	c.alertedLock.Unlock()
+	if ok {
+		return
+	}
+	text := fmt.Sprintf("Liteservers disagree on payment transaction %v (lt %d) of account %v:\n%v",
+		hash.Hex(), lt, account.ToRaw(), strings.Join(disagreements, "\n"))
+	for _, a := range c.alerters {
+		err := a.Alert(ctx, text)
+		if err != nil {
+			slog.Error("send alert", "error", err)
+		}
+	}
+}
+
+func (s confirmationServer) check(ctx context.Context, account ton.AccountID, lt uint64, hash ton.Bits256) error {
+	txs, err := s.connection.GetTransactions(ctx, 1, account, lt, hash)
+	if err != nil {
+		return err
+	}
+	if len(txs) == 0 {
+		// the liteserver can lag behind others, it is not a disagreement
+		return errors.New("transaction is not found")
+	}
+	if ton.Bits256(txs[0].Hash()) != hash || txs[0].Lt != lt {
+		return fmt.Errorf("%w: another transaction %v with lt %d", errMismatch, ton.Bits256(txs[0].Hash()).Hex(), txs[0].Lt)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package confirmer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers with the transaction with the given lt, no transactions if lt is 0 or the error.
+// A hung server does not answer until the request is cancelled.
+type fakeServer struct {
+	lt   uint64
+	err  error
+	hung bool
+}
+
+func (s fakeServer) GetTransactions(ctx context.Context, count uint32, accountID ton.AccountID, lt uint64, hash ton.Bits256) ([]ton.Transaction, error) {
+	if s.hung {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.lt == 0 {
+		return nil, nil
+	}
+	return []ton.Transaction{{Transaction: tlb.Transaction{Lt: s.lt}}}, nil
+}
+
+type recordingAlerter struct {
+	mu     sync.Mutex
+	alerts []string
+}
+
+func (a *recordingAlerter) Alert(ctx context.Context, text string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.alerts = append(a.alerts, text)
+	return nil
+}
+
+const txLt = 100
+
+var (
+	confirming  = fakeServer{lt: txLt}
+	lagging     = fakeServer{}
+	disagreeing = fakeServer{lt: txLt + 1}
+	failing     = fakeServer{err: errors.New("connection refused")}
+	hung        = fakeServer{hung: true}
+)
+
+func newTestConfirmer(t *testing.T, required int, alerters []Alerter, servers ...fakeServer) *Confirmer {
+	var res []confirmationServer
+	for i, s := range servers {
+		res = append(res, confirmationServer{host: fmt.Sprintf("ls%d", i), connection: s})
+	}
+	c, err := newConfirmer(res, required, alerters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.timeout = 50 * time.Millisecond
+	return c
+}
+
+func TestConfirmTransaction(t *testing.T) {
+	tests := []struct {
+		name      string
+		servers   []fakeServer
+		required  int
+		confirmed bool
+		alerted   []string // liteservers in the alert
+	}{
+		{name: "all confirm", servers: []fakeServer{confirming, confirming, confirming}, required: 3, confirmed: true},
+		{name: "majority by default", servers: []fakeServer{confirming, confirming, failing}, confirmed: true},
+		{name: "no majority by default", servers: []fakeServer{confirming, failing, failing}},
+		{name: "lagging server is not a disagreement", servers: []fakeServer{confirming, lagging, confirming}, required: 3},
+		{name: "lagging server below required", servers: []fakeServer{confirming, lagging, confirming}, required: 2, confirmed: true},
+		{name: "disagreement is alerted if confirmed", servers: []fakeServer{confirming, disagreeing, confirming}, required: 2, confirmed: true, alerted: []string{"ls1"}},
+		{name: "disagreement is alerted if not confirmed", servers: []fakeServer{disagreeing, disagreeing, confirming}, required: 2, alerted: []string{"ls0", "ls1"}},
+		{name: "failing servers", servers: []fakeServer{failing, failing}, required: 1},
+		{name: "hung server", servers: []fakeServer{confirming, hung, confirming}, confirmed: true},
+		{name: "hung server below required", servers: []fakeServer{confirming, hung, confirming}, required: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerter := &recordingAlerter{}
+			c := newTestConfirmer(t, tt.required, []Alerter{alerter}, tt.servers...)
+			err := c.ConfirmTransaction(context.Background(), ton.AccountID{}, txLt, ton.Bits256{})
+			if tt.confirmed && err != nil {
+				t.Fatalf("expected confirmed transaction, got %v", err)
+			}
+			if !tt.confirmed && err == nil {
+				t.Fatal("expected not confirmed transaction")
+			}
+			if len(tt.alerted) == 0 {
+				if len(alerter.alerts) > 0 {
+					t.Fatalf("unexpected alerts: %v", alerter.alerts)
+				}
+				return
+			}
+			if len(alerter.alerts) != 1 {
+				t.Fatalf("expected one alert, got %v", alerter.alerts)
+			}
+			for i := range tt.servers {
+				host := fmt.Sprintf("ls%d:", i)
+				alerted := false
+				for _, h := range tt.alerted {
+					alerted = alerted || h+":" == host
+				}
+				if strings.Contains(alerter.alerts[0], host) != alerted {
+					t.Errorf("invalid liteservers in alert: %v", alerter.alerts[0])
+				}
+			}
+		})
+	}
+}
+
+func TestNewConfirmer(t *testing.T) {
+	servers := make([]confirmationServer, 4)
+	for required, want := range map[int]int{0: 3, 1: 1, 4: 4} {
+		c, err := newConfirmer(servers, required, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.required != want {
+			t.Errorf("expected %d required confirmations for %d, got %d", want, required, c.required)
+		}
+	}
+	for _, required := range []int{-1, 5} {
+		if _, err := newConfirmer(servers, required, nil); err == nil {
+			t.Errorf("%d required confirmations of 4 liteservers must be rejected", required)
+		}
+	}
+}
+
+func TestAlertOnce(t *testing.T) {
+	alerter := &recordingAlerter{}
+	c := newTestConfirmer(t, 1, []Alerter{alerter}, confirming, disagreeing)
+	for i := 0; i < 2; i++ {
+		err := c.ConfirmTransaction(context.Background(), ton.AccountID{}, txLt, ton.Bits256{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(alerter.alerts) != 1 {
+		t.Fatalf("the disagreement must be alerted once, got %d alerts", len(alerter.alerts))
+	}
+
+	// the oldest transactions are forgotten
+	for i := 1; i <= maxAlerted; i++ {
+		c.alert(context.Background(), ton.AccountID{}, txLt, ton.Bits256{byte(i >> 8), byte(i)}, nil)
+	}
+	if len(c.alerted) != maxAlerted || len(c.alertedOrder) != maxAlerted {
+		t.Fatalf("alerted transactions must be bounded by %d, got %d", maxAlerted, len(c.alerted))
+	}
+	err := c.ConfirmTransaction(context.Background(), ton.AccountID{}, txLt, ton.Bits256{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerter.alerts) != maxAlerted+2 {
+		t.Fatalf("the forgotten disagreement must be alerted again, got %d alerts", len(alerter.alerts))
+	}
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"github.com/tonkeeper/tongo/tlb"
 	"github.com/tonkeeper/tongo/ton"
+	"time"
 )
 
 type AccountInfo struct {
@@ -49,3 +50,52 @@ type Message struct {
 	DecodedOperation string
 	DecodedBody      any
 }
+
+type WorkerStatus string
+
+const (
+	RunningWorkerStatus    WorkerStatus = "running"
+	RestartingWorkerStatus WorkerStatus = "restarting" // failed and waits for restart
+	StoppedWorkerStatus    WorkerStatus = "stopped"
+)
+
+// WorkerState is the state of the worker which loads (WorkerKindLoader) or indexes (WorkerKindIndexer) transactions of the account
+type WorkerState struct {
+	Account   ton.AccountID
+	Kind      string
+	Status    WorkerStatus
+	Restarts  int
+	LastError *string
+	StartedAt time.Time // start of the current run
+	UpdatedAt time.Time
+}
+
+const (
+	WorkerKindLoader  = "loader"
+	WorkerKindIndexer = "indexer"
+)
+
+type WorkerStatePrintable struct {
+	Account   string `json:"account"`
+	Kind      string `json:"kind"`
+	Status    string `json:"status"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"last_error,omitempty"`
+	StartedAt int64  `json:"started_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+func ConvertWorkerStateToPrintable(s WorkerState) WorkerStatePrintable {
+	res := WorkerStatePrintable{
+		Account:   s.Account.ToRaw(),
+		Kind:      s.Kind,
+		Status:    string(s.Status),
+		Restarts:  s.Restarts,
+		StartedAt: s.StartedAt.Unix(),
+		UpdatedAt: s.UpdatedAt.Unix(),
+	}
+	if s.LastError != nil {
+		res.LastError = *s.LastError
+	}
+	return res
+}
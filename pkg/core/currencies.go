@@ -3,9 +3,16 @@ package core
 import (
 	"fmt"
 	"github.com/tonkeeper/tongo/ton"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
 )
 
-const DefaultTonTicker = "TON"
+const (
+	DefaultTonTicker = "TON"
+	tonDecimals      = 9
+)
 
 type Currency struct {
 	// Do not use pointers to support equality
@@ -19,6 +26,20 @@ type ExtendedCurrency struct {
 	JettonDecimals int
 }
 
+// Decimals returns the number of decimal places of the currency
+func (c ExtendedCurrency) Decimals() int {
+	if c.Type == TON {
+		return tonDecimals
+	}
+	return c.JettonDecimals
+}
+
+// FormatAmount returns the amount in base units as a decimal number of whole units
+func (c ExtendedCurrency) FormatAmount(amount *big.Int) string {
+	denominator := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(c.Decimals())), nil)
+	return FormatDecimal(new(big.Rat).SetFrac(amount, denominator))
+}
+
 type CurrencyType = string
 
 const (
@@ -74,3 +95,130 @@ func (c *Currency) String() string {
 	}
 	return ""
 }
+
+// RegisteredCurrency is a currency with its ticker. Disabled currencies are not accepted for new invoices
+// and their accounts are not tracked, but they are kept to display existing invoices and payments.
+type RegisteredCurrency struct {
+	Ticker string
+	ExtendedCurrency
+	Enabled bool
+}
+
+type CurrencyPrintable struct {
+	Ticker   string `json:"ticker"`
+	Type     string `json:"type"`
+	Decimals int    `json:"decimals"`
+	Address  string `json:"address,omitempty"` // Jetton master
+	Enabled  bool   `json:"enabled"`
+}
+
+func ConvertCurrencyToPrintable(c RegisteredCurrency) CurrencyPrintable {
+	res := CurrencyPrintable{
+		Ticker:   c.Ticker,
+		Type:     c.Type,
+		Decimals: c.Decimals(),
+		Enabled:  c.Enabled,
+	}
+	if c.Type == Jetton {
+		res.Address = c.Jetton().ToRaw()
+	}
+	return res
+}
+
+// CurrencyRegistry holds currencies by ticker. Currencies can be added and disabled at runtime,
+// so the registry is shared by all components instead of a plain map.
+type CurrencyRegistry struct {
+	mu         sync.RWMutex
+	currencies map[string]ExtendedCurrency // copied on write, so maps returned by All are never modified
+	disabled   map[string]struct{}
+}
+
+// NewCurrencyRegistry creates the registry with enabled currencies
+func NewCurrencyRegistry(currencies map[string]ExtendedCurrency) *CurrencyRegistry {
+	r := &CurrencyRegistry{
+		currencies: make(map[string]ExtendedCurrency, len(currencies)),
+		disabled:   make(map[string]struct{}),
+	}
+	for ticker, c := range currencies {
+		r.currencies[ticker] = c
+	}
+	return r
+}
+
+// All returns all currencies by ticker including disabled ones. The returned map must not be modified.
+func (r *CurrencyRegistry) All() map[string]ExtendedCurrency {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.currencies
+}
+
+// Get returns the enabled currency by ticker
+func (r *CurrencyRegistry) Get(ticker string) (ExtendedCurrency, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.currencies[ticker]
+	if !ok {
+		return ExtendedCurrency{}, false
+	}
+	if _, disabled := r.disabled[ticker]; disabled {
+		return ExtendedCurrency{}, false
+	}
+	return c, true
+}
+
+// Lookup returns the currency by ticker including disabled ones
+func (r *CurrencyRegistry) Lookup(ticker string) (RegisteredCurrency, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.currencies[ticker]
+	if !ok {
+		return RegisteredCurrency{}, false
+	}
+	_, disabled := r.disabled[ticker]
+	return RegisteredCurrency{Ticker: ticker, ExtendedCurrency: c, Enabled: !disabled}, true
+}
+
+// Find returns the currency by its value including disabled ones
+func (r *CurrencyRegistry) Find(currency Currency) (RegisteredCurrency, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for ticker, c := range r.currencies {
+		if c.Currency == currency {
+			_, disabled := r.disabled[ticker]
+			return RegisteredCurrency{Ticker: ticker, ExtendedCurrency: c, Enabled: !disabled}, true
+		}
+	}
+	return RegisteredCurrency{}, false
+}
+
+// List returns all currencies ordered by ticker
+func (r *CurrencyRegistry) List() []RegisteredCurrency {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := make([]RegisteredCurrency, 0, len(r.currencies))
+	for ticker, c := range r.currencies {
+		_, disabled := r.disabled[ticker]
+		res = append(res, RegisteredCurrency{Ticker: ticker, ExtendedCurrency: c, Enabled: !disabled})
+	}
+	slices.SortFunc(res, func(a, b RegisteredCurrency) int {
+		return strings.Compare(a.Ticker, b.Ticker)
+	})
+	return res
+}
+
+// Set adds the currency or replaces the currency with the same ticker
+func (r *CurrencyRegistry) Set(c RegisteredCurrency) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	currencies := make(map[string]ExtendedCurrency, len(r.currencies)+1)
+	for ticker, cur := range r.currencies {
+		currencies[ticker] = cur
+	}
+	currencies[c.Ticker] = c.ExtendedCurrency
+	r.currencies = currencies
+	if c.Enabled {
+		delete(r.disabled, c.Ticker)
+	} else {
+		r.disabled[c.Ticker] = struct{}{}
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+type DeliveryStatus string
+
+const (
+	PendingDeliveryStatus   DeliveryStatus = "pending"
+	DeliveredDeliveryStatus DeliveryStatus = "delivered"
+	DeadDeliveryStatus      DeliveryStatus = "dead" // all attempts failed, can be resent manually
+)
+
+// WebhookSender is the sender name of deliveries to webhooks managed via API
+const WebhookSender = "webhook"
+
+// Delivery is a notification payload saved for delivering to one destination: a configured sender or a webhook
+type Delivery struct {
+	ID            uuid.UUID
+	MerchantID    uuid.UUID
+	Sender        string
+	WebhookID     *uuid.UUID // set for WebhookSender
+	EventID       uuid.UUID
+	InvoiceID     *InvoiceID // set for invoice events, deliveries of the same invoice to the same destination are sent in order
+	EventType     string
+	Payload       json.RawMessage
+	Status        DeliveryStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     *string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeliveredAt   *time.Time
+}
+
+type DeliveryAttempt struct {
+	ID          uuid.UUID
+	DeliveryID  uuid.UUID
+	AttemptedAt time.Time
+	Duration    time.Duration
+	Error       *string // nil for successful attempt
+}
+
+type DeliveryFilter struct {
+	MerchantID uuid.UUID
+	Status     *DeliveryStatus
+	Sender     *string
+	WebhookID  *uuid.UUID
+	EventID    *uuid.UUID
+	After      uuid.UUID
+	Limit      int64
+}
+
+func ParseDeliveryStatus(s string) (DeliveryStatus, bool) {
+	switch status := DeliveryStatus(s); status {
+	case PendingDeliveryStatus, DeliveredDeliveryStatus, DeadDeliveryStatus:
+		return status, true
+	}
+	return "", false
+}
+
+type DeliveryAttemptPrintable struct {
+	AttemptedAt int64  `json:"attempted_at"`
+	Duration    int64  `json:"duration_ms"`
+	Error       string `json:"error,omitempty"`
+}
+
+type DeliveryPrintable struct {
+	ID            string                     `json:"id"`
+	Sender        string                     `json:"sender"`
+	WebhookID     string                     `json:"webhook_id,omitempty"`
+	EventID       string                     `json:"event_id"`
+	InvoiceID     string                     `json:"invoice_id,omitempty"`
+	EventType     string                     `json:"event_type"`
+	Status        string                     `json:"status"`
+	Attempts      int                        `json:"attempts"`
+	NextAttemptAt *int64                     `json:"next_attempt_at,omitempty"`
+	LastError     string                     `json:"last_error,omitempty"`
+	CreatedAt     int64                      `json:"created_at"`
+	UpdatedAt     int64                      `json:"updated_at"`
+	DeliveredAt   *int64                     `json:"delivered_at,omitempty"`
+	Payload       json.RawMessage            `json:"payload,omitempty"`
+	AttemptLog    []DeliveryAttemptPrintable `json:"attempt_log,omitempty"`
+}
+
+// ConvertDeliveryToPrintable converts delivery, the payload and attempts are included only if passed
+func ConvertDeliveryToPrintable(d Delivery, withPayload bool, attempts []DeliveryAttempt) DeliveryPrintable {
+	res := DeliveryPrintable{
+		ID:        d.ID.String(),
+		Sender:    d.Sender,
+		EventID:   d.EventID.String(),
+		EventType: d.EventType,
+		Status:    string(d.Status),
+		Attempts:  d.Attempts,
+		CreatedAt: d.CreatedAt.Unix(),
+		UpdatedAt: d.UpdatedAt.Unix(),
+	}
+	if d.WebhookID != nil {
+		res.WebhookID = d.WebhookID.String()
+	}
+	if d.InvoiceID != nil {
+		res.InvoiceID = d.InvoiceID.String()
+	}
+	if d.Status == PendingDeliveryStatus {
+		next := d.NextAttemptAt.Unix()
+		res.NextAttemptAt = &next
+	}
+	if d.LastError != nil {
+		res.LastError = *d.LastError
+	}
+	if d.DeliveredAt != nil {
+		deliveredAt := d.DeliveredAt.Unix()
+		res.DeliveredAt = &deliveredAt
+	}
+	if withPayload {
+		res.Payload = d.Payload
+	}
+	for _, a := range attempts {
+		attempt := DeliveryAttemptPrintable{
+			AttemptedAt: a.AttemptedAt.Unix(),
+			Duration:    a.Duration.Milliseconds(),
+		}
+		if a.Error != nil {
+			attempt.Error = *a.Error
+		}
+		res.AttemptLog = append(res.AttemptLog, attempt)
+	}
+	return res
+}
@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrInternalServerError = errors.New("internal server error")
 	ErrNotFound            = errors.New("not found")
+	ErrAlreadyExists       = errors.New("already exists")
 )
@@ -0,0 +1,19 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Event is an entry of the event log which feeds the event streams.
+// It is saved together with the deliveries of the same notification.
+type Event struct {
+	Seq        int64     // position in the log, used as the stream event ID
+	EventID    uuid.UUID // ID of the invoice lifecycle or subscription event
+	MerchantID uuid.UUID
+	InvoiceID  *InvoiceID
+	Type       string
+	Payload    json.RawMessage // the same payload as in the deliveries
+	CreatedAt  time.Time
+}
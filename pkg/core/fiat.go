@@ -0,0 +1,31 @@
+package core
+
+import (
+	"math/big"
+	"strings"
+)
+
+// FiatPrice is the original fiat price of the invoice and the rate locked at the invoice creation
+type FiatPrice struct {
+	Currency string
+	Amount   *big.Rat
+	Rate     *big.Rat // price of one whole unit of the invoice currency in fiat
+}
+
+// ConvertFiatAmount converts fiat amount to the smallest units of currency rounding up
+func ConvertFiatAmount(fiatAmount, rate *big.Rat, decimals int) *big.Int {
+	units := new(big.Rat).Quo(fiatAmount, rate)
+	units.Mul(units, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	res, remainder := new(big.Int).QuoRem(units.Num(), units.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		res.Add(res, big.NewInt(1))
+	}
+	return res
+}
+
+// FormatDecimal returns decimal representation of the number without trailing zeros
+func FormatDecimal(r *big.Rat) string {
+	s := r.FloatString(18)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestConvertFiatAmount(t *testing.T) {
+	tests := []struct {
+		name       string
+		fiatAmount *big.Rat
+		rate       *big.Rat
+		decimals   int
+		want       string
+	}{
+		{name: "exact", fiatAmount: big.NewRat(13, 1), rate: big.NewRat(13, 4), decimals: 9, want: "4000000000"},
+		{name: "rounded up", fiatAmount: big.NewRat(10, 1), rate: big.NewRat(3, 1), decimals: 9, want: "3333333334"},
+		{name: "rounded up by a fraction of unit", fiatAmount: big.NewRat(1, 1), rate: big.NewRat(3, 1), decimals: 0, want: "1"},
+		{name: "stablecoin", fiatAmount: big.NewRat(1250, 100), rate: big.NewRat(1, 1), decimals: 6, want: "12500000"},
+		{name: "smallest fiat unit", fiatAmount: big.NewRat(1, 100), rate: big.NewRat(325, 100), decimals: 9, want: "3076924"},
+		{name: "zero", fiatAmount: new(big.Rat), rate: big.NewRat(3, 1), decimals: 9, want: "0"},
+	}
+	for _, tt := range tests {
+		got := ConvertFiatAmount(tt.fiatAmount, tt.rate, tt.decimals)
+		if got.String() != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		// the buyer never pays less than the fiat price
+		paid := new(big.Rat).SetFrac(got, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tt.decimals)), nil))
+		if paid.Mul(paid, tt.rate).Cmp(tt.fiatAmount) < 0 {
+			t.Errorf("%s: %v is less than the fiat price", tt.name, got)
+		}
+	}
+}
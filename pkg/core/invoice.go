@@ -13,10 +13,11 @@ import (
 type InvoiceStatus string
 
 const (
-	WaitingInvoiceStatus  InvoiceStatus = "waiting"
-	PaidInvoiceStatus     InvoiceStatus = "paid"
-	CanceledInvoiceStatus InvoiceStatus = "cancelled"
-	ExpiredInvoiceStatus  InvoiceStatus = "expired"
+	WaitingInvoiceStatus       InvoiceStatus = "waiting"
+	PartiallyPaidInvoiceStatus InvoiceStatus = "partially_paid"
+	PaidInvoiceStatus          InvoiceStatus = "paid"
+	CanceledInvoiceStatus      InvoiceStatus = "cancelled"
+	ExpiredInvoiceStatus       InvoiceStatus = "expired"
 )
 
 type Invoice struct {
@@ -25,6 +26,7 @@ type Invoice struct {
 	Status      InvoiceStatus
 	Amount      *big.Int
 	Overpayment *big.Int
+	Received    *big.Int // sum of payments made while the invoice was payable
 	Currency    Currency
 	CreatedAt   time.Time
 	ExpireAt    time.Time
@@ -34,12 +36,70 @@ type Invoice struct {
 	PaidBy      *ton.AccountID
 	PaidAt      *time.Time
 	TxHash      *ton.Bits256
+	// PaidCurrency settled the invoice, it is one of the options if they covered the invoice first.
+	// Currency and Amount keep the original price.
+	PaidCurrency *Currency
+	// ExternalReference is a merchant order reference. It is unique and makes invoice creation idempotent
+	ExternalReference *string
+	BuyerEmail        *string // receives the receipt after the payment
+	Options           []InvoiceOption
+	Fiat              *FiatPrice // nil if the invoice is priced in crypto
+	// SubscriptionID and Cycle link the invoice issued by the subscription
+	SubscriptionID *uuid.UUID
+	Cycle          *int
+	// PaymentRequestID links the child invoice created by the payment to the payment request
+	PaymentRequestID *uuid.UUID
+}
+
+// InvoiceOption is an alternative currency and amount the invoice can be paid with.
+// The invoice becomes paid by the first currency that fully covers its amount, see PaidCurrency.
+type InvoiceOption struct {
+	Currency Currency
+	Amount   *big.Int
+	Received *big.Int
+	Rate     *big.Rat // locked fiat rate for fiat priced invoices
+}
+
+// InvoiceFilter describes invoice history query. Empty fields are not used for filtering.
+type InvoiceFilter struct {
+	After             InvoiceID // empty ID means from the beginning
+	Limit             int64
+	Descending        bool
+	Recipient         *ton.AccountID // invoices of the merchant
+	Statuses          []InvoiceStatus
+	Currency          *Currency
+	CreatedFrom       *time.Time
+	CreatedTo         *time.Time
+	PaidFrom          *time.Time
+	PaidTo            *time.Time
+	PaidBy            *ton.AccountID             // the payer of any of the invoice payments
+	PrivateInfo       map[string]json.RawMessage // JSONB containment
+	ExternalReference *string
+	SubscriptionID    *uuid.UUID
+	PaymentRequestID  *uuid.UUID
+}
+
+// Remaining returns the amount still required to mark the invoice as paid
+func (i Invoice) Remaining() *big.Int {
+	if i.Status == PaidInvoiceStatus {
+		return big.NewInt(0) // the invoice paid by an option can have received less than the amount
+	}
+	remaining := new(big.Int).Sub(i.Amount, i.Received)
+	if remaining.Sign() < 0 {
+		return big.NewInt(0)
+	}
+	return remaining
 }
 
 type PrivateInvoicePrintable struct {
 	PublicInvoicePrintable
-	PrivateInfo map[string]json.RawMessage `json:"private_info"`
-	Metadata    map[string]json.RawMessage `json:"metadata"`
+	PrivateInfo       map[string]json.RawMessage `json:"private_info"`
+	Metadata          map[string]json.RawMessage `json:"metadata"`
+	ExternalReference string                     `json:"external_reference,omitempty"`
+	BuyerEmail        string                     `json:"buyer_email,omitempty"`
+	SubscriptionID    string                     `json:"subscription_id,omitempty"`
+	Cycle             *int                       `json:"cycle,omitempty"`
+	PaymentRequestID  string                     `json:"payment_request_id,omitempty"`
 }
 
 type JettonInfo struct {
@@ -47,6 +107,15 @@ type JettonInfo struct {
 	Decimals int    `json:"decimals"`
 }
 
+type InvoiceOptionPrintable struct {
+	Amount       string            `json:"amount"`
+	Currency     string            `json:"currency"`
+	Received     string            `json:"received"`
+	PaymentLinks map[string]string `json:"payment_links"`
+	JettonInfo   *JettonInfo       `json:"jetton_info,omitempty"`
+	Rate         string            `json:"rate,omitempty"`
+}
+
 type PublicInvoicePrintable struct {
 	ID           string            `json:"id"`
 	Status       string            `json:"status"`
@@ -58,24 +127,28 @@ type PublicInvoicePrintable struct {
 	ExpireAt     int64             `json:"expire_at"`
 	UpdatedAt    int64             `json:"updated_at"`
 	Overpayment  string            `json:"overpayment"`
+	Received     string            `json:"received"`
+	Remaining    string            `json:"remaining"`
 	PaidBy       string            `json:"paid_by,omitempty"`
 	PaidAt       *int64            `json:"paid_at,omitempty"`
 	TxHash       string            `json:"tx_hash,omitempty"`
+	PaidCurrency string            `json:"paid_currency,omitempty"` // ticker of the currency which settled the invoice
 	JettonInfo   *JettonInfo       `json:"jetton_info,omitempty"`
 	Payload      string            `json:"payload"`
+	// Options are alternative currencies for the payment
+	Options []InvoiceOptionPrintable `json:"options,omitempty"`
+	// original fiat price and locked rate of fiat priced invoice
+	FiatCurrency string `json:"fiat_currency,omitempty"`
+	FiatAmount   string `json:"fiat_amount,omitempty"`
+	Rate         string `json:"rate,omitempty"`
 }
 
 func ConvertInvoiceToPrintablePublic(prefixes map[string]string, invoice Invoice, currencies map[string]ExtendedCurrency, adnlAddress *ton.Bits256) (PublicInvoicePrintable, error) {
-	ticker := ""
-	for t, c := range currencies {
-		if c.Currency == invoice.Currency {
-			ticker = t
-		}
-	}
-	if len(ticker) == 0 {
-		return PublicInvoicePrintable{}, fmt.Errorf("currency not found: %s", invoice.Currency.String())
+	ticker, err := currencyTicker(currencies, invoice.Currency)
+	if err != nil {
+		return PublicInvoicePrintable{}, err
 	}
-	payload, err := EncodePayload(invoice, adnlAddress, false)
+	payload, err := EncodePayload(invoice.ID, adnlAddress, false)
 	if err != nil {
 		return PublicInvoicePrintable{}, err
 	}
@@ -89,6 +162,8 @@ func ConvertInvoiceToPrintablePublic(prefixes map[string]string, invoice Invoice
 		ExpireAt:     invoice.ExpireAt.Unix(),
 		UpdatedAt:    invoice.UpdatedAt.Unix(),
 		Overpayment:  invoice.Overpayment.String(),
+		Received:     invoice.Received.String(),
+		Remaining:    invoice.Remaining().String(),
 		PaymentLinks: make(map[string]string, len(prefixes)),
 		Payload:      payload,
 	}
@@ -99,6 +174,35 @@ func ConvertInvoiceToPrintablePublic(prefixes map[string]string, invoice Invoice
 		}
 		res.PaymentLinks[name] = paymentLink
 	}
+	for _, option := range invoice.Options {
+		optionTicker, err := currencyTicker(currencies, option.Currency)
+		if err != nil {
+			return PublicInvoicePrintable{}, err
+		}
+		optionP := InvoiceOptionPrintable{
+			Amount:       option.Amount.String(),
+			Currency:     optionTicker,
+			Received:     option.Received.String(),
+			PaymentLinks: make(map[string]string, len(prefixes)),
+			JettonInfo:   jettonInfo(option.Currency, currencies[optionTicker]),
+		}
+		if option.Rate != nil {
+			optionP.Rate = FormatDecimal(option.Rate)
+		}
+		for name, prefix := range prefixes {
+			paymentLink, err := generatePaymentLink(prefix, invoice.ID, invoice.Recipient, option.Currency, option.Amount, &invoice.ExpireAt, adnlAddress)
+			if err != nil {
+				return PublicInvoicePrintable{}, err
+			}
+			optionP.PaymentLinks[name] = paymentLink
+		}
+		res.Options = append(res.Options, optionP)
+	}
+	if invoice.Fiat != nil {
+		res.FiatCurrency = invoice.Fiat.Currency
+		res.FiatAmount = FormatDecimal(invoice.Fiat.Amount)
+		res.Rate = FormatDecimal(invoice.Fiat.Rate)
+	}
 	if invoice.PaidAt != nil {
 		paidAt := invoice.PaidAt.Unix()
 		res.PaidAt = &paidAt
@@ -109,34 +213,159 @@ func ConvertInvoiceToPrintablePublic(prefixes map[string]string, invoice Invoice
 	if invoice.TxHash != nil {
 		res.TxHash = invoice.TxHash.Hex()
 	}
-	if invoice.Currency.Type == Jetton {
-		res.JettonInfo = &JettonInfo{
-			Address:  invoice.Currency.Jetton().ToRaw(),
-			Decimals: currencies[ticker].JettonDecimals,
+	if invoice.PaidCurrency != nil {
+		res.PaidCurrency, err = currencyTicker(currencies, *invoice.PaidCurrency)
+		if err != nil {
+			return PublicInvoicePrintable{}, err
 		}
 	}
+	res.JettonInfo = jettonInfo(invoice.Currency, currencies[ticker])
 	return res, nil
 }
 
+func jettonInfo(currency Currency, extended ExtendedCurrency) *JettonInfo {
+	if currency.Type != Jetton {
+		return nil
+	}
+	return &JettonInfo{
+		Address:  currency.Jetton().ToRaw(),
+		Decimals: extended.JettonDecimals,
+	}
+}
+
 func ConvertInvoiceToPrintablePrivate(prefixes map[string]string, invoice Invoice, currencies map[string]ExtendedCurrency, adnlAddress *ton.Bits256) (PrivateInvoicePrintable, error) {
 	publicInvoice, err := ConvertInvoiceToPrintablePublic(prefixes, invoice, currencies, adnlAddress)
 	if err != nil {
 		return PrivateInvoicePrintable{}, err
 	}
-	return PrivateInvoicePrintable{
+	res := PrivateInvoicePrintable{
 		PublicInvoicePrintable: publicInvoice,
 		PrivateInfo:            invoice.PrivateInfo,
 		Metadata:               invoice.Metadata,
-	}, nil
+	}
+	if invoice.ExternalReference != nil {
+		res.ExternalReference = *invoice.ExternalReference
+	}
+	if invoice.BuyerEmail != nil {
+		res.BuyerEmail = *invoice.BuyerEmail
+	}
+	if invoice.SubscriptionID != nil {
+		res.SubscriptionID = invoice.SubscriptionID.String()
+		res.Cycle = invoice.Cycle
+	}
+	if invoice.PaymentRequestID != nil {
+		res.PaymentRequestID = invoice.PaymentRequestID.String()
+	}
+	return res, nil
 }
 
 type Payment struct {
-	InvoiceID InvoiceID
+	InvoiceID InvoiceID // invoice or payment request ID, empty if the comment is not a valid ID
+	Comment   string    // raw invoice ID or text comment attached to the transfer
 	Currency  Currency
 	Amount    *big.Int
 	PaidBy    ton.AccountID
 	Recipient ton.AccountID
 	TxHash    ton.Bits256
+	Lt        uint64
+	CreatedAt time.Time // transaction time
+}
+
+type PaymentPrintable struct {
+	InvoiceID string `json:"invoice_id"`
+	TxHash    string `json:"tx_hash"`
+	Lt        uint64 `json:"lt"`
+	PaidBy    string `json:"paid_by"`
+	Amount    string `json:"amount"`
+	Currency  string `json:"currency"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func ConvertPaymentToPrintable(payment Payment, currencies map[string]ExtendedCurrency) (PaymentPrintable, error) {
+	ticker, err := currencyTicker(currencies, payment.Currency)
+	if err != nil {
+		return PaymentPrintable{}, err
+	}
+	return PaymentPrintable{
+		InvoiceID: payment.InvoiceID.String(),
+		TxHash:    payment.TxHash.Hex(),
+		Lt:        payment.Lt,
+		PaidBy:    payment.PaidBy.ToRaw(),
+		Amount:    payment.Amount.String(),
+		Currency:  ticker,
+		CreatedAt: payment.CreatedAt.Unix(),
+	}, nil
+}
+
+type UnmatchedPaymentReason string
+
+const (
+	InvalidCommentUnmatchedReason   UnmatchedPaymentReason = "invalid_comment"
+	UnknownInvoiceUnmatchedReason   UnmatchedPaymentReason = "unknown_invoice"
+	CurrencyMismatchUnmatchedReason UnmatchedPaymentReason = "currency_mismatch"
+	BelowMinimumUnmatchedReason     UnmatchedPaymentReason = "below_minimum"    // payment to the payment request is less than minimum
+	DisabledRequestUnmatchedReason  UnmatchedPaymentReason = "request_disabled" // payment to the disabled payment request
+)
+
+// UnmatchedPayment is an incoming transfer that could not be linked to any invoice
+type UnmatchedPayment struct {
+	ID         uuid.UUID
+	Payment    Payment
+	Reason     UnmatchedPaymentReason
+	ReceivedAt time.Time
+	AttachedTo *InvoiceID
+	AttachedAt *time.Time
+}
+
+type UnmatchedPaymentPrintable struct {
+	ID         string `json:"id"`
+	TxHash     string `json:"tx_hash"`
+	Lt         uint64 `json:"lt"`
+	PaidBy     string `json:"paid_by"`
+	Amount     string `json:"amount"`
+	Currency   string `json:"currency"`
+	Comment    string `json:"comment"`
+	Reason     string `json:"reason"`
+	CreatedAt  int64  `json:"created_at"`
+	ReceivedAt int64  `json:"received_at"`
+	AttachedTo string `json:"attached_to,omitempty"`
+	AttachedAt *int64 `json:"attached_at,omitempty"`
+}
+
+func ConvertUnmatchedPaymentToPrintable(payment UnmatchedPayment, currencies map[string]ExtendedCurrency) (UnmatchedPaymentPrintable, error) {
+	ticker, err := currencyTicker(currencies, payment.Payment.Currency)
+	if err != nil {
+		return UnmatchedPaymentPrintable{}, err
+	}
+	res := UnmatchedPaymentPrintable{
+		ID:         payment.ID.String(),
+		TxHash:     payment.Payment.TxHash.Hex(),
+		Lt:         payment.Payment.Lt,
+		PaidBy:     payment.Payment.PaidBy.ToRaw(),
+		Amount:     payment.Payment.Amount.String(),
+		Currency:   ticker,
+		Comment:    payment.Payment.Comment,
+		Reason:     string(payment.Reason),
+		CreatedAt:  payment.Payment.CreatedAt.Unix(),
+		ReceivedAt: payment.ReceivedAt.Unix(),
+	}
+	if payment.AttachedTo != nil {
+		res.AttachedTo = payment.AttachedTo.String()
+	}
+	if payment.AttachedAt != nil {
+		attachedAt := payment.AttachedAt.Unix()
+		res.AttachedAt = &attachedAt
+	}
+	return res, nil
+}
+
+func currencyTicker(currencies map[string]ExtendedCurrency, currency Currency) (string, error) {
+	for t, c := range currencies {
+		if c.Currency == currency {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("currency not found: %s", currency.String())
 }
 
 type InvoiceID = uuid.UUID
@@ -166,21 +395,33 @@ var DefaultPaymentPrefixes = map[string]string{
 }
 
 func GeneratePaymentLink(prefix string, invoice Invoice, adnlAddress *ton.Bits256) (string, error) {
-	payload, err := EncodePayload(invoice, adnlAddress, true)
+	return generatePaymentLink(prefix, invoice.ID, invoice.Recipient, invoice.Currency, invoice.Amount, &invoice.ExpireAt, adnlAddress)
+}
+
+// generatePaymentLink returns link for invoice or payment request. Empty amount lets the payer choose it.
+func generatePaymentLink(prefix string, id uuid.UUID, recipient ton.AccountID, currency Currency, amount *big.Int, expireAt *time.Time, adnlAddress *ton.Bits256) (string, error) {
+	payload, err := EncodePayload(id, adnlAddress, true)
 	if err != nil {
 		return "", err
 	}
-	switch invoice.Currency.Type {
+	var params string
+	if amount != nil {
+		params += fmt.Sprintf("&amount=%d", amount)
+	}
+	params += fmt.Sprintf("&bin=%s", payload)
+	if expireAt != nil {
+		params += fmt.Sprintf("&exp=%d", expireAt.Unix())
+	}
+	switch currency.Type {
 	case TON:
 		// {prefix}transfer/{address}?amount={elementary-units}&bin={base64url-binary-data}&exp={expiry-timestamp}
-		link := fmt.Sprintf("%stransfer/%s?amount=%d&bin=%s&exp=%d",
-			prefix, invoice.Recipient.ToHuman(false, false), invoice.Amount, payload, invoice.ExpireAt.Unix())
+		link := fmt.Sprintf("%stransfer/%s?%s",
+			prefix, recipient.ToHuman(false, false), params[1:])
 		return link, nil
 	case Jetton:
 		// {prefix}transfer/{destination-address}?jetton={jetton-master-address}&amount={elementary-units}&bin={base64url-binary-data}&exp={expiry-timestamp}
-		link := fmt.Sprintf("%stransfer/%s?jetton=%s&amount=%d&bin=%s&exp=%d",
-			prefix, invoice.Recipient.ToHuman(true, false), invoice.Currency.Jetton().ToHuman(true, false),
-			invoice.Amount, payload, invoice.ExpireAt.Unix())
+		link := fmt.Sprintf("%stransfer/%s?jetton=%s%s",
+			prefix, recipient.ToHuman(true, false), currency.Jetton().ToHuman(true, false), params)
 		return link, nil
 	case Extra:
 		// TODO: implement
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// EventSchemaVersion is the version of the EventEnvelope layout. It is increased on incompatible changes.
+const EventSchemaVersion = 1
+
+// Invoice lifecycle event types
+const (
+	InvoiceCreatedEvent   = "invoice.created"
+	InvoicePaidEvent      = "invoice.paid"
+	InvoiceExpiredEvent   = "invoice.expired"
+	InvoiceCancelledEvent = "invoice.cancelled"
+	// PaymentReceivedEvent is a payment to the payable invoice, it goes before InvoicePaidEvent if the invoice becomes paid
+	PaymentReceivedEvent = "payment.received"
+	// OverpaymentReceivedEvent is a payment to the invoice which can no longer be paid (paid, cancelled or expired)
+	OverpaymentReceivedEvent = "overpayment.received"
+)
+
+// eventNamespace is the namespace of stable event IDs
+var eventNamespace = uuid.MustParse("5b0b2a4e-6f3d-4c1a-9a5e-3c7d2e8f1b60")
+
+// InvoiceEventID returns the stable ID of the invoice event: the same change of the invoice always gets the same ID,
+// so consumers can deduplicate events. Payment events are identified by the payment, the other events happen once per invoice.
+func InvoiceEventID(eventType string, invoiceID InvoiceID, payment *Payment) uuid.UUID {
+	name := invoiceID.String() + "/" + eventType
+	if payment != nil {
+		name += "/" + payment.TxHash.Hex() + "/" + payment.Currency.String()
+	}
+	return uuid.NewSHA1(eventNamespace, []byte(name))
+}
+
+// SubscriptionEventID returns the stable ID of the subscription event, every event type happens once per cycle
+func SubscriptionEventID(subscriptionID uuid.UUID, eventType SubscriptionEventType, cycle int) uuid.UUID {
+	return uuid.NewSHA1(eventNamespace, []byte(fmt.Sprintf("%s/%s/%d", subscriptionID, eventType, cycle)))
+}
+
+// InvoiceEvent is a change of the invoice waiting for notification
+type InvoiceEvent struct {
+	ID             uuid.UUID
+	InvoiceID      InvoiceID
+	Type           string
+	PreviousStatus *InvoiceStatus // nil if the invoice did not exist before the event
+	Payment        *Payment       // set for payment events
+	CreatedAt      time.Time
+}
+
+func NewInvoiceEvent(eventType string, invoiceID InvoiceID, previousStatus *InvoiceStatus, payment *Payment, now time.Time) InvoiceEvent {
+	return InvoiceEvent{
+		ID:             InvoiceEventID(eventType, invoiceID, payment),
+		InvoiceID:      invoiceID,
+		Type:           eventType,
+		PreviousStatus: previousStatus,
+		Payment:        payment,
+		CreatedAt:      now,
+	}
+}
+
+// EventEnvelope is the payload of every notification. Type defines which of the optional fields are set:
+// Invoice for invoice and payment events, Payment for payment events, SubscriptionEvent for subscription events.
+type EventEnvelope struct {
+	ID                string                      `json:"id"`
+	Type              string                      `json:"type"`
+	SchemaVersion     int                         `json:"schema_version"`
+	CreatedAt         int64                       `json:"created_at"`
+	PreviousStatus    string                      `json:"previous_status,omitempty"`
+	Invoice           *PrivateInvoicePrintable    `json:"invoice,omitempty"` // current state of the invoice
+	Payment           *PaymentPrintable           `json:"payment,omitempty"`
+	SubscriptionEvent *SubscriptionEventPrintable `json:"subscription_event,omitempty"`
+}
+
+func NewInvoiceEnvelope(event InvoiceEvent, invoice PrivateInvoicePrintable, payment *PaymentPrintable) EventEnvelope {
+	res := EventEnvelope{
+		ID:            event.ID.String(),
+		Type:          event.Type,
+		SchemaVersion: EventSchemaVersion,
+		CreatedAt:     event.CreatedAt.Unix(),
+		Invoice:       &invoice,
+		Payment:       payment,
+	}
+	if event.PreviousStatus != nil {
+		res.PreviousStatus = string(*event.PreviousStatus)
+	}
+	return res
+}
+
+func NewSubscriptionEnvelope(id uuid.UUID, event SubscriptionEventPrintable) EventEnvelope {
+	return EventEnvelope{
+		ID:                id.String(),
+		Type:              event.Event,
+		SchemaVersion:     EventSchemaVersion,
+		CreatedAt:         event.CreatedAt,
+		SubscriptionEvent: &event,
+	}
+}
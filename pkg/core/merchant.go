@@ -0,0 +1,108 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/tonkeeper/tongo/ton"
+	"slices"
+	"time"
+)
+
+// DefaultMerchantID is the ID of the merchant configured by RECIPIENT and TOKEN. It owns the data created before merchants
+// were introduced and receives notifications of the configured senders.
+var DefaultMerchantID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// Merchant owns its recipient wallet and everything paid to it: invoices, subscriptions, payment requests, payments,
+// webhooks and deliveries. The recipient can not be changed because invoices are matched to payments by it.
+type Merchant struct {
+	ID         uuid.UUID
+	Name       string
+	Recipient  ton.AccountID
+	Currencies []string // accepted tickers, empty accepts all enabled currencies
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Accepts reports whether the merchant accepts payments in the currency
+func (m Merchant) Accepts(ticker string) bool {
+	return len(m.Currencies) == 0 || slices.Contains(m.Currencies, ticker)
+}
+
+// MerchantToken grants access to the private API on behalf of the merchant. Only the hash of the token is stored.
+type MerchantToken struct {
+	ID         uuid.UUID
+	MerchantID uuid.UUID
+	Name       string
+	Hash       []byte
+	CreatedAt  time.Time
+}
+
+// NewMerchantToken generates a random token. The token is returned once and can not be restored from the hash.
+func NewMerchantToken(merchantID uuid.UUID, name string) (MerchantToken, string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return MerchantToken{}, "", err
+	}
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return MerchantToken{}, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return MerchantToken{
+		ID:         id,
+		MerchantID: merchantID,
+		Name:       name,
+		Hash:       HashMerchantToken(token),
+		CreatedAt:  time.Now(),
+	}, token, nil
+}
+
+func HashMerchantToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+type MerchantPrintable struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Recipient  string   `json:"recipient"`
+	Currencies []string `json:"currencies"`
+	CreatedAt  int64    `json:"created_at"`
+	UpdatedAt  int64    `json:"updated_at"`
+}
+
+func ConvertMerchantToPrintable(m Merchant) MerchantPrintable {
+	res := MerchantPrintable{
+		ID:         m.ID.String(),
+		Name:       m.Name,
+		Recipient:  m.Recipient.ToRaw(),
+		Currencies: m.Currencies,
+		CreatedAt:  m.CreatedAt.Unix(),
+		UpdatedAt:  m.UpdatedAt.Unix(),
+	}
+	if res.Currencies == nil {
+		res.Currencies = []string{}
+	}
+	return res
+}
+
+type MerchantTokenPrintable struct {
+	ID         string `json:"id"`
+	MerchantID string `json:"merchant_id"`
+	Name       string `json:"name"`
+	Token      string `json:"token,omitempty"` // only in the response to the creation
+	CreatedAt  int64  `json:"created_at"`
+}
+
+func ConvertMerchantTokenToPrintable(t MerchantToken, token string) MerchantTokenPrintable {
+	return MerchantTokenPrintable{
+		ID:         t.ID.String(),
+		MerchantID: t.MerchantID.String(),
+		Name:       t.Name,
+		Token:      token,
+		CreatedAt:  t.CreatedAt.Unix(),
+	}
+}
@@ -2,15 +2,17 @@ package core
 
 import (
 	"encoding/base64"
+	"github.com/google/uuid"
 	"github.com/tonkeeper/tongo/abi"
 	"github.com/tonkeeper/tongo/boc"
 	"github.com/tonkeeper/tongo/tlb"
 	"github.com/tonkeeper/tongo/ton"
 )
 
-func EncodePayload(invoice Invoice, adnlAddress *ton.Bits256, urlSafe bool) (string, error) {
+// EncodePayload encodes invoice or payment request ID into the transfer payload
+func EncodePayload(id uuid.UUID, adnlAddress *ton.Bits256, urlSafe bool) (string, error) {
 	payload := abi.InvoicePayloadMsgBody{
-		Id:  tlb.Bits128(invoice.ID),
+		Id:  tlb.Bits128(id),
 		Url: abi.PaymentProviderUrl{SumType: "None"},
 	}
 	if adnlAddress != nil {
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/tonkeeper/tongo/ton"
+	"math/big"
+	"time"
+)
+
+type PaymentRequestStatus string
+
+const (
+	ActivePaymentRequestStatus   PaymentRequestStatus = "active"
+	DisabledPaymentRequestStatus PaymentRequestStatus = "disabled"
+)
+
+// PaymentRequest is a reusable payment destination (e.g. donation link or QR code at the counter).
+// Its ID is used in the payload instead of the invoice ID and every payment to it creates a paid child invoice.
+type PaymentRequest struct {
+	ID          uuid.UUID
+	Status      PaymentRequestStatus
+	Recipient   ton.AccountID
+	Currency    Currency
+	MinAmount   *big.Int // payments below are not accepted
+	Amount      *big.Int // suggested amount for payment links, nil lets the payer choose it
+	PrivateInfo map[string]json.RawMessage
+	Metadata    map[string]json.RawMessage
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// NewChildInvoice creates a paid invoice for the payment to the request
+func (r PaymentRequest) NewChildInvoice(p Payment, now time.Time) Invoice {
+	id := r.ID
+	return Invoice{
+		ID:               NewInvoiceID(),
+		Recipient:        r.Recipient,
+		Status:           PaidInvoiceStatus,
+		Amount:           new(big.Int).Set(p.Amount),
+		Overpayment:      big.NewInt(0),
+		Received:         new(big.Int).Set(p.Amount),
+		Currency:         r.Currency,
+		CreatedAt:        now,
+		ExpireAt:         now,
+		UpdatedAt:        now,
+		PrivateInfo:      r.PrivateInfo,
+		Metadata:         r.Metadata,
+		PaidBy:           &p.PaidBy,
+		PaidAt:           &now,
+		TxHash:           &p.TxHash,
+		PaidCurrency:     &r.Currency,
+		PaymentRequestID: &id,
+	}
+}
+
+type PaymentRequestPrintable struct {
+	ID           string                     `json:"id"`
+	Status       string                     `json:"status"`
+	Currency     string                     `json:"currency"`
+	MinAmount    string                     `json:"min_amount"`
+	Amount       string                     `json:"amount,omitempty"`
+	Recipient    string                     `json:"pay_to_address"`
+	PaymentLinks map[string]string          `json:"payment_links"`
+	JettonInfo   *JettonInfo                `json:"jetton_info,omitempty"`
+	Payload      string                     `json:"payload"`
+	PrivateInfo  map[string]json.RawMessage `json:"private_info"`
+	Metadata     map[string]json.RawMessage `json:"metadata"`
+	CreatedAt    int64                      `json:"created_at"`
+	UpdatedAt    int64                      `json:"updated_at"`
+}
+
+func ConvertPaymentRequestToPrintable(prefixes map[string]string, r PaymentRequest, currencies map[string]ExtendedCurrency, adnlAddress *ton.Bits256) (PaymentRequestPrintable, error) {
+	ticker, err := currencyTicker(currencies, r.Currency)
+	if err != nil {
+		return PaymentRequestPrintable{}, err
+	}
+	payload, err := EncodePayload(r.ID, adnlAddress, false)
+	if err != nil {
+		return PaymentRequestPrintable{}, err
+	}
+	res := PaymentRequestPrintable{
+		ID:           r.ID.String(),
+		Status:       string(r.Status),
+		Currency:     ticker,
+		MinAmount:    r.MinAmount.String(),
+		Recipient:    r.Recipient.ToRaw(),
+		PaymentLinks: make(map[string]string),
+		JettonInfo:   jettonInfo(r.Currency, currencies[ticker]),
+		Payload:      payload,
+		PrivateInfo:  r.PrivateInfo,
+		Metadata:     r.Metadata,
+		CreatedAt:    r.CreatedAt.Unix(),
+		UpdatedAt:    r.UpdatedAt.Unix(),
+	}
+	if r.Amount != nil {
+		res.Amount = r.Amount.String()
+	}
+	for name, prefix := range prefixes {
+		paymentLink, err := GenerateRequestPaymentLink(prefix, r, adnlAddress)
+		if err != nil {
+			return PaymentRequestPrintable{}, err
+		}
+		res.PaymentLinks[name] = paymentLink
+	}
+	return res, nil
+}
+
+// GenerateRequestPaymentLink returns link without expiration. Without the suggested amount the payer chooses it.
+func GenerateRequestPaymentLink(prefix string, r PaymentRequest, adnlAddress *ton.Bits256) (string, error) {
+	return generatePaymentLink(prefix, r.ID, r.Recipient, r.Currency, r.Amount, nil, adnlAddress)
+}
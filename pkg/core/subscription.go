@@ -0,0 +1,215 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/tonkeeper/tongo/ton"
+	"math/big"
+	"time"
+)
+
+type SubscriptionStatus string
+
+const (
+	ActiveSubscriptionStatus   SubscriptionStatus = "active"
+	FinishedSubscriptionStatus SubscriptionStatus = "finished"
+	CanceledSubscriptionStatus SubscriptionStatus = "cancelled"
+)
+
+type SubscriptionPeriod string
+
+const (
+	DailySubscriptionPeriod   SubscriptionPeriod = "day"
+	WeeklySubscriptionPeriod  SubscriptionPeriod = "week"
+	MonthlySubscriptionPeriod SubscriptionPeriod = "month"
+	YearlySubscriptionPeriod  SubscriptionPeriod = "year"
+)
+
+func ParseSubscriptionPeriod(s string) (SubscriptionPeriod, error) {
+	switch p := SubscriptionPeriod(s); p {
+	case DailySubscriptionPeriod, WeeklySubscriptionPeriod, MonthlySubscriptionPeriod, YearlySubscriptionPeriod:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid subscription period: %s", s)
+}
+
+// Subscription issues a new invoice from the template every Interval periods starting from StartAt
+type Subscription struct {
+	ID           uuid.UUID
+	Status       SubscriptionStatus
+	Recipient    ton.AccountID
+	Template     SubscriptionTemplate
+	Period       SubscriptionPeriod
+	Interval     int
+	StartAt      time.Time
+	EndAt        *time.Time // nil for endless subscription
+	NotifyBefore time.Duration
+	NextCycle    int // number of the next cycle to issue starting from 0
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// SubscriptionTemplate is used for creation of the invoice of every cycle
+type SubscriptionTemplate struct {
+	Amount      *big.Int
+	Currency    Currency
+	LifeTime    time.Duration
+	PrivateInfo map[string]json.RawMessage
+	Metadata    map[string]json.RawMessage
+}
+
+// CycleAt returns the time when the invoice of the cycle must be issued
+func (s Subscription) CycleAt(cycle int) time.Time {
+	n := cycle * s.Interval
+	switch s.Period {
+	case DailySubscriptionPeriod:
+		return s.StartAt.AddDate(0, 0, n)
+	case WeeklySubscriptionPeriod:
+		return s.StartAt.AddDate(0, 0, 7*n)
+	case MonthlySubscriptionPeriod:
+		return addMonths(s.StartAt, n)
+	default:
+		return addMonths(s.StartAt, 12*n)
+	}
+}
+
+// addMonths adds months keeping the day of the month. The day is clamped to the last day of the target month,
+// so cycles started on Jan 31 are issued on the last day of February and do not drift.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	month += time.Month(months)
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	return time.Date(year, month, min(day, lastDay), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// HasCycle returns false if the cycle starts after the end of subscription
+func (s Subscription) HasCycle(cycle int) bool {
+	return s.EndAt == nil || s.CycleAt(cycle).Before(*s.EndAt)
+}
+
+// NewCycleInvoice creates an invoice of the cycle from the template. The invoice expires after template life time from the cycle start.
+func (s Subscription) NewCycleInvoice(cycle int, now time.Time) Invoice {
+	id := s.ID
+	return Invoice{
+		ID:             NewInvoiceID(),
+		Recipient:      s.Recipient,
+		Status:         WaitingInvoiceStatus,
+		Amount:         new(big.Int).Set(s.Template.Amount),
+		Overpayment:    big.NewInt(0),
+		Received:       big.NewInt(0),
+		Currency:       s.Template.Currency,
+		CreatedAt:      now,
+		ExpireAt:       s.CycleAt(cycle).Add(s.Template.LifeTime),
+		UpdatedAt:      now,
+		PrivateInfo:    s.Template.PrivateInfo,
+		Metadata:       s.Template.Metadata,
+		SubscriptionID: &id,
+		Cycle:          &cycle,
+	}
+}
+
+type SubscriptionEventType string
+
+const (
+	UpcomingSubscriptionEvent SubscriptionEventType = "upcoming"
+	CreatedSubscriptionEvent  SubscriptionEventType = "created"
+	MissedSubscriptionEvent   SubscriptionEventType = "missed"
+)
+
+// SubscriptionEvent is a notification about the subscription cycle
+type SubscriptionEvent struct {
+	ID             uuid.UUID
+	SubscriptionID uuid.UUID
+	Type           SubscriptionEventType
+	Cycle          int
+	CycleAt        time.Time
+	InvoiceID      *InvoiceID // nil for upcoming cycle and for cycle missed while the service was not running
+	CreatedAt      time.Time
+}
+
+type SubscriptionTemplatePrintable struct {
+	Amount      string                     `json:"amount"`
+	Currency    string                     `json:"currency"`
+	LifeTime    int64                      `json:"life_time"`
+	PrivateInfo map[string]json.RawMessage `json:"private_info"`
+	Metadata    map[string]json.RawMessage `json:"metadata"`
+}
+
+type SubscriptionPrintable struct {
+	ID           string                        `json:"id"`
+	Status       string                        `json:"status"`
+	Template     SubscriptionTemplatePrintable `json:"template"`
+	Period       string                        `json:"period"`
+	Interval     int                           `json:"interval"`
+	StartAt      int64                         `json:"start_at"`
+	EndAt        *int64                        `json:"end_at,omitempty"`
+	NotifyBefore int64                         `json:"notify_before"`
+	NextCycle    int                           `json:"next_cycle"`
+	NextCycleAt  *int64                        `json:"next_cycle_at,omitempty"`
+	CreatedAt    int64                         `json:"created_at"`
+	UpdatedAt    int64                         `json:"updated_at"`
+}
+
+func ConvertSubscriptionToPrintable(s Subscription, currencies map[string]ExtendedCurrency) (SubscriptionPrintable, error) {
+	ticker, err := currencyTicker(currencies, s.Template.Currency)
+	if err != nil {
+		return SubscriptionPrintable{}, err
+	}
+	res := SubscriptionPrintable{
+		ID:     s.ID.String(),
+		Status: string(s.Status),
+		Template: SubscriptionTemplatePrintable{
+			Amount:      s.Template.Amount.String(),
+			Currency:    ticker,
+			LifeTime:    int64(s.Template.LifeTime.Seconds()),
+			PrivateInfo: s.Template.PrivateInfo,
+			Metadata:    s.Template.Metadata,
+		},
+		Period:       string(s.Period),
+		Interval:     s.Interval,
+		StartAt:      s.StartAt.Unix(),
+		NotifyBefore: int64(s.NotifyBefore.Seconds()),
+		NextCycle:    s.NextCycle,
+		CreatedAt:    s.CreatedAt.Unix(),
+		UpdatedAt:    s.UpdatedAt.Unix(),
+	}
+	if s.EndAt != nil {
+		endAt := s.EndAt.Unix()
+		res.EndAt = &endAt
+	}
+	if s.Status == ActiveSubscriptionStatus && s.HasCycle(s.NextCycle) {
+		nextCycleAt := s.CycleAt(s.NextCycle).Unix()
+		res.NextCycleAt = &nextCycleAt
+	}
+	return res, nil
+}
+
+type SubscriptionEventPrintable struct {
+	Event          string                `json:"event"`
+	SubscriptionID string                `json:"subscription_id"`
+	Cycle          int                   `json:"cycle"`
+	CycleAt        int64                 `json:"cycle_at"`
+	InvoiceID      string                `json:"invoice_id,omitempty"`
+	CreatedAt      int64                 `json:"created_at"`
+	Subscription   SubscriptionPrintable `json:"subscription"`
+}
+
+func ConvertSubscriptionEventToPrintable(event SubscriptionEvent, s Subscription, currencies map[string]ExtendedCurrency) (SubscriptionEventPrintable, error) {
+	subscription, err := ConvertSubscriptionToPrintable(s, currencies)
+	if err != nil {
+		return SubscriptionEventPrintable{}, err
+	}
+	res := SubscriptionEventPrintable{
+		Event:          SubscriptionEventTypeName(event.Type),
+		SubscriptionID: event.SubscriptionID.String(),
+		Cycle:          event.Cycle,
+		CycleAt:        event.CycleAt.Unix(),
+		CreatedAt:      event.CreatedAt.Unix(),
+		Subscription:   subscription,
+	}
+	if event.InvoiceID != nil {
+		res.InvoiceID = event.InvoiceID.String()
+	}
+	return res, nil
+}
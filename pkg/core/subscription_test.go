@@ -0,0 +1,64 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCycleAt(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 10, 30, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		period   SubscriptionPeriod
+		interval int
+		start    time.Time
+		want     map[int]time.Time // cycle -> time
+	}{
+		{
+			name:     "monthly from the end of month",
+			period:   MonthlySubscriptionPeriod,
+			interval: 1,
+			start:    date(2025, time.January, 31),
+			want: map[int]time.Time{0: date(2025, time.January, 31), 1: date(2025, time.February, 28), 2: date(2025, time.March, 31),
+				3: date(2025, time.April, 30), 13: date(2026, time.February, 28)},
+		},
+		{
+			name:     "monthly in leap year",
+			period:   MonthlySubscriptionPeriod,
+			interval: 1,
+			start:    date(2023, time.December, 30),
+			want:     map[int]time.Time{1: date(2024, time.January, 30), 2: date(2024, time.February, 29), 3: date(2024, time.March, 30)},
+		},
+		{
+			name:     "quarterly",
+			period:   MonthlySubscriptionPeriod,
+			interval: 3,
+			start:    date(2025, time.November, 30),
+			want:     map[int]time.Time{1: date(2026, time.February, 28), 2: date(2026, time.May, 30)},
+		},
+		{
+			name:     "yearly from Feb 29",
+			period:   YearlySubscriptionPeriod,
+			interval: 1,
+			start:    date(2024, time.February, 29),
+			want:     map[int]time.Time{1: date(2025, time.February, 28), 2: date(2026, time.February, 28), 4: date(2028, time.February, 29)},
+		},
+		{
+			name:     "weekly",
+			period:   WeeklySubscriptionPeriod,
+			interval: 2,
+			start:    date(2025, time.February, 20),
+			want:     map[int]time.Time{1: date(2025, time.March, 6)},
+		},
+	}
+	for _, tt := range tests {
+		s := Subscription{Period: tt.period, Interval: tt.interval, StartAt: tt.start}
+		for cycle, want := range tt.want {
+			if got := s.CycleAt(cycle); !got.Equal(want) {
+				t.Errorf("%s: cycle %d: got %v, want %v", tt.name, cycle, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Webhook is a subscription of an endpoint to notifications.
+// Empty EventTypes and Currencies match all events and currencies.
+type Webhook struct {
+	ID             uuid.UUID
+	MerchantID     uuid.UUID
+	URL            string
+	Secret         string
+	PreviousSecret *string // still signs deliveries during the secret rotation
+	EventTypes     []string
+	Currencies     []string // tickers
+	Enabled        bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// WebhookEventTypes are the event types which can be used in webhook filters
+var WebhookEventTypes = []string{
+	InvoiceCreatedEvent,
+	InvoicePaidEvent,
+	InvoiceExpiredEvent,
+	InvoiceCancelledEvent,
+	PaymentReceivedEvent,
+	OverpaymentReceivedEvent,
+	SubscriptionEventTypeName(UpcomingSubscriptionEvent),
+	SubscriptionEventTypeName(CreatedSubscriptionEvent),
+	SubscriptionEventTypeName(MissedSubscriptionEvent),
+}
+
+func SubscriptionEventTypeName(t SubscriptionEventType) string {
+	return "subscription." + string(t)
+}
+
+func ValidateWebhookEventType(eventType string) error {
+	if !slices.Contains(WebhookEventTypes, eventType) {
+		return fmt.Errorf("unknown event type: %s", eventType)
+	}
+	return nil
+}
+
+// Secrets returns secrets for signing deliveries, the current one goes first
+func (w Webhook) Secrets() []string {
+	if w.PreviousSecret != nil {
+		return []string{w.Secret, *w.PreviousSecret}
+	}
+	return []string{w.Secret}
+}
+
+// Matches reports whether the webhook is subscribed to the event
+func (w Webhook) Matches(eventType, ticker string) bool {
+	if !w.Enabled {
+		return false
+	}
+	if len(w.EventTypes) > 0 && !slices.Contains(w.EventTypes, eventType) {
+		return false
+	}
+	if len(w.Currencies) > 0 && !slices.Contains(w.Currencies, ticker) {
+		return false
+	}
+	return true
+}
+
+type WebhookPrintable struct {
+	ID             string   `json:"id"`
+	URL            string   `json:"url"`
+	Secret         string   `json:"secret"`
+	PreviousSecret string   `json:"previous_secret,omitempty"`
+	EventTypes     []string `json:"event_types"`
+	Currencies     []string `json:"currencies"`
+	Enabled        bool     `json:"enabled"`
+	CreatedAt      int64    `json:"created_at"`
+	UpdatedAt      int64    `json:"updated_at"`
+}
+
+// ConvertWebhookToPrintable converts the webhook with masked secrets. Secrets are shown only if withSecrets is set,
+// which is used in responses to the creation and the rotation of the secret.
+func ConvertWebhookToPrintable(w Webhook, withSecrets bool) WebhookPrintable {
+	res := WebhookPrintable{
+		ID:         w.ID.String(),
+		URL:        w.URL,
+		Secret:     MaskWebhookSecret(w.Secret),
+		EventTypes: w.EventTypes,
+		Currencies: w.Currencies,
+		Enabled:    w.Enabled,
+		CreatedAt:  w.CreatedAt.Unix(),
+		UpdatedAt:  w.UpdatedAt.Unix(),
+	}
+	if w.PreviousSecret != nil {
+		res.PreviousSecret = MaskWebhookSecret(*w.PreviousSecret)
+	}
+	if withSecrets {
+		res.Secret = w.Secret
+		if w.PreviousSecret != nil {
+			res.PreviousSecret = *w.PreviousSecret
+		}
+	}
+	if res.EventTypes == nil {
+		res.EventTypes = []string{}
+	}
+	if res.Currencies == nil {
+		res.Currencies = []string{}
+	}
+	return res
+}
+
+// MaskWebhookSecret hides the secret except for the last characters which help to tell secrets apart
+func MaskWebhookSecret(secret string) string {
+	const visible = 4
+	if len(secret) <= 2*visible {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-visible) + secret[len(secret)-visible:]
+}
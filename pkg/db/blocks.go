@@ -7,6 +7,7 @@ import (
 	"github.com/tonkeeper/tongo/ton"
 )
 
+// GetLastTrustedBlock returns the saved key block or nil if there is no block proven by this version
 func (c *Connection) GetLastTrustedBlock(ctx context.Context) (*ton.BlockIDExt, error) {
 	blockID := ton.BlockIDExt{
 		BlockID: ton.BlockID{
@@ -17,7 +18,7 @@ func (c *Connection) GetLastTrustedBlock(ctx context.Context) (*ton.BlockIDExt,
 	err := c.postgres.QueryRow(ctx, `
 		SELECT seqno, root_hash, file_hash 
 		FROM blockchain.trusted_mc_block 
-		WHERE id = 1`).Scan(&blockID.Seqno, &blockID.RootHash, &blockID.FileHash)
+		WHERE id = 1 AND key_block`).Scan(&blockID.Seqno, &blockID.RootHash, &blockID.FileHash)
 	if err != nil && errors.Is(err, pgx.ErrNoRows) {
 		return nil, nil
 	} else if err != nil {
@@ -26,16 +27,17 @@ func (c *Connection) GetLastTrustedBlock(ctx context.Context) (*ton.BlockIDExt,
 	return &blockID, nil
 }
 
+// SetLastTrustedBlock saves the proven key block
 func (c *Connection) SetLastTrustedBlock(ctx context.Context, block ton.BlockIDExt) error {
 	if block.Workchain != -1 || block.Shard != 0x8000000000000000 {
 		return errors.New("only masterchain block can be saved")
 	}
 	_, err := c.postgres.Exec(ctx, `
 		INSERT INTO blockchain.trusted_mc_block
-   		(id, seqno, root_hash, file_hash)
-		VALUES (1, $1, $2, $3)
+   		(id, seqno, root_hash, file_hash, key_block)
+		VALUES (1, $1, $2, $3, true)
 		ON CONFLICT (id) DO UPDATE
-   		SET seqno = $1, root_hash = $2, file_hash = $3`,
+   		SET seqno = $1, root_hash = $2, file_hash = $3, key_block = true`,
 		block.Seqno, block.RootHash, block.FileHash)
 	return err
 }
@@ -7,20 +7,25 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/tonkeeper/tongo/ton"
 	"regexp"
 	"sort"
 	"strconv"
 )
 
+const uniqueViolationCode = "23505"
+
+// executor is implemented by both pool and transaction
+type executor interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
 type Connection struct {
 	postgres *pgxpool.Pool
-
-	recipient ton.AccountID
 }
 
-func New(ctx context.Context, postgresURI string, recipient ton.AccountID) (*Connection, error) {
+func New(ctx context.Context, postgresURI string) (*Connection, error) {
 	pool, err := pgxpool.New(ctx, postgresURI)
 	if err != nil {
 		return nil, err
@@ -30,8 +35,7 @@ func New(ctx context.Context, postgresURI string, recipient ton.AccountID) (*Con
 		return nil, err
 	}
 	return &Connection{
-		postgres:  pool,
-		recipient: recipient,
+		postgres: pool,
 	}, nil
 }
 
@@ -52,6 +52,10 @@ func (c *Connection) getCurrencyByID(ctx context.Context, currencyID uuid.UUID)
 	} else if err != nil {
 		return nil, err
 	}
+	return parseCurrency(curType, info)
+}
+
+func parseCurrency(curType core.CurrencyType, info string) (*core.Currency, error) {
 	var res core.Currency
 	switch curType {
 	case core.TON:
@@ -72,9 +76,15 @@ func (c *Connection) getCurrencyByID(ctx context.Context, currencyID uuid.UUID)
 	return &res, nil
 }
 
+// SaveCurrencies saves configured currencies with their tickers. The enabled flag of existing currencies is kept,
+// so a currency disabled at runtime stays disabled after the restart.
 func (c *Connection) SaveCurrencies(ctx context.Context, currencies map[string]core.ExtendedCurrency) error {
-	for _, currency := range currencies {
-		err := c.saveCurrency(ctx, currency.Currency)
+	for ticker, currency := range currencies {
+		_, err := c.postgres.Exec(ctx, `
+			INSERT INTO payments.currencies (type, info, ticker, decimals) 
+			VALUES ($1, $2, $3, $4) 		
+			ON CONFLICT (type, info) DO UPDATE SET ticker = excluded.ticker, decimals = excluded.decimals`,
+			currency.Type, currencyInfo(currency.Currency), ticker, currency.JettonDecimals)
 		if err != nil {
 			return err
 		}
@@ -82,18 +92,53 @@ func (c *Connection) SaveCurrencies(ctx context.Context, currencies map[string]c
 	return nil
 }
 
-func (c *Connection) saveCurrency(ctx context.Context, currency core.Currency) error {
-	var info string
+// SaveCurrency saves the currency added, enabled or disabled at runtime
+func (c *Connection) SaveCurrency(ctx context.Context, currency core.RegisteredCurrency) error {
+	_, err := c.postgres.Exec(ctx, `
+		INSERT INTO payments.currencies (type, info, ticker, decimals, enabled) 
+		VALUES ($1, $2, $3, $4, $5) 		
+		ON CONFLICT (type, info) DO UPDATE SET ticker = excluded.ticker, decimals = excluded.decimals, enabled = excluded.enabled`,
+		currency.Type, currencyInfo(currency.Currency), currency.Ticker, currency.JettonDecimals, currency.Enabled)
+	return err
+}
+
+// GetCurrencies returns all currencies with tickers
+func (c *Connection) GetCurrencies(ctx context.Context) ([]core.RegisteredCurrency, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT type, info, ticker, decimals, enabled
+		FROM payments.currencies WHERE ticker IS NOT NULL
+		ORDER BY ticker`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []core.RegisteredCurrency
+	for rows.Next() {
+		var (
+			curType core.CurrencyType
+			info    string
+			r       core.RegisteredCurrency
+		)
+		err = rows.Scan(&curType, &info, &r.Ticker, &r.JettonDecimals, &r.Enabled)
+		if err != nil {
+			return nil, err
+		}
+		currency, err := parseCurrency(curType, info)
+		if err != nil {
+			return nil, err
+		}
+		r.Currency = *currency
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+func currencyInfo(currency core.Currency) string {
 	switch currency.Type {
 	case core.Jetton:
-		info = currency.Jetton().ToRaw()
+		return currency.Jetton().ToRaw()
 	case core.Extra:
-		info = fmt.Sprintf("%d", int64(*currency.ExtraID()))
+		return fmt.Sprintf("%d", int64(*currency.ExtraID()))
 	}
-	_, err := c.postgres.Exec(ctx, `
-		INSERT INTO payments.currencies (type, info) 
-		VALUES ($1, $2) 		
-		ON CONFLICT DO NOTHING`,
-		currency.Type, info)
-	return err
+	return ""
 }
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"strings"
+	"time"
+)
+
+const deliveryColumns = `id, merchant_id, sender, webhook_id, event_id, invoice_id, event_type, payload, status, attempts, next_attempt_at, last_error,
+	created_at, updated_at, delivered_at`
+
+// deliveredRetention is how long delivered deliveries are kept. Pending and dead deliveries are never deleted.
+const deliveredRetention = 5 * 24 * time.Hour
+
+// EnqueueInvoiceDeliveries saves the event and deliveries of the invoice event and removes it from the pending
+// invoice events in one transaction. Returns the event with its position in the log.
+func (c *Connection) EnqueueInvoiceDeliveries(ctx context.Context, event core.Event, deliveries []core.Delivery) (core.Event, error) {
+	tx, err := c.postgres.Begin(ctx)
+	if err != nil {
+		return core.Event{}, err
+	}
+	defer rollbackDbTx(ctx, tx)
+	event, err = saveEvent(ctx, tx, event)
+	if err != nil {
+		return core.Event{}, err
+	}
+	err = saveDeliveries(ctx, tx, deliveries)
+	if err != nil {
+		return core.Event{}, err
+	}
+	_, err = tx.Exec(ctx, `
+		DELETE FROM payments.invoice_events
+		WHERE id = $1`, event.EventID)
+	if err != nil {
+		return core.Event{}, err
+	}
+	return event, tx.Commit(ctx)
+}
+
+// EnqueueSubscriptionDeliveries saves the event and deliveries of the subscription event and removes the notification
+// in one transaction. Returns the event with its position in the log.
+func (c *Connection) EnqueueSubscriptionDeliveries(ctx context.Context, event core.Event, deliveries []core.Delivery) (core.Event, error) {
+	tx, err := c.postgres.Begin(ctx)
+	if err != nil {
+		return core.Event{}, err
+	}
+	defer rollbackDbTx(ctx, tx)
+	event, err = saveEvent(ctx, tx, event)
+	if err != nil {
+		return core.Event{}, err
+	}
+	err = saveDeliveries(ctx, tx, deliveries)
+	if err != nil {
+		return core.Event{}, err
+	}
+	_, err = tx.Exec(ctx, `
+		DELETE FROM payments.subscription_notifications
+		WHERE id = $1`, event.EventID)
+	if err != nil {
+		return core.Event{}, err
+	}
+	return event, tx.Commit(ctx)
+}
+
+func saveDeliveries(ctx context.Context, exec executor, deliveries []core.Delivery) error {
+	for _, d := range deliveries {
+		_, err := exec.Exec(ctx, `
+			INSERT INTO payments.deliveries
+			(id, merchant_id, sender, webhook_id, event_id, invoice_id, event_type, payload, status, attempts, next_attempt_at, last_error,
+			 created_at, updated_at, delivered_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+			d.ID, d.MerchantID, d.Sender, d.WebhookID, d.EventID, d.InvoiceID, d.EventType, []byte(d.Payload), d.Status, d.Attempts, d.NextAttemptAt,
+			d.LastError, d.CreatedAt, d.UpdatedAt, d.DeliveredAt)
+		if err != nil {
+			return fmt.Errorf("save delivery: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetDueDeliveries returns pending deliveries which next attempt time has come.
+// Deliveries to disabled webhooks are postponed until the webhook is enabled.
+// A delivery of the invoice event waits while an earlier delivery of the same invoice to the same destination is pending,
+// so the result has at most one delivery per invoice and destination.
+func (c *Connection) GetDueDeliveries(ctx context.Context, limit int) ([]core.Delivery, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT `+prefixColumns("d", deliveryColumns)+`
+		FROM payments.deliveries AS d
+		LEFT JOIN payments.webhooks AS w ON w.id = d.webhook_id
+		WHERE d.status = $1 AND d.next_attempt_at <= $2 AND (d.webhook_id IS NULL OR w.enabled)
+		  AND NOT EXISTS (
+		      SELECT 1 FROM payments.deliveries AS prev
+		      WHERE prev.invoice_id = d.invoice_id AND prev.status = $1
+		        AND prev.sender = d.sender AND prev.webhook_id IS NOT DISTINCT FROM d.webhook_id
+		        AND (prev.created_at, prev.id) < (d.created_at, d.id))
+		ORDER BY d.next_attempt_at
+		LIMIT $3`, core.PendingDeliveryStatus, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectDeliveries(rows)
+}
+
+// SaveDeliveryAttempt saves the attempt and the delivery state after it
+func (c *Connection) SaveDeliveryAttempt(ctx context.Context, d core.Delivery, attempt core.DeliveryAttempt) error {
+	tx, err := c.postgres.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer rollbackDbTx(ctx, tx)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO payments.delivery_attempts (id, delivery_id, attempted_at, duration_ms, error)
+		VALUES ($1, $2, $3, $4, $5)`,
+		attempt.ID, attempt.DeliveryID, attempt.AttemptedAt, attempt.Duration.Milliseconds(), attempt.Error)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE payments.deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, updated_at = $5, delivered_at = $6
+		WHERE id = $7`,
+		d.Status, d.Attempts, d.NextAttemptAt, d.LastError, d.UpdatedAt, d.DeliveredAt, d.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (c *Connection) GetDeliveries(ctx context.Context, filter core.DeliveryFilter) ([]core.Delivery, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	addCondition("merchant_id = $%d", filter.MerchantID)
+	addCondition("id > $%d", filter.After)
+	if filter.Status != nil {
+		addCondition("status = $%d", *filter.Status)
+	}
+	if filter.Sender != nil {
+		addCondition("sender = $%d", *filter.Sender)
+	}
+	if filter.WebhookID != nil {
+		addCondition("webhook_id = $%d", *filter.WebhookID)
+	}
+	if filter.EventID != nil {
+		addCondition("event_id = $%d", *filter.EventID)
+	}
+	args = append(args, filter.Limit)
+	rows, err := c.postgres.Query(ctx, fmt.Sprintf(`
+		SELECT `+deliveryColumns+`
+		FROM payments.deliveries
+		WHERE %s
+		ORDER BY id
+		LIMIT $%d`, strings.Join(conditions, " AND "), len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	return collectDeliveries(rows)
+}
+
+func (c *Connection) GetDelivery(ctx context.Context, id uuid.UUID) (core.Delivery, error) {
+	d, err := scanDelivery(c.postgres.QueryRow(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM payments.deliveries
+		WHERE id = $1`, id))
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.Delivery{}, core.ErrNotFound
+	}
+	return d, err
+}
+
+func (c *Connection) GetDeliveryAttempts(ctx context.Context, deliveryID uuid.UUID) ([]core.DeliveryAttempt, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT id, delivery_id, attempted_at, duration_ms, error
+		FROM payments.delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY attempted_at`, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []core.DeliveryAttempt
+	for rows.Next() {
+		var (
+			a        core.DeliveryAttempt
+			duration int64
+		)
+		err = rows.Scan(&a.ID, &a.DeliveryID, &a.AttemptedAt, &duration, &a.Error)
+		if err != nil {
+			return nil, err
+		}
+		a.Duration = time.Duration(duration) * time.Millisecond
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
+
+// ResendDelivery schedules the delivery for sending right now with the full set of attempts.
+// Delivered deliveries are sent again.
+func (c *Connection) ResendDelivery(ctx context.Context, id uuid.UUID) (core.Delivery, error) {
+	now := time.Now()
+	tag, err := c.postgres.Exec(ctx, `
+		UPDATE payments.deliveries
+		SET status = $1, attempts = 0, next_attempt_at = $2, updated_at = $2
+		WHERE id = $3`, core.PendingDeliveryStatus, now, id)
+	if err != nil {
+		return core.Delivery{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return core.Delivery{}, core.ErrNotFound
+	}
+	return c.GetDelivery(ctx, id)
+}
+
+// DeleteOldDeliveries deletes delivered deliveries after the retention period. Pending and dead deliveries are kept.
+func (c *Connection) DeleteOldDeliveries(ctx context.Context) error {
+	_, err := c.postgres.Exec(ctx, `
+		DELETE FROM payments.deliveries
+		WHERE status = $1 AND delivered_at < $2`, core.DeliveredDeliveryStatus, time.Now().Add(-deliveredRetention))
+	return err
+}
+
+func collectDeliveries(rows pgx.Rows) ([]core.Delivery, error) {
+	defer rows.Close()
+	var res []core.Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+func scanDelivery(row pgx.Row) (core.Delivery, error) {
+	var (
+		d       core.Delivery
+		payload []byte
+	)
+	err := row.Scan(&d.ID, &d.MerchantID, &d.Sender, &d.WebhookID, &d.EventID, &d.InvoiceID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastError, &d.CreatedAt, &d.UpdatedAt, &d.DeliveredAt)
+	d.Payload = payload
+	return d, err
+}
+
+// prefixColumns qualifies the comma separated columns with the table alias
+func prefixColumns(alias, columns string) string {
+	parts := strings.Split(columns, ",")
+	for i, p := range parts {
+		parts[i] = alias + "." + strings.TrimSpace(p)
+	}
+	return strings.Join(parts, ", ")
+}
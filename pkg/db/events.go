@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"time"
+)
+
+// eventRetention is how long events are kept for resuming streams
+const eventRetention = 5 * 24 * time.Hour
+
+func saveEvent(ctx context.Context, tx pgx.Tx, event core.Event) (core.Event, error) {
+	err := tx.QueryRow(ctx, `
+		INSERT INTO payments.events (event_id, merchant_id, invoice_id, type, payload, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING seq`,
+		event.EventID, event.MerchantID, event.InvoiceID, event.Type, []byte(event.Payload), event.CreatedAt).Scan(&event.Seq)
+	return event, err
+}
+
+// GetEvents returns events of the merchant after the sequence number in the log order
+func (c *Connection) GetEvents(ctx context.Context, merchantID uuid.UUID, afterSeq int64, limit int) ([]core.Event, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT seq, event_id, merchant_id, invoice_id, type, payload, created_at
+		FROM payments.events
+		WHERE merchant_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3`, merchantID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []core.Event
+	for rows.Next() {
+		var (
+			e       core.Event
+			payload []byte
+		)
+		err = rows.Scan(&e.Seq, &e.EventID, &e.MerchantID, &e.InvoiceID, &e.Type, &payload, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// DeleteOldEvents deletes events and failed outbox events after the retention period
+func (c *Connection) DeleteOldEvents(ctx context.Context) error {
+	before := time.Now().Add(-eventRetention)
+	_, err := c.postgres.Exec(ctx, `
+		DELETE FROM payments.events
+		WHERE created_at < $1`, before)
+	if err != nil {
+		return err
+	}
+	_, err = c.postgres.Exec(ctx, `
+		DELETE FROM payments.invoice_events
+		WHERE failed_at < $1`, before)
+	if err != nil {
+		return err
+	}
+	_, err = c.postgres.Exec(ctx, `
+		DELETE FROM payments.subscription_notifications
+		WHERE failed_at < $1`, before)
+	return err
+}
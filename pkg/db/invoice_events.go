@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"math/big"
+	"time"
+)
+
+// saveInvoiceEvents saves events to the outbox. It must be called in the transaction which changes the invoice,
+// so the change and its events are committed together. Repeated events with the same stable ID are ignored.
+func (c *Connection) saveInvoiceEvents(ctx context.Context, exec executor, events []core.InvoiceEvent) error {
+	for _, e := range events {
+		var (
+			txHash     *ton.Bits256
+			currencyID *uuid.UUID
+		)
+		if e.Payment != nil {
+			var err error
+			currencyID, err = c.getCurrencyID(ctx, e.Payment.Currency)
+			if err != nil {
+				return err
+			}
+			txHash = &e.Payment.TxHash
+		}
+		_, err := exec.Exec(ctx, `
+			INSERT INTO payments.invoice_events (id, invoice_id, type, previous_status, payment_tx_hash, payment_currency, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (id) DO NOTHING`,
+			e.ID, e.InvoiceID, e.Type, e.PreviousStatus, txHash, currencyID, e.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return notify(ctx, exec)
+}
+
+// GetInvoiceEvents returns invoice events waiting for notification in the order they happened. Failed events are skipped.
+func (c *Connection) GetInvoiceEvents(ctx context.Context, limit int) ([]core.InvoiceEvent, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT e.id, e.invoice_id, e.type, e.previous_status, e.created_at,
+		       p.tx_hash, p.lt, p.invoice_id, p.currency, p.amount, p.paid_by, p.recipient, p.created_at
+		FROM payments.invoice_events AS e
+		LEFT JOIN payments.payments AS p ON p.tx_hash = e.payment_tx_hash AND p.currency = e.payment_currency
+		WHERE e.failed_at IS NULL
+		ORDER BY e.seq
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type eventRow struct {
+		event      core.InvoiceEvent
+		payment    core.Payment
+		currencyID *uuid.UUID
+		amount     *string
+		paidBy     *string
+		recipient  *string
+	}
+	var eventRows []eventRow
+	for rows.Next() {
+		var (
+			r              eventRow
+			txHash         *ton.Bits256
+			lt             *uint64
+			paymentInvoice *core.InvoiceID
+			paymentCreated *time.Time
+		)
+		err = rows.Scan(&r.event.ID, &r.event.InvoiceID, &r.event.Type, &r.event.PreviousStatus, &r.event.CreatedAt,
+			&txHash, &lt, &paymentInvoice, &r.currencyID, &r.amount, &r.paidBy, &r.recipient, &paymentCreated)
+		if err != nil {
+			return nil, err
+		}
+		if txHash != nil {
+			r.payment = core.Payment{TxHash: *txHash, Lt: *lt, InvoiceID: *paymentInvoice, CreatedAt: *paymentCreated}
+		}
+		eventRows = append(eventRows, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]core.InvoiceEvent, 0, len(eventRows))
+	for _, r := range eventRows {
+		if r.currencyID != nil {
+			currency, err := c.getCurrencyByID(ctx, *r.currencyID)
+			if err != nil {
+				return nil, err
+			}
+			r.payment.Currency = *currency
+			r.payment.Amount, _ = new(big.Int).SetString(*r.amount, 10)
+			r.payment.PaidBy, err = ton.ParseAccountID(*r.paidBy)
+			if err != nil {
+				return nil, err
+			}
+			r.payment.Recipient, err = ton.ParseAccountID(*r.recipient)
+			if err != nil {
+				return nil, err
+			}
+			payment := r.payment
+			r.event.Payment = &payment
+		}
+		res = append(res, r.event)
+	}
+	return res, nil
+}
+
+// FailInvoiceEvent marks the invoice event which can not be sent, so it does not block the following events.
+// Failed events are deleted after the retention period.
+func (c *Connection) FailInvoiceEvent(ctx context.Context, id uuid.UUID, reason string) error {
+	_, err := c.postgres.Exec(ctx, `
+		UPDATE payments.invoice_events
+		SET failed_at = $2, error = $3
+		WHERE id = $1`, id, time.Now(), reason)
+	return err
+}
@@ -8,22 +8,139 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/tonkeeper/tongo/ton"
 	"github.com/txsociety/spice-harvester/pkg/core"
 	"log/slog"
 	"math/big"
+	"strings"
 	"time"
 )
 
 func (c *Connection) CreateInvoice(ctx context.Context, invoice core.Invoice) error {
-	err := c.saveInvoice(ctx, invoice, false)
+	tx, err := c.postgres.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer rollbackDbTx(ctx, tx)
+
+	err = c.saveInvoice(ctx, tx, invoice)
+	if err != nil {
+		return err
+	}
+	err = c.saveInvoiceOptions(ctx, tx, invoice)
 	if err != nil {
 		return err
 	}
-	return c.saveInvoice(ctx, invoice, true)
+	event := core.NewInvoiceEvent(core.InvoiceCreatedEvent, invoice.ID, nil, nil, invoice.CreatedAt)
+	err = c.saveInvoiceEvents(ctx, tx, []core.InvoiceEvent{event})
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
-func (c *Connection) saveInvoice(ctx context.Context, invoice core.Invoice, isNotify bool) error {
+// CreateInvoices saves invoices in one transaction. Every invoice is saved in its own savepoint
+// so the error of one invoice (e.g. core.ErrAlreadyExists) does not affect others. Returns per-invoice errors.
+func (c *Connection) CreateInvoices(ctx context.Context, invoices []core.Invoice) ([]error, error) {
+	tx, err := c.postgres.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rollbackDbTx(ctx, tx)
+
+	results := make([]error, len(invoices))
+	for i, invoice := range invoices {
+		results[i] = c.createInvoiceInSavepoint(ctx, tx, invoice)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (c *Connection) createInvoiceInSavepoint(ctx context.Context, tx pgx.Tx, invoice core.Invoice) error {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer rollbackDbTx(ctx, savepoint)
+
+	err = c.saveInvoice(ctx, savepoint, invoice)
+	if err != nil {
+		return err
+	}
+	err = c.saveInvoiceOptions(ctx, savepoint, invoice)
+	if err != nil {
+		return err
+	}
+	event := core.NewInvoiceEvent(core.InvoiceCreatedEvent, invoice.ID, nil, nil, invoice.CreatedAt)
+	err = c.saveInvoiceEvents(ctx, savepoint, []core.InvoiceEvent{event})
+	if err != nil {
+		return err
+	}
+	return savepoint.Commit(ctx)
+}
+
+func (c *Connection) saveInvoiceOptions(ctx context.Context, tx pgx.Tx, invoice core.Invoice) error {
+	for _, option := range invoice.Options {
+		currencyID, err := c.getCurrencyID(ctx, option.Currency)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO payments.invoice_options (invoice_id, currency, amount, received, rate)
+			VALUES ($1, $2, $3, $4, $5)`, invoice.ID, currencyID, option.Amount.String(), option.Received.String(), decimalToDb(option.Rate))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Connection) getInvoiceOptions(ctx context.Context, id core.InvoiceID) ([]core.InvoiceOption, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT currency, amount, received, rate
+		FROM payments.invoice_options
+		WHERE invoice_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type optionRow struct {
+		currencyID       uuid.UUID
+		amount, received string
+		rate             *string
+	}
+	var optionRows []optionRow
+	for rows.Next() {
+		var r optionRow
+		err = rows.Scan(&r.currencyID, &r.amount, &r.received, &r.rate)
+		if err != nil {
+			return nil, err
+		}
+		optionRows = append(optionRows, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var res []core.InvoiceOption
+	for _, r := range optionRows {
+		currency, err := c.getCurrencyByID(ctx, r.currencyID)
+		if err != nil {
+			return nil, err
+		}
+		option := core.InvoiceOption{Currency: *currency, Rate: decimalFromDb(r.rate)}
+		option.Amount, _ = new(big.Int).SetString(r.amount, 10)
+		option.Received, _ = new(big.Int).SetString(r.received, 10)
+		res = append(res, option)
+	}
+	return res, nil
+}
+
+func (c *Connection) saveInvoice(ctx context.Context, exec executor, invoice core.Invoice) error {
 	currencyID, err := c.getCurrencyID(ctx, invoice.Currency)
 	if err != nil {
 		return err
@@ -36,14 +153,32 @@ func (c *Connection) saveInvoice(ctx context.Context, invoice core.Invoice, isNo
 	if err != nil {
 		return err
 	}
-	table := "payments.invoices"
-	if isNotify {
-		table = "payments.invoice_notifications"
+	sqlRequest := `INSERT INTO payments.invoices 
+		(id, status, amount, currency, created_at, expire_at, updated_at, private_info, metadata, overpayment, received, recipient, external_reference,
+		 fiat_currency, fiat_amount, rate, subscription_id, cycle, payment_request_id, paid_by, paid_at, tx_hash, buyer_email, paid_currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)`
+	var (
+		fiatCurrency     *string
+		fiatAmount, rate *string
+	)
+	var paidBy *string
+	if invoice.PaidBy != nil {
+		raw := invoice.PaidBy.ToRaw()
+		paidBy = &raw
+	}
+	var paidCurrencyID *uuid.UUID
+	if invoice.PaidCurrency != nil {
+		paidCurrencyID, err = c.getCurrencyID(ctx, *invoice.PaidCurrency)
+		if err != nil {
+			return err
+		}
+	}
+	if invoice.Fiat != nil {
+		fiatCurrency = &invoice.Fiat.Currency
+		fiatAmount = decimalToDb(invoice.Fiat.Amount)
+		rate = decimalToDb(invoice.Fiat.Rate)
 	}
-	sqlRequest := fmt.Sprintf(`INSERT INTO %s 
-		(id, status, amount, currency, created_at, expire_at, updated_at, private_info, metadata, overpayment, recipient)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`, table)
-	_, err = c.postgres.Exec(ctx, sqlRequest,
+	_, err = exec.Exec(ctx, sqlRequest,
 		invoice.ID,
 		invoice.Status,
 		invoice.Amount.String(),
@@ -54,8 +189,25 @@ func (c *Connection) saveInvoice(ctx context.Context, invoice core.Invoice, isNo
 		privateInfoBytes,
 		metaBytes,
 		invoice.Overpayment.String(),
+		invoice.Received.String(),
 		invoice.Recipient,
+		invoice.ExternalReference,
+		fiatCurrency,
+		fiatAmount,
+		rate,
+		invoice.SubscriptionID,
+		invoice.Cycle,
+		invoice.PaymentRequestID,
+		paidBy,
+		invoice.PaidAt,
+		invoice.TxHash,
+		invoice.BuyerEmail,
+		paidCurrencyID,
 	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == "invoices_recipient_external_reference_idx" {
+		return core.ErrAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
@@ -64,14 +216,17 @@ func (c *Connection) saveInvoice(ctx context.Context, invoice core.Invoice, isNo
 
 func (c *Connection) GetInvoice(ctx context.Context, id core.InvoiceID) (core.Invoice, error) {
 	var (
-		i                              core.Invoice
-		currencyID                     uuid.UUID
-		recipient, amount, overpayment string
-		paidByS                        *string
-		txHash                         *ton.Bits256
+		i                                        core.Invoice
+		currencyID                               uuid.UUID
+		paidCurrencyID                           *uuid.UUID
+		recipient, amount, overpayment, received string
+		paidByS                                  *string
+		txHash                                   *ton.Bits256
+		fiatCurrency, fiatAmount, rate           *string
 	)
 	err := c.postgres.QueryRow(ctx, `
-		SELECT id, status, amount, currency, created_at, expire_at, updated_at, private_info, metadata, overpayment, paid_at, paid_by, recipient, tx_hash
+		SELECT id, status, amount, currency, created_at, expire_at, updated_at, private_info, metadata, overpayment, received, paid_at, paid_by, recipient, tx_hash, external_reference,
+		       fiat_currency, fiat_amount, rate, subscription_id, cycle, payment_request_id, buyer_email, paid_currency
 		FROM payments.invoices WHERE id = $1`, id).Scan(
 		&i.ID,
 		&i.Status,
@@ -83,10 +238,20 @@ func (c *Connection) GetInvoice(ctx context.Context, id core.InvoiceID) (core.In
 		&i.PrivateInfo,
 		&i.Metadata,
 		&overpayment,
+		&received,
 		&i.PaidAt,
 		&paidByS,
 		&recipient,
 		&txHash,
+		&i.ExternalReference,
+		&fiatCurrency,
+		&fiatAmount,
+		&rate,
+		&i.SubscriptionID,
+		&i.Cycle,
+		&i.PaymentRequestID,
+		&i.BuyerEmail,
+		&paidCurrencyID,
 	)
 	if err != nil && errors.Is(err, pgx.ErrNoRows) {
 		return core.Invoice{}, core.ErrNotFound
@@ -98,12 +263,19 @@ func (c *Connection) GetInvoice(ctx context.Context, id core.InvoiceID) (core.In
 		return core.Invoice{}, err
 	}
 	i.Currency = *currency
+	if paidCurrencyID != nil {
+		i.PaidCurrency, err = c.getCurrencyByID(ctx, *paidCurrencyID)
+		if err != nil {
+			return core.Invoice{}, err
+		}
+	}
 	i.Recipient, err = ton.ParseAccountID(recipient)
 	if err != nil {
 		return core.Invoice{}, err
 	}
 	i.Amount, _ = new(big.Int).SetString(amount, 10)
 	i.Overpayment, _ = new(big.Int).SetString(overpayment, 10)
+	i.Received, _ = new(big.Int).SetString(received, 10)
 	if paidByS != nil {
 		paidBy, err := ton.ParseAccountID(*paidByS)
 		if err != nil {
@@ -114,20 +286,130 @@ func (c *Connection) GetInvoice(ctx context.Context, id core.InvoiceID) (core.In
 	if txHash != nil {
 		i.TxHash = txHash
 	}
+	if fiatCurrency != nil {
+		i.Fiat = &core.FiatPrice{
+			Currency: *fiatCurrency,
+			Amount:   decimalFromDb(fiatAmount),
+			Rate:     decimalFromDb(rate),
+		}
+	}
+	i.Options, err = c.getInvoiceOptions(ctx, i.ID)
+	if err != nil {
+		return core.Invoice{}, err
+	}
 	return i, nil
 }
 
-func (c *Connection) GetInvoices(ctx context.Context, after core.InvoiceID, limit int64) ([]core.Invoice, error) {
-	// use validated id
-	rows, err := c.postgres.Query(ctx, `
+// GetInvoiceByReference returns the invoice of the recipient with the external reference
+func (c *Connection) GetInvoiceByReference(ctx context.Context, recipient ton.AccountID, reference string) (core.Invoice, error) {
+	var id core.InvoiceID
+	err := c.postgres.QueryRow(ctx, `
+		SELECT id FROM payments.invoices WHERE recipient = $1 AND external_reference = $2`, recipient.ToRaw(), reference).Scan(&id)
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.Invoice{}, core.ErrNotFound
+	} else if err != nil {
+		return core.Invoice{}, err
+	}
+	return c.GetInvoice(ctx, id)
+}
+
+// GetInvoices returns the page of invoices matching the filter and the total number of matching invoices
+func (c *Connection) GetInvoices(ctx context.Context, filter core.InvoiceFilter) ([]core.Invoice, int64, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Recipient != nil {
+		addCondition("recipient = $%d", filter.Recipient.ToRaw())
+	}
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, 0, len(filter.Statuses))
+		for _, s := range filter.Statuses {
+			statuses = append(statuses, string(s))
+		}
+		addCondition("status::text = ANY($%d)", statuses)
+	}
+	if filter.Currency != nil {
+		currencyID, err := c.getCurrencyID(ctx, *filter.Currency)
+		if err != nil && errors.Is(err, core.ErrNotFound) {
+			return nil, 0, nil // not tracked currency
+		} else if err != nil {
+			return nil, 0, err
+		}
+		addCondition(`(currency = $%[1]d OR EXISTS (
+			SELECT 1 FROM payments.invoice_options AS o WHERE o.invoice_id = payments.invoices.id AND o.currency = $%[1]d))`,
+			*currencyID)
+	}
+	if filter.CreatedFrom != nil {
+		addCondition("created_at >= $%d", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		addCondition("created_at < $%d", *filter.CreatedTo)
+	}
+	if filter.PaidFrom != nil {
+		addCondition("paid_at >= $%d", *filter.PaidFrom)
+	}
+	if filter.PaidTo != nil {
+		addCondition("paid_at < $%d", *filter.PaidTo)
+	}
+	if filter.PaidBy != nil {
+		addCondition(`(paid_by = $%[1]d OR EXISTS (
+			SELECT 1 FROM payments.payments AS p WHERE p.invoice_id = payments.invoices.id AND p.paid_by = $%[1]d))`,
+			filter.PaidBy.ToRaw())
+	}
+	if len(filter.PrivateInfo) > 0 {
+		privateInfoBytes, err := marshalJsonForDb(filter.PrivateInfo)
+		if err != nil {
+			return nil, 0, err
+		}
+		addCondition("private_info @> $%d", privateInfoBytes)
+	}
+	if filter.ExternalReference != nil {
+		addCondition("external_reference = $%d", *filter.ExternalReference)
+	}
+	if filter.SubscriptionID != nil {
+		addCondition("subscription_id = $%d", *filter.SubscriptionID)
+	}
+	if filter.PaymentRequestID != nil {
+		addCondition("payment_request_id = $%d", *filter.PaymentRequestID)
+	}
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+	var total int64
+	err := c.postgres.QueryRow(ctx, `SELECT count(*) FROM payments.invoices WHERE `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := "ASC"
+	if filter.Descending {
+		order = "DESC"
+	}
+	if filter.After != (core.InvoiceID{}) {
+		// use validated id
+		if filter.Descending {
+			addCondition("id < $%d", filter.After)
+		} else {
+			addCondition("id > $%d", filter.After)
+		}
+		where = strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	rows, err := c.postgres.Query(ctx, fmt.Sprintf(`
 		SELECT id
-		FROM payments.invoices		
-		WHERE id > $1
-		ORDER BY id
-		LIMIT $2`,
-		after, limit)
+		FROM payments.invoices
+		WHERE %s
+		ORDER BY id %s
+		LIMIT $%d`, where, order, len(args)),
+		args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -137,14 +419,18 @@ func (c *Connection) GetInvoices(ctx context.Context, after core.InvoiceID, limi
 		var invoiceID core.InvoiceID
 		err = rows.Scan(&invoiceID)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		invoiceIDs = append(invoiceIDs, invoiceID)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	invoices, err := c.getInvoicesByIDs(ctx, invoiceIDs)
+	if err != nil {
+		return nil, 0, err
 	}
-	return c.getInvoicesByIDs(ctx, invoiceIDs)
+	return invoices, total, nil
 }
 
 func (c *Connection) getInvoicesByIDs(ctx context.Context, invoiceIDs []core.InvoiceID) (res []core.Invoice, err error) {
@@ -158,108 +444,180 @@ func (c *Connection) getInvoicesByIDs(ctx context.Context, invoiceIDs []core.Inv
 	return res, nil
 }
 
-func (c *Connection) GetInvoiceNotifications(ctx context.Context, limit int) ([]core.Invoice, error) {
-	rows, err := c.postgres.Query(ctx, `
-		SELECT id
-		FROM payments.invoice_notifications
-		ORDER BY updated_at
-		LIMIT $1`, limit)
+// CancelInvoice cancels the waiting invoice of the recipient
+func (c *Connection) CancelInvoice(ctx context.Context, recipient ton.AccountID, id core.InvoiceID) (core.Invoice, error) {
+	tx, err := c.postgres.Begin(ctx)
 	if err != nil {
-		return nil, err
+		return core.Invoice{}, err
 	}
-	defer rows.Close()
-
-	var invoiceIDs []core.InvoiceID
+	defer rollbackDbTx(ctx, tx)
 
-	for rows.Next() {
-		var invoiceID core.InvoiceID
-		err = rows.Scan(&invoiceID)
-		if err != nil {
-			return nil, err
-		}
-		invoiceIDs = append(invoiceIDs, invoiceID)
+	now := time.Now()
+	var previousStatus core.InvoiceStatus
+	// the joined row keeps the status before the update
+	err = tx.QueryRow(ctx, `
+		UPDATE payments.invoices AS i
+		SET status = $1, updated_at = $2
+		FROM payments.invoices AS prev
+		WHERE i.id = $3 AND prev.id = i.id AND i.status IN ($4, $5) AND i.expire_at > $6 AND i.recipient = $7
+		RETURNING prev.status`,
+		core.CanceledInvoiceStatus, now, id, core.WaitingInvoiceStatus, core.PartiallyPaidInvoiceStatus, now, recipient.ToRaw()).Scan(&previousStatus)
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.Invoice{}, core.ErrNotFound
+	} else if err != nil {
+		return core.Invoice{}, err
 	}
-	if err = rows.Err(); err != nil {
-		return nil, err
+	event := core.NewInvoiceEvent(core.InvoiceCancelledEvent, id, &previousStatus, nil, now)
+	err = c.saveInvoiceEvents(ctx, tx, []core.InvoiceEvent{event})
+	if err != nil {
+		return core.Invoice{}, err
 	}
-	return c.getInvoicesByIDs(ctx, invoiceIDs)
-}
-
-func (c *Connection) DeleteInvoiceNotification(ctx context.Context, invoiceID core.InvoiceID) error {
-	_, err := c.postgres.Exec(ctx, `
-		DELETE FROM payments.invoice_notifications
-		WHERE id = $1`, invoiceID)
-	return err
+	err = tx.Commit(ctx)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	return c.GetInvoice(ctx, id)
 }
 
-func (c *Connection) DeleteOldNotifications(ctx context.Context) error {
-	_, err := c.postgres.Exec(ctx, `
-		DELETE FROM payments.invoice_notifications
-		WHERE updated_at < $1`, time.Now().Add(-time.Hour*24*5))
-	return err
-}
+// CancelInvoices cancels invoices of the recipient in one transaction. Returns cancelled invoices and core.ErrNotFound for
+// invoices that can not be cancelled in the order of ids. The ids must be unique.
+func (c *Connection) CancelInvoices(ctx context.Context, recipient ton.AccountID, ids []core.InvoiceID) ([]core.Invoice, []error, error) {
+	tx, err := c.postgres.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rollbackDbTx(ctx, tx)
 
-func (c *Connection) CancelInvoice(ctx context.Context, id core.InvoiceID) (core.Invoice, error) {
 	now := time.Now()
-	tag, err := c.postgres.Exec(ctx, `
-		UPDATE payments.invoices 
-		SET status = $1, updated_at = $2 
-		WHERE id = $3 AND status = $4 AND expire_at > $5`,
-		core.CanceledInvoiceStatus, now, id, core.WaitingInvoiceStatus, now)
+	rows, err := tx.Query(ctx, `
+		UPDATE payments.invoices AS i
+		SET status = $1, updated_at = $2
+		FROM payments.invoices AS prev
+		WHERE i.id = ANY($3) AND prev.id = i.id AND i.status IN ($4, $5) AND i.expire_at > $6 AND i.recipient = $7
+		RETURNING i.id, prev.status`,
+		core.CanceledInvoiceStatus, now, ids, core.WaitingInvoiceStatus, core.PartiallyPaidInvoiceStatus, now, recipient.ToRaw())
 	if err != nil {
-		return core.Invoice{}, err
+		return nil, nil, err
 	}
-	if tag.RowsAffected() == 0 {
-		return core.Invoice{}, core.ErrNotFound
+	cancelled := make(map[core.InvoiceID]core.InvoiceStatus) // previous statuses
+	for rows.Next() {
+		var (
+			id     core.InvoiceID
+			status core.InvoiceStatus
+		)
+		err = rows.Scan(&id, &status)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		cancelled[id] = status
 	}
-	invoice, err := c.GetInvoice(ctx, id)
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	var events []core.InvoiceEvent
+	for _, id := range ids {
+		if previousStatus, ok := cancelled[id]; ok {
+			events = append(events, core.NewInvoiceEvent(core.InvoiceCancelledEvent, id, &previousStatus, nil, now))
+		}
+	}
+	err = c.saveInvoiceEvents(ctx, tx, events)
 	if err != nil {
-		return core.Invoice{}, err
+		return nil, nil, err
 	}
-	err = c.saveInvoice(ctx, invoice, true)
+	err = tx.Commit(ctx)
 	if err != nil {
-		return core.Invoice{}, err
+		return nil, nil, err
+	}
+	invoices := make([]core.Invoice, len(ids))
+	results := make([]error, len(ids))
+	for i, id := range ids {
+		if _, ok := cancelled[id]; !ok {
+			results[i] = core.ErrNotFound
+			continue
+		}
+		invoices[i], err = c.GetInvoice(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	return invoice, nil
+	return invoices, results, nil
 }
 
+// MarkExpired expires overdue invoices. Events of expired invoices and missed cycles of their subscriptions
+// are saved in the same transaction.
 func (c *Connection) MarkExpired(ctx context.Context) error {
-	now := time.Now()
-	rows, err := c.postgres.Query(ctx, `
-		UPDATE payments.invoices 
-		SET status = $1, updated_at = $2 
-		WHERE status = $3 AND expire_at < $4
-		RETURNING id`,
-		core.ExpiredInvoiceStatus, now, core.WaitingInvoiceStatus, now)
+	tx, err := c.postgres.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-
-	var invoiceIDs []core.InvoiceID
+	defer rollbackDbTx(ctx, tx)
 
+	now := time.Now()
+	rows, err := tx.Query(ctx, `
+		UPDATE payments.invoices AS i
+		SET status = $1, updated_at = $2
+		FROM payments.invoices AS prev
+		WHERE i.status IN ($3, $4) AND i.expire_at < $5 AND prev.id = i.id
+		RETURNING i.id, prev.status, i.subscription_id, i.cycle`,
+		core.ExpiredInvoiceStatus, now, core.WaitingInvoiceStatus, core.PartiallyPaidInvoiceStatus, now)
+	if err != nil {
+		return err
+	}
+	type expiredCycle struct {
+		invoiceID      core.InvoiceID
+		subscriptionID uuid.UUID
+		cycle          int
+	}
+	var (
+		events []core.InvoiceEvent
+		cycles []expiredCycle
+	)
 	for rows.Next() {
-		var invoiceID core.InvoiceID
-		err = rows.Scan(&invoiceID)
+		var (
+			invoiceID      core.InvoiceID
+			previousStatus core.InvoiceStatus
+			subscriptionID *uuid.UUID
+			cycle          *int
+		)
+		err = rows.Scan(&invoiceID, &previousStatus, &subscriptionID, &cycle)
 		if err != nil {
+			rows.Close()
 			return err
 		}
-		invoiceIDs = append(invoiceIDs, invoiceID)
+		events = append(events, core.NewInvoiceEvent(core.InvoiceExpiredEvent, invoiceID, &previousStatus, nil, now))
+		if subscriptionID != nil {
+			cycles = append(cycles, expiredCycle{invoiceID: invoiceID, subscriptionID: *subscriptionID, cycle: *cycle})
+		}
 	}
+	rows.Close()
 	if err = rows.Err(); err != nil {
 		return err
 	}
-	for _, id := range invoiceIDs {
-		inv, err := c.GetInvoice(ctx, id)
+	err = c.saveInvoiceEvents(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+	for _, ec := range cycles {
+		s, err := c.GetSubscription(ctx, ec.subscriptionID)
 		if err != nil {
 			return err
 		}
-		err = c.saveInvoice(ctx, inv, true)
+		invoiceID := ec.invoiceID
+		err = saveSubscriptionEvent(ctx, tx, core.SubscriptionEvent{
+			SubscriptionID: s.ID,
+			Type:           core.MissedSubscriptionEvent,
+			Cycle:          ec.cycle,
+			CycleAt:        s.CycleAt(ec.cycle),
+			InvoiceID:      &invoiceID,
+			CreatedAt:      now,
+		})
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return tx.Commit(ctx)
 }
 
 func (c *Connection) SavePayments(ctx context.Context, account ton.AccountID, txLt uint64, payments []core.Payment, parsingError error) error {
@@ -269,7 +627,7 @@ func (c *Connection) SavePayments(ctx context.Context, account ton.AccountID, tx
 	}
 	defer rollbackDbTx(ctx, tx)
 
-	var res []core.Invoice
+	var events []core.InvoiceEvent
 	if parsingError != nil {
 		_, err = tx.Exec(ctx, `
 			UPDATE blockchain.transactions set processing_error = $1 where account_id = $2 and lt = $3`,
@@ -279,11 +637,11 @@ func (c *Connection) SavePayments(ctx context.Context, account ton.AccountID, tx
 		}
 	} else if len(payments) > 0 {
 		for _, p := range payments {
-			inv, err := c.processPayment(ctx, tx, p)
+			paymentEvents, err := c.processPayment(ctx, tx, p)
 			if err != nil {
 				return err
 			}
-			res = append(res, inv...)
+			events = append(events, paymentEvents...)
 		}
 	}
 	_, err = tx.Exec(ctx, `
@@ -291,20 +649,15 @@ func (c *Connection) SavePayments(ctx context.Context, account ton.AccountID, tx
 	if err != nil {
 		return err
 	}
-	err = tx.Commit(ctx)
+	err = c.saveInvoiceEvents(ctx, tx, events)
 	if err != nil {
 		return err
 	}
-	for _, inv := range res {
-		err = c.saveInvoice(ctx, inv, true)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return tx.Commit(ctx)
 }
 
-func (c *Connection) processPayment(ctx context.Context, tx pgx.Tx, p core.Payment) ([]core.Invoice, error) {
+// processPayment applies the payment to the invoice and returns the events it caused
+func (c *Connection) processPayment(ctx context.Context, tx pgx.Tx, p core.Payment) ([]core.InvoiceEvent, error) {
 	currencyID, err := c.getCurrencyID(ctx, p.Currency)
 	if err != nil && errors.Is(err, core.ErrNotFound) {
 		return nil, nil // not tracked currency
@@ -313,73 +666,155 @@ func (c *Connection) processPayment(ctx context.Context, tx pgx.Tx, p core.Payme
 	}
 
 	var (
-		status, amountS, overpaymentS string
-		metadata, privateInfo         map[string]json.RawMessage
-		expireAt, createdAt           time.Time
+		status, amountS, overpaymentS, receivedS string
+		expireAt                                 time.Time
+		invoiceCurrencyID                        uuid.UUID
 	)
 
 	now := time.Now()
 	err = tx.QueryRow(ctx, `
-		SELECT expire_at, created_at, amount, status, metadata, private_info, overpayment
+		SELECT expire_at, amount, status, overpayment, received, currency
 		FROM payments.invoices
-		WHERE currency = $1 AND id = $2 AND recipient = $3`, *currencyID, p.InvoiceID, p.Recipient.ToRaw()).Scan(
-		&expireAt, &createdAt, &amountS, &status, &metadata, &privateInfo, &overpaymentS)
+		WHERE id = $1 AND recipient = $2
+		FOR UPDATE`, p.InvoiceID, p.Recipient.ToRaw()).Scan(
+		&expireAt, &amountS, &status, &overpaymentS, &receivedS, &invoiceCurrencyID)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, nil
+		events, found, err := c.processRequestPayment(ctx, tx, *currencyID, p)
+		if err != nil || found {
+			return events, err
+		}
+		return nil, c.saveUnmatchedPayment(ctx, tx, *currencyID, p)
 	}
 	if err != nil {
 		return nil, err
 	}
+	previousStatus := core.InvoiceStatus(status)
 	amount, _ := new(big.Int).SetString(amountS, 10)
 	overpayment, _ := new(big.Int).SetString(overpaymentS, 10)
+	received, _ := new(big.Int).SetString(receivedS, 10)
 
-	_, err = tx.Exec(ctx, `
-		UPDATE payments.keys
-		SET accepted = true
-		WHERE address = $1 AND accepted = false`, p.PaidBy.ToRaw())
+	// payment in one of the alternative currencies
+	isOption := invoiceCurrencyID != *currencyID
+	var optionAmount, optionReceived *big.Int
+	if isOption {
+		var optionAmountS, optionReceivedS string
+		err = tx.QueryRow(ctx, `
+			SELECT amount, received
+			FROM payments.invoice_options
+			WHERE invoice_id = $1 AND currency = $2
+			FOR UPDATE`, p.InvoiceID, *currencyID).Scan(&optionAmountS, &optionReceivedS)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, c.saveUnmatchedPayment(ctx, tx, *currencyID, p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		optionAmount, _ = new(big.Int).SetString(optionAmountS, 10)
+		optionReceived, _ = new(big.Int).SetString(optionReceivedS, 10)
+		optionReceived.Add(optionReceived, p.Amount)
+	}
+
+	err = c.savePayment(ctx, tx, *currencyID, p)
 	if err != nil {
 		return nil, err
 	}
 
-	overpayment.Add(overpayment, p.Amount)
 	_, err = tx.Exec(ctx, `
-		UPDATE payments.invoices
-		SET overpayment = $1, updated_at = $2
-		WHERE id = $3`, overpayment, now, p.InvoiceID)
+		UPDATE payments.keys
+		SET accepted = true
+		WHERE address = $1 AND accepted = false`, p.PaidBy.ToRaw())
 	if err != nil {
 		return nil, err
 	}
-	if status != string(core.WaitingInvoiceStatus) || expireAt.Before(now) {
-		return nil, nil
+
+	payable := status == string(core.WaitingInvoiceStatus) || status == string(core.PartiallyPaidInvoiceStatus)
+	if !payable || expireAt.Before(now) {
+		if isOption {
+			// the invoice can no longer be paid, keep the payment with the option to refund it
+			_, err = tx.Exec(ctx, `
+				UPDATE payments.invoice_options
+				SET received = $1
+				WHERE invoice_id = $2 AND currency = $3`, optionReceived, p.InvoiceID, *currencyID)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(ctx, `
+				UPDATE payments.invoices
+				SET updated_at = $1
+				WHERE id = $2`, now, p.InvoiceID)
+			if err != nil {
+				return nil, err
+			}
+			return newPaymentEvents(p, &previousStatus, now, core.OverpaymentReceivedEvent), nil
+		}
+		// the invoice can no longer be paid, so the whole payment is an overpayment
+		overpayment.Add(overpayment, p.Amount)
+		_, err = tx.Exec(ctx, `
+			UPDATE payments.invoices
+			SET overpayment = $1, updated_at = $2
+			WHERE id = $3`, overpayment, now, p.InvoiceID)
+		if err != nil {
+			return nil, err
+		}
+		return newPaymentEvents(p, &previousStatus, now, core.OverpaymentReceivedEvent), nil
 	}
-	if overpayment.Cmp(amount) == -1 { // overpayment < amount
-		return nil, nil
+
+	if isOption {
+		// the price and payments in the invoice currency are kept even if the option covers the invoice
+		_, err = tx.Exec(ctx, `
+			UPDATE payments.invoice_options
+			SET received = $1
+			WHERE invoice_id = $2 AND currency = $3`, optionReceived, p.InvoiceID, *currencyID)
+		if err != nil {
+			return nil, err
+		}
+		if optionReceived.Cmp(optionAmount) == -1 { // option received < option amount
+			_, err = tx.Exec(ctx, `
+				UPDATE payments.invoices
+				SET status = $1, updated_at = $2
+				WHERE id = $3`, core.PartiallyPaidInvoiceStatus, now, p.InvoiceID)
+			if err != nil {
+				return nil, err
+			}
+			return newPaymentEvents(p, &previousStatus, now, core.PaymentReceivedEvent), nil
+		}
+	} else {
+		received.Add(received, p.Amount)
+		if received.Cmp(amount) == -1 { // received < amount
+			_, err = tx.Exec(ctx, `
+				UPDATE payments.invoices
+				SET status = $1, updated_at = $2, received = $3
+				WHERE id = $4`, core.PartiallyPaidInvoiceStatus, now, received, p.InvoiceID)
+			if err != nil {
+				return nil, err
+			}
+			return newPaymentEvents(p, &previousStatus, now, core.PaymentReceivedEvent), nil
+		}
+		overpayment.Add(overpayment, new(big.Int).Sub(received, amount))
 	}
-	overpayment.Sub(overpayment, amount)
 
 	_, err = tx.Exec(ctx, `
 			UPDATE payments.invoices
-			SET status = $1, updated_at = $2, paid_by = $3, overpayment = $4, paid_at = $5, tx_hash = $6
-			WHERE id = $7`, core.PaidInvoiceStatus, now, p.PaidBy.ToRaw(), overpayment, now, p.TxHash, p.InvoiceID)
+			SET status = $1, updated_at = $2, paid_by = $3, overpayment = $4, received = $5, paid_at = $6, tx_hash = $7, paid_currency = $8
+			WHERE id = $9`, core.PaidInvoiceStatus, now, p.PaidBy.ToRaw(), overpayment, received, now, p.TxHash,
+		*currencyID, p.InvoiceID)
 	if err != nil {
 		return nil, err
 	}
-	res := core.Invoice{
-		ID:          p.InvoiceID,
-		Recipient:   p.Recipient,
-		Status:      core.PaidInvoiceStatus,
-		Amount:      amount,
-		Currency:    p.Currency,
-		CreatedAt:   createdAt,
-		ExpireAt:    expireAt,
-		UpdatedAt:   now,
-		PrivateInfo: privateInfo,
-		Metadata:    metadata,
-		PaidBy:      &p.PaidBy,
-		PaidAt:      &now,
-		Overpayment: overpayment,
-	}
-	return []core.Invoice{res}, nil
+	return newPaymentEvents(p, &previousStatus, now, core.PaymentReceivedEvent, core.InvoicePaidEvent), nil
+}
+
+// newPaymentEvents creates events of the payment to the invoice. The payment is attached to payment events only.
+func newPaymentEvents(p core.Payment, previousStatus *core.InvoiceStatus, now time.Time, eventTypes ...string) []core.InvoiceEvent {
+	res := make([]core.InvoiceEvent, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		var payment *core.Payment
+		if eventType == core.PaymentReceivedEvent || eventType == core.OverpaymentReceivedEvent {
+			payment = &p
+		}
+		res = append(res, core.NewInvoiceEvent(eventType, p.InvoiceID, previousStatus, payment, now))
+	}
+	return res
 }
 
 func rollbackDbTx(ctx context.Context, tx pgx.Tx) {
@@ -389,6 +824,22 @@ func rollbackDbTx(ctx context.Context, tx pgx.Tx) {
 	}
 }
 
+func decimalToDb(r *big.Rat) *string {
+	if r == nil {
+		return nil
+	}
+	s := core.FormatDecimal(r)
+	return &s
+}
+
+func decimalFromDb(s *string) *big.Rat {
+	if s == nil {
+		return nil
+	}
+	r, _ := new(big.Rat).SetString(*s)
+	return r
+}
+
 func marshalJsonForDb(x any) ([]byte, error) {
 	b, err := json.Marshal(x)
 	if err != nil {
@@ -397,7 +848,3 @@ func marshalJsonForDb(x any) ([]byte, error) {
 	b = bytes.ReplaceAll(b, []byte(`\u0000`), nil) // postgres doesn't support \u0000 in jsonb
 	return b, nil
 }
-
-func (c *Connection) GetRecipient(ctx context.Context) (ton.AccountID, error) {
-	return c.recipient, nil
-}
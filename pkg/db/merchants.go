@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"time"
+)
+
+const merchantColumns = `id, name, recipient, currencies, created_at, updated_at`
+
+// SaveDefaultMerchant sets the configured recipient of the default merchant
+func (c *Connection) SaveDefaultMerchant(ctx context.Context, recipient ton.AccountID) error {
+	_, err := c.postgres.Exec(ctx, `
+		UPDATE payments.merchants
+		SET recipient = $1, updated_at = $2
+		WHERE id = $3 AND recipient IS DISTINCT FROM $1`, recipient.ToRaw(), time.Now(), core.DefaultMerchantID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return errors.New("recipient is used by another merchant")
+	}
+	return err
+}
+
+func (c *Connection) CreateMerchant(ctx context.Context, m core.Merchant) error {
+	_, err := c.postgres.Exec(ctx, `
+		INSERT INTO payments.merchants (id, name, recipient, currencies, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		m.ID, m.Name, m.Recipient.ToRaw(), nonNilStrings(m.Currencies), m.CreatedAt, m.UpdatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return core.ErrAlreadyExists
+	}
+	return err
+}
+
+func (c *Connection) GetMerchant(ctx context.Context, id uuid.UUID) (core.Merchant, error) {
+	m, err := scanMerchant(c.postgres.QueryRow(ctx, `
+		SELECT `+merchantColumns+`
+		FROM payments.merchants
+		WHERE id = $1`, id))
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.Merchant{}, core.ErrNotFound
+	}
+	return m, err
+}
+
+// GetMerchants returns all merchants with recipients
+func (c *Connection) GetMerchants(ctx context.Context) ([]core.Merchant, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT `+merchantColumns+`
+		FROM payments.merchants
+		WHERE recipient IS NOT NULL
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []core.Merchant
+	for rows.Next() {
+		m, err := scanMerchant(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
+
+// UpdateMerchant updates the name and currencies, the recipient can not be changed
+func (c *Connection) UpdateMerchant(ctx context.Context, m core.Merchant) error {
+	tag, err := c.postgres.Exec(ctx, `
+		UPDATE payments.merchants
+		SET name = $1, currencies = $2, updated_at = $3
+		WHERE id = $4`,
+		m.Name, nonNilStrings(m.Currencies), m.UpdatedAt, m.ID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return core.ErrNotFound
+	}
+	return nil
+}
+
+func (c *Connection) CreateMerchantToken(ctx context.Context, t core.MerchantToken) error {
+	_, err := c.postgres.Exec(ctx, `
+		INSERT INTO payments.merchant_tokens (id, merchant_id, name, token_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		t.ID, t.MerchantID, t.Name, t.Hash, t.CreatedAt)
+	return err
+}
+
+func (c *Connection) GetMerchantTokens(ctx context.Context, merchantID uuid.UUID) ([]core.MerchantToken, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT id, merchant_id, name, token_hash, created_at
+		FROM payments.merchant_tokens
+		WHERE merchant_id = $1
+		ORDER BY id`, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []core.MerchantToken
+	for rows.Next() {
+		var t core.MerchantToken
+		err = rows.Scan(&t.ID, &t.MerchantID, &t.Name, &t.Hash, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, rows.Err()
+}
+
+func (c *Connection) DeleteMerchantToken(ctx context.Context, merchantID, id uuid.UUID) error {
+	tag, err := c.postgres.Exec(ctx, `
+		DELETE FROM payments.merchant_tokens
+		WHERE id = $1 AND merchant_id = $2`, id, merchantID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return core.ErrNotFound
+	}
+	return nil
+}
+
+// GetMerchantByToken returns the merchant which owns the token with the hash
+func (c *Connection) GetMerchantByToken(ctx context.Context, hash []byte) (core.Merchant, error) {
+	m, err := scanMerchant(c.postgres.QueryRow(ctx, `
+		SELECT `+prefixColumns("m", merchantColumns)+`
+		FROM payments.merchant_tokens AS t
+		JOIN payments.merchants AS m ON m.id = t.merchant_id
+		WHERE t.token_hash = $1 AND m.recipient IS NOT NULL`, hash))
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.Merchant{}, core.ErrNotFound
+	}
+	return m, err
+}
+
+func scanMerchant(row pgx.Row) (core.Merchant, error) {
+	var (
+		m         core.Merchant
+		recipient *string
+	)
+	err := row.Scan(&m.ID, &m.Name, &recipient, &m.Currencies, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return core.Merchant{}, err
+	}
+	if recipient != nil {
+		m.Recipient, err = ton.ParseAccountID(*recipient)
+		if err != nil {
+			return core.Merchant{}, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,10 @@
+BEGIN;
+
+update payments.invoices set overpayment = overpayment + received where status in ('waiting', 'partially_paid');
+update payments.invoices set status = 'waiting' where status = 'partially_paid';
+update payments.invoice_notifications set status = 'waiting' where status = 'partially_paid';
+
+alter table payments.invoices drop column if exists received;
+alter table payments.invoice_notifications drop column if exists received;
+
+COMMIT;
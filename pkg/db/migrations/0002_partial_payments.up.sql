@@ -0,0 +1,13 @@
+BEGIN;
+
+-- the new value can not be used until the transaction is committed
+alter type invoice_status_type add value if not exists 'partially_paid' after 'waiting';
+
+alter table payments.invoices add column if not exists received numeric not null default 0;
+alter table payments.invoice_notifications add column if not exists received numeric not null default 0;
+
+-- underpayments of waiting invoices were previously accumulated as overpayment
+update payments.invoices set received = overpayment, overpayment = 0 where status = 'waiting';
+update payments.invoices set received = amount where status = 'paid';
+
+COMMIT;
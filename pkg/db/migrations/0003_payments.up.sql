@@ -0,0 +1,17 @@
+BEGIN;
+
+create table if not exists payments.payments
+(
+    tx_hash     bytea       not null,
+    lt          bigint      not null,
+    invoice_id  uuid        not null references payments.invoices (id),
+    currency    uuid        not null references payments.currencies (id),
+    amount      numeric     not null,
+    paid_by     text        not null,
+    recipient   text        not null,
+    created_at  timestamptz not null,  -- transaction time
+    primary key (tx_hash, currency)    -- one transaction can carry TON and extra currencies
+);
+create index if not exists payments_invoice_id_lt_idx on payments.payments (invoice_id, lt);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+drop table if exists payments.unmatched_payments;
+drop type if exists unmatched_payment_reason_type;
+
+COMMIT;
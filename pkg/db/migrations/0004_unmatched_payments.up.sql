@@ -0,0 +1,23 @@
+BEGIN;
+
+create type   unmatched_payment_reason_type as enum ('invalid_comment', 'unknown_invoice', 'currency_mismatch');
+create table if not exists payments.unmatched_payments
+(
+    id           uuid primary key,
+    tx_hash      bytea       not null,
+    lt           bigint      not null,
+    currency     uuid        not null references payments.currencies (id),
+    amount       numeric     not null,
+    paid_by      text        not null,
+    recipient    text        not null,
+    comment      text        not null,
+    reason       unmatched_payment_reason_type not null,
+    created_at   timestamptz not null,  -- transaction time
+    received_at  timestamptz not null,
+    attached_to  uuid references payments.invoices (id),  -- invoice chosen during manual reconciliation
+    attached_at  timestamptz,
+    unique (tx_hash, currency)
+);
+create index if not exists unmatched_payments_attached_to_id_idx on payments.unmatched_payments (attached_to, id);
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+drop index if exists payments.invoices_created_at_idx;
+drop index if exists payments.invoices_paid_at_idx;
+drop index if exists payments.invoices_paid_by_idx;
+drop index if exists payments.invoices_private_info_idx;
+drop index if exists payments.payments_paid_by_idx;
+
+COMMIT;
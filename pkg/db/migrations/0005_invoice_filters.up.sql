@@ -0,0 +1,9 @@
+BEGIN;
+
+create index if not exists invoices_created_at_idx on payments.invoices (created_at);
+create index if not exists invoices_paid_at_idx on payments.invoices (paid_at);
+create index if not exists invoices_paid_by_idx on payments.invoices (paid_by);
+create index if not exists invoices_private_info_idx on payments.invoices using gin (private_info jsonb_path_ops);
+create index if not exists payments_paid_by_idx on payments.payments (paid_by);
+
+COMMIT;
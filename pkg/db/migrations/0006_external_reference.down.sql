@@ -0,0 +1,7 @@
+BEGIN;
+
+drop index if exists payments.invoices_external_reference_idx;
+alter table payments.invoices drop column if exists external_reference;
+alter table payments.invoice_notifications drop column if exists external_reference;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+alter table payments.invoices add column if not exists external_reference text;
+alter table payments.invoice_notifications add column if not exists external_reference text;
+create unique index if not exists invoices_external_reference_idx on payments.invoices (external_reference);
+
+COMMIT;
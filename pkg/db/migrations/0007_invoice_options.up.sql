@@ -0,0 +1,13 @@
+BEGIN;
+
+create table if not exists payments.invoice_options -- alternative currencies of the invoice
+(
+    invoice_id  uuid    not null references payments.invoices (id),
+    currency    uuid    not null references payments.currencies (id),
+    amount      numeric not null,
+    received    numeric not null default 0,
+    primary key (invoice_id, currency)
+);
+create index if not exists invoice_options_currency_idx on payments.invoice_options (currency);
+
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+
+alter table payments.invoice_options drop column if exists rate;
+
+alter table payments.invoice_notifications drop column if exists rate;
+alter table payments.invoice_notifications drop column if exists fiat_amount;
+alter table payments.invoice_notifications drop column if exists fiat_currency;
+
+alter table payments.invoices drop column if exists rate;
+alter table payments.invoices drop column if exists fiat_amount;
+alter table payments.invoices drop column if exists fiat_currency;
+
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+
+alter table payments.invoices add column if not exists fiat_currency text;
+alter table payments.invoices add column if not exists fiat_amount numeric;
+alter table payments.invoices add column if not exists rate numeric;
+
+alter table payments.invoice_notifications add column if not exists fiat_currency text;
+alter table payments.invoice_notifications add column if not exists fiat_amount numeric;
+alter table payments.invoice_notifications add column if not exists rate numeric;
+
+alter table payments.invoice_options add column if not exists rate numeric;
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+drop table if exists payments.subscription_notifications;
+drop type if exists subscription_event_type;
+
+alter table payments.invoice_notifications drop column if exists cycle;
+alter table payments.invoice_notifications drop column if exists subscription_id;
+drop index if exists payments.invoices_subscription_cycle_idx;
+alter table payments.invoices drop column if exists cycle;
+alter table payments.invoices drop column if exists subscription_id;
+
+drop table if exists payments.subscriptions;
+drop type if exists subscription_status_type;
+
+COMMIT;
@@ -0,0 +1,48 @@
+BEGIN;
+
+create type   subscription_status_type as enum ('active', 'finished', 'cancelled');
+create table  payments.subscriptions
+(
+    id                 uuid primary key,
+    status             subscription_status_type not null,
+    recipient          text not null,
+    -- invoice template
+    currency           uuid not null references payments.currencies (id),
+    amount             numeric not null,
+    life_time          bigint not null, -- seconds
+    private_info       jsonb not null,
+    metadata           jsonb not null,
+    -- schedule
+    period             text not null,
+    interval           integer not null,
+    start_at           timestamptz not null,
+    end_at             timestamptz,
+    notify_before      bigint not null, -- seconds
+    next_cycle         integer not null default 0,
+    next_cycle_at      timestamptz not null,
+    upcoming_notified  boolean not null default false,
+    created_at         timestamptz not null,
+    updated_at         timestamptz not null
+);
+create index if not exists subscriptions_status_next_cycle_at_idx on payments.subscriptions (status, next_cycle_at);
+
+alter table payments.invoices add column if not exists subscription_id uuid references payments.subscriptions (id);
+alter table payments.invoices add column if not exists cycle integer;
+create unique index if not exists invoices_subscription_cycle_idx on payments.invoices (subscription_id, cycle);
+alter table payments.invoice_notifications add column if not exists subscription_id uuid;
+alter table payments.invoice_notifications add column if not exists cycle integer;
+
+create type   subscription_event_type as enum ('upcoming', 'created', 'missed');
+create table  payments.subscription_notifications
+(
+    id               uuid primary key,
+    subscription_id  uuid not null references payments.subscriptions (id),
+    type             subscription_event_type not null,
+    cycle            integer not null,
+    cycle_at         timestamptz not null,
+    invoice_id       uuid,
+    created_at       timestamptz not null
+);
+create index if not exists subscription_notifications_created_at_idx on payments.subscription_notifications (created_at);
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+-- values of unmatched_payment_reason_type can not be removed
+alter table payments.invoice_notifications drop column if exists payment_request_id;
+drop index if exists payments.invoices_payment_request_id_idx;
+alter table payments.invoices drop column if exists payment_request_id;
+
+drop table if exists payments.payment_requests;
+drop type if exists payment_request_status_type;
+
+COMMIT;
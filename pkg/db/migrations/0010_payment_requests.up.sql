@@ -0,0 +1,25 @@
+BEGIN;
+
+create type   payment_request_status_type as enum ('active', 'disabled');
+create table  payments.payment_requests -- reusable payment destinations, every payment creates a child invoice
+(
+    id            uuid primary key,
+    status        payment_request_status_type not null,
+    recipient     text not null,
+    currency      uuid not null references payments.currencies (id),
+    min_amount    numeric not null,
+    amount        numeric, -- suggested amount for payment links
+    private_info  jsonb not null,
+    metadata      jsonb not null,
+    created_at    timestamptz not null,
+    updated_at    timestamptz not null
+);
+
+alter table payments.invoices add column if not exists payment_request_id uuid references payments.payment_requests (id);
+create index if not exists invoices_payment_request_id_idx on payments.invoices (payment_request_id);
+alter table payments.invoice_notifications add column if not exists payment_request_id uuid;
+
+alter type unmatched_payment_reason_type add value if not exists 'below_minimum';
+alter type unmatched_payment_reason_type add value if not exists 'request_disabled';
+
+COMMIT;
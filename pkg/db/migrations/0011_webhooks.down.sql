@@ -0,0 +1,6 @@
+BEGIN;
+
+drop table if exists payments.webhook_deliveries;
+drop table if exists payments.webhooks;
+
+COMMIT;
@@ -0,0 +1,29 @@
+BEGIN;
+
+create table  payments.webhooks -- webhook subscriptions managed via API
+(
+    id               uuid primary key,
+    url              text not null,
+    secret           text not null,
+    previous_secret  text, -- still signs deliveries during rotation
+    event_types      text[] not null, -- empty for all events
+    currencies       text[] not null, -- tickers, empty for all currencies
+    enabled          boolean not null,
+    created_at       timestamptz not null,
+    updated_at       timestamptz not null
+);
+
+create table  payments.webhook_deliveries -- delivery state of notifications per webhook
+(
+    webhook_id    uuid not null references payments.webhooks (id) on delete cascade,
+    event_id      uuid not null, -- invoice ID or subscription event ID
+    version       timestamptz not null, -- updated_at of the delivered invoice or created_at of the event
+    attempts      integer not null,
+    last_error    text,
+    delivered_at  timestamptz,
+    updated_at    timestamptz not null,
+    primary key (webhook_id, event_id)
+);
+create index if not exists webhook_deliveries_updated_at_idx on payments.webhook_deliveries (updated_at);
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+drop table if exists payments.delivery_attempts;
+drop table if exists payments.deliveries;
+drop type if exists delivery_status_type;
+
+create table  payments.webhook_deliveries -- delivery state of notifications per webhook
+(
+    webhook_id    uuid not null references payments.webhooks (id) on delete cascade,
+    event_id      uuid not null, -- invoice ID or subscription event ID
+    version       timestamptz not null, -- updated_at of the delivered invoice or created_at of the event
+    attempts      integer not null,
+    last_error    text,
+    delivered_at  timestamptz,
+    updated_at    timestamptz not null,
+    primary key (webhook_id, event_id)
+);
+create index if not exists webhook_deliveries_updated_at_idx on payments.webhook_deliveries (updated_at);
+
+COMMIT;
@@ -0,0 +1,37 @@
+BEGIN;
+
+-- delivery state is replaced by the delivery log
+drop table if exists payments.webhook_deliveries;
+
+create type   delivery_status_type as enum ('pending', 'delivered', 'dead');
+create table  payments.deliveries -- notification payloads for every destination
+(
+    id               uuid primary key,
+    sender           text not null, -- configured sender name or 'webhook'
+    webhook_id       uuid references payments.webhooks (id) on delete cascade,
+    event_id         uuid not null, -- invoice ID or subscription event ID
+    event_type       text not null,
+    payload          jsonb not null,
+    status           delivery_status_type not null,
+    attempts         integer not null,
+    next_attempt_at  timestamptz not null,
+    last_error       text,
+    created_at       timestamptz not null,
+    updated_at       timestamptz not null,
+    delivered_at     timestamptz
+);
+create index if not exists deliveries_status_next_attempt_at_idx on payments.deliveries (status, next_attempt_at);
+create index if not exists deliveries_event_id_idx on payments.deliveries (event_id);
+create index if not exists deliveries_webhook_id_idx on payments.deliveries (webhook_id);
+
+create table  payments.delivery_attempts
+(
+    id            uuid primary key,
+    delivery_id   uuid not null references payments.deliveries (id) on delete cascade,
+    attempted_at  timestamptz not null,
+    duration_ms   bigint not null,
+    error         text -- null for successful attempt
+);
+create index if not exists delivery_attempts_delivery_id_idx on payments.delivery_attempts (delivery_id);
+
+COMMIT;
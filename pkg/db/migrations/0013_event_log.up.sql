@@ -0,0 +1,14 @@
+BEGIN;
+
+create table  payments.events -- log of notifications for event streams
+(
+    seq         bigserial primary key,
+    event_id    uuid not null, -- invoice ID or subscription event ID
+    invoice_id  uuid,
+    type        text not null,
+    payload     jsonb not null,
+    created_at  timestamptz not null
+);
+create index if not exists events_created_at_idx on payments.events (created_at);
+
+COMMIT;
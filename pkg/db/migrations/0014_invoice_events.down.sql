@@ -0,0 +1,35 @@
+BEGIN;
+
+update payments.webhooks
+set event_types = array_remove(array_replace(array_replace(event_types, 'invoice.created', 'invoice.waiting'), 'payment.received', 'invoice.partially_paid'), 'overpayment.received');
+
+create table  payments.invoice_notifications -- sync with payments.invoices
+(
+    id                 uuid, -- not primary key
+    currency           uuid not null references payments.currencies (id),
+    created_at         timestamptz not null,
+    expire_at          timestamptz not null,
+    updated_at         timestamptz not null,
+    paid_at            timestamptz,
+    amount             numeric not null,
+    overpayment        numeric not null,
+    status             invoice_status_type not null,
+    recipient          text not null,
+    paid_by            text,
+    tx_hash            bytea,
+    private_info       jsonb not null,
+    metadata           jsonb not null,
+    received           numeric not null default 0,
+    external_reference text,
+    fiat_currency      text,
+    fiat_amount        numeric,
+    rate               numeric,
+    subscription_id    uuid,
+    cycle              integer,
+    payment_request_id uuid
+);
+create index if not exists invoice_notifications_updated_at_idx on payments.invoice_notifications (updated_at);
+
+drop table if exists payments.invoice_events;
+
+COMMIT;
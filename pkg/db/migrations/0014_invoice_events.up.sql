@@ -0,0 +1,34 @@
+BEGIN;
+
+create table  payments.invoice_events -- invoice lifecycle events waiting for notification
+(
+    id               uuid primary key,
+    invoice_id       uuid not null references payments.invoices (id),
+    type             text not null,
+    previous_status  invoice_status_type, -- null if the invoice did not exist before the event
+    payment_tx_hash  bytea, -- payment of payment.received and overpayment.received events
+    payment_currency uuid references payments.currencies (id),
+    created_at       timestamptz not null
+);
+create index if not exists invoice_events_created_at_idx on payments.invoice_events (created_at);
+
+-- pending snapshots become events of the status they were taken in
+insert into payments.invoice_events (id, invoice_id, type, created_at)
+select gen_random_uuid(), n.id,
+       case n.status
+           when 'waiting' then 'invoice.created'
+           when 'partially_paid' then 'payment.received'
+           when 'paid' then 'invoice.paid'
+           when 'cancelled' then 'invoice.cancelled'
+           else 'invoice.expired'
+       end,
+       n.updated_at
+from payments.invoice_notifications AS n
+where exists (select 1 from payments.invoices AS i where i.id = n.id);
+
+drop table if exists payments.invoice_notifications;
+
+update payments.webhooks
+set event_types = array_replace(array_replace(event_types, 'invoice.waiting', 'invoice.created'), 'invoice.partially_paid', 'payment.received');
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+alter table payments.invoices drop column if exists buyer_email;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+alter table payments.invoices add column if not exists buyer_email text; -- receives the receipt after the payment
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+drop index if exists payments.deliveries_pending_invoice_id_idx;
+alter table payments.deliveries drop column if exists invoice_id;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+alter table payments.deliveries add column if not exists invoice_id uuid; -- deliveries of the same invoice are sent in order
+
+update payments.deliveries AS d
+set invoice_id = e.invoice_id
+from payments.events AS e
+where e.event_id = d.event_id and e.invoice_id is not null;
+
+create index if not exists deliveries_pending_invoice_id_idx on payments.deliveries (invoice_id, created_at) where status = 'pending';
+
+COMMIT;
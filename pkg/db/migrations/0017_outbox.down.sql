@@ -0,0 +1,6 @@
+BEGIN;
+
+alter table payments.invoice_events drop column if exists seq;
+alter table payments.subscription_notifications drop column if exists seq;
+
+COMMIT;
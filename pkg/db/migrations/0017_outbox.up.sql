@@ -0,0 +1,7 @@
+BEGIN;
+
+-- event IDs are stable hashes now, so the outbox is ordered by sequence
+alter table payments.invoice_events add column if not exists seq bigserial;
+alter table payments.subscription_notifications add column if not exists seq bigserial;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+alter table payments.currencies drop column if exists ticker;
+alter table payments.currencies drop column if exists decimals;
+alter table payments.currencies drop column if exists enabled;
+
+COMMIT;
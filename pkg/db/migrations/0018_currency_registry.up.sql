@@ -0,0 +1,8 @@
+BEGIN;
+
+-- currencies can be added and disabled at runtime, so their tickers and state are kept in the database
+alter table payments.currencies add column if not exists ticker text;
+alter table payments.currencies add column if not exists decimals integer not null default 0;
+alter table payments.currencies add column if not exists enabled boolean not null default true;
+
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN;
+
+drop index if exists payments.invoices_recipient_id_idx;
+drop index if exists payments.invoices_recipient_external_reference_idx;
+create unique index if not exists invoices_external_reference_idx on payments.invoices (external_reference);
+
+drop index if exists payments.events_merchant_id_seq_idx;
+drop index if exists payments.deliveries_merchant_id_idx;
+alter table payments.events drop column if exists merchant_id;
+alter table payments.deliveries drop column if exists merchant_id;
+alter table payments.webhooks drop column if exists merchant_id;
+
+drop table if exists payments.merchant_tokens;
+drop table if exists payments.merchants;
+
+COMMIT;
@@ -0,0 +1,44 @@
+BEGIN;
+
+create table  payments.merchants -- tenants of the service, every merchant has its own recipient wallet
+(
+    id          uuid primary key,
+    name        text not null,
+    recipient   text unique, -- null only until the default merchant is saved at startup
+    currencies  text[] not null, -- accepted tickers, empty for all currencies
+    created_at  timestamptz not null,
+    updated_at  timestamptz not null
+);
+-- the default merchant is configured by RECIPIENT and TOKEN and owns the existing data
+insert into payments.merchants (id, name, recipient, currencies, created_at, updated_at)
+values ('00000000-0000-0000-0000-000000000001', 'default', null, '{}', now(), now())
+on conflict do nothing;
+
+create table  payments.merchant_tokens -- API tokens of merchants, only hashes are stored
+(
+    id           uuid primary key,
+    merchant_id  uuid not null references payments.merchants (id) on delete cascade,
+    name         text not null,
+    token_hash   bytea not null unique,
+    created_at   timestamptz not null
+);
+create index if not exists merchant_tokens_merchant_id_idx on payments.merchant_tokens (merchant_id);
+
+alter table payments.webhooks add column if not exists merchant_id uuid not null
+    default '00000000-0000-0000-0000-000000000001' references payments.merchants (id);
+alter table payments.webhooks alter column merchant_id drop default;
+alter table payments.deliveries add column if not exists merchant_id uuid not null
+    default '00000000-0000-0000-0000-000000000001';
+alter table payments.deliveries alter column merchant_id drop default;
+alter table payments.events add column if not exists merchant_id uuid not null
+    default '00000000-0000-0000-0000-000000000001';
+alter table payments.events alter column merchant_id drop default;
+create index if not exists deliveries_merchant_id_idx on payments.deliveries (merchant_id);
+create index if not exists events_merchant_id_seq_idx on payments.events (merchant_id, seq);
+
+-- external references are unique per merchant
+drop index if exists payments.invoices_external_reference_idx;
+create unique index if not exists invoices_recipient_external_reference_idx on payments.invoices (recipient, external_reference);
+create index if not exists invoices_recipient_id_idx on payments.invoices (recipient, id);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+alter table payments.invoices add column if not exists paid_currency uuid references payments.currencies (id); -- currency which settled the invoice
+-- paid invoices used to take the currency of the option which paid them
+update payments.invoices set paid_currency = currency where status = 'paid' and paid_currency is null;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+alter table blockchain.trusted_mc_block drop column if exists key_block;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- older versions saved the latest block without proofs, such blocks are not trusted
+alter table blockchain.trusted_mc_block add column if not exists key_block boolean not null default false; -- the block is a proven key block
+
+COMMIT;
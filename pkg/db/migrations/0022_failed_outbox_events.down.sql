@@ -0,0 +1,10 @@
+BEGIN;
+
+delete from payments.invoice_events where failed_at is not null;
+delete from payments.subscription_notifications where failed_at is not null;
+alter table payments.invoice_events drop column if exists failed_at;
+alter table payments.invoice_events drop column if exists error;
+alter table payments.subscription_notifications drop column if exists failed_at;
+alter table payments.subscription_notifications drop column if exists error;
+
+COMMIT;
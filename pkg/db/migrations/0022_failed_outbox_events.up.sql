@@ -0,0 +1,9 @@
+BEGIN;
+
+-- events which can not be sent are kept for investigation and deleted after the retention period
+alter table payments.invoice_events add column if not exists failed_at timestamptz;
+alter table payments.invoice_events add column if not exists error text;
+alter table payments.subscription_notifications add column if not exists failed_at timestamptz;
+alter table payments.subscription_notifications add column if not exists error text;
+
+COMMIT;
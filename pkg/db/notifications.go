@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+)
+
+// notificationChannel is the Postgres channel which signals about new invoice and subscription events
+const notificationChannel = "payments_notifications"
+
+// notify signals listeners about new notification rows. Inside a transaction the signal is sent on commit,
+// several signals of one transaction are delivered once.
+func notify(ctx context.Context, exec executor) error {
+	_, err := exec.Exec(ctx, `SELECT pg_notify($1, '')`, notificationChannel)
+	return err
+}
+
+// ListenNotifications listens for new notification rows on a dedicated connection.
+// Signals are coalesced, so one value in the channel can stand for several notifications.
+// The channel is closed when the connection is lost or ctx is done, the caller can listen again.
+func (c *Connection) ListenNotifications(ctx context.Context) (<-chan struct{}, error) {
+	conn, err := pgx.ConnectConfig(ctx, c.postgres.Config().ConnConfig.Copy())
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Exec(ctx, "LISTEN "+notificationChannel)
+	if err != nil {
+		_ = conn.Close(context.Background())
+		return nil, err
+	}
+	signals := make(chan struct{}, 1)
+	go func() {
+		defer close(signals)
+		defer func() {
+			err := conn.Close(context.Background())
+			if err != nil {
+				slog.Error("close listening connection", "error", err.Error())
+			}
+		}()
+		for {
+			_, err := conn.WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("wait for notification", "error", err.Error())
+				}
+				return
+			}
+			select {
+			case signals <- struct{}{}:
+			default: // the previous signal is not handled yet
+			}
+		}
+	}()
+	return signals, nil
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"math/big"
+	"time"
+)
+
+func (c *Connection) savePayment(ctx context.Context, tx pgx.Tx, currencyID uuid.UUID, p core.Payment) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO payments.payments (tx_hash, lt, invoice_id, currency, amount, paid_by, recipient, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		p.TxHash, p.Lt, p.InvoiceID, currencyID, p.Amount.String(), p.PaidBy.ToRaw(), p.Recipient.ToRaw(), p.CreatedAt)
+	return err
+}
+
+func (c *Connection) GetInvoicePayments(ctx context.Context, invoiceID core.InvoiceID) ([]core.Payment, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT tx_hash, lt, invoice_id, currency, amount, paid_by, recipient, created_at
+		FROM payments.payments
+		WHERE invoice_id = $1
+		ORDER BY lt`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type paymentRow struct {
+		payment    core.Payment
+		currencyID uuid.UUID
+	}
+	var paymentRows []paymentRow
+	for rows.Next() {
+		var (
+			r                         paymentRow
+			amount, paidBy, recipient string
+		)
+		err = rows.Scan(&r.payment.TxHash, &r.payment.Lt, &r.payment.InvoiceID, &r.currencyID, &amount, &paidBy, &recipient, &r.payment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		r.payment.Amount, _ = new(big.Int).SetString(amount, 10)
+		r.payment.PaidBy, err = ton.ParseAccountID(paidBy)
+		if err != nil {
+			return nil, err
+		}
+		r.payment.Recipient, err = ton.ParseAccountID(recipient)
+		if err != nil {
+			return nil, err
+		}
+		paymentRows = append(paymentRows, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]core.Payment, 0, len(paymentRows))
+	for _, r := range paymentRows {
+		currency, err := c.getCurrencyByID(ctx, r.currencyID)
+		if err != nil {
+			return nil, err
+		}
+		r.payment.Currency = *currency
+		res = append(res, r.payment)
+	}
+	return res, nil
+}
+
+func (c *Connection) saveUnmatchedPayment(ctx context.Context, tx pgx.Tx, currencyID uuid.UUID, p core.Payment) error {
+	reason := core.InvalidCommentUnmatchedReason
+	if p.InvoiceID != (core.InvoiceID{}) {
+		// invoices of other recipients are unknown, so their existence is not disclosed to the recipient
+		var exists bool
+		err := tx.QueryRow(ctx, `
+			SELECT EXISTS(SELECT 1 FROM payments.invoices WHERE id = $1 AND recipient = $2)`, p.InvoiceID, p.Recipient.ToRaw()).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		reason = core.UnknownInvoiceUnmatchedReason
+		if exists {
+			reason = core.CurrencyMismatchUnmatchedReason
+		}
+	}
+	return c.saveUnmatchedPaymentWithReason(ctx, tx, currencyID, p, reason)
+}
+
+func (c *Connection) saveUnmatchedPaymentWithReason(ctx context.Context, tx pgx.Tx, currencyID uuid.UUID, p core.Payment, reason core.UnmatchedPaymentReason) error {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO payments.unmatched_payments 
+		(id, tx_hash, lt, currency, amount, paid_by, recipient, comment, reason, created_at, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (tx_hash, currency) DO NOTHING`,
+		id, p.TxHash, p.Lt, currencyID, p.Amount.String(), p.PaidBy.ToRaw(), p.Recipient.ToRaw(), p.Comment, reason, p.CreatedAt, time.Now())
+	return err
+}
+
+// GetUnmatchedPayments returns payments to the recipient that are not attached to any invoice yet
+func (c *Connection) GetUnmatchedPayments(ctx context.Context, recipient ton.AccountID, after uuid.UUID, limit int64) ([]core.UnmatchedPayment, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT id, tx_hash, lt, currency, amount, paid_by, recipient, comment, reason, created_at, received_at, attached_to, attached_at
+		FROM payments.unmatched_payments
+		WHERE attached_to IS NULL AND recipient = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`, recipient.ToRaw(), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type unmatchedRow struct {
+		payment    core.UnmatchedPayment
+		currencyID uuid.UUID
+	}
+	var unmatchedRows []unmatchedRow
+	for rows.Next() {
+		var r unmatchedRow
+		r.currencyID, err = scanUnmatchedPayment(rows, &r.payment)
+		if err != nil {
+			return nil, err
+		}
+		unmatchedRows = append(unmatchedRows, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]core.UnmatchedPayment, 0, len(unmatchedRows))
+	for _, r := range unmatchedRows {
+		currency, err := c.getCurrencyByID(ctx, r.currencyID)
+		if err != nil {
+			return nil, err
+		}
+		r.payment.Payment.Currency = *currency
+		res = append(res, r.payment)
+	}
+	return res, nil
+}
+
+// AttachUnmatchedPayment links an unmatched payment to the recipient to the invoice and processes it as a regular payment
+func (c *Connection) AttachUnmatchedPayment(ctx context.Context, recipient ton.AccountID, id uuid.UUID, invoiceID core.InvoiceID) (core.Invoice, error) {
+	tx, err := c.postgres.Begin(ctx)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	defer rollbackDbTx(ctx, tx)
+
+	var unmatched core.UnmatchedPayment
+	currencyID, err := scanUnmatchedPayment(tx.QueryRow(ctx, `
+		SELECT id, tx_hash, lt, currency, amount, paid_by, recipient, comment, reason, created_at, received_at, attached_to, attached_at
+		FROM payments.unmatched_payments
+		WHERE id = $1 AND recipient = $2 AND attached_to IS NULL
+		FOR UPDATE`, id, recipient.ToRaw()), &unmatched)
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.Invoice{}, fmt.Errorf("unmatched payment %w", core.ErrNotFound)
+	} else if err != nil {
+		return core.Invoice{}, err
+	}
+	var exists bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM payments.invoices AS i
+			WHERE i.id = $1 AND i.recipient = $3 AND (i.currency = $2 OR EXISTS (
+				SELECT 1 FROM payments.invoice_options AS o WHERE o.invoice_id = i.id AND o.currency = $2)))`,
+		invoiceID, currencyID, unmatched.Payment.Recipient.ToRaw()).Scan(&exists)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	if !exists {
+		return core.Invoice{}, fmt.Errorf("invoice with the payment currency %w", core.ErrNotFound)
+	}
+	currency, err := c.getCurrencyByID(ctx, currencyID)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	payment := unmatched.Payment
+	payment.Currency = *currency
+	payment.InvoiceID = invoiceID
+	events, err := c.processPayment(ctx, tx, payment)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE payments.unmatched_payments
+		SET attached_to = $1, attached_at = $2
+		WHERE id = $3`, invoiceID, time.Now(), id)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	err = c.saveInvoiceEvents(ctx, tx, events)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	return c.GetInvoice(ctx, invoiceID)
+}
+
+func scanUnmatchedPayment(row pgx.Row, p *core.UnmatchedPayment) (uuid.UUID, error) {
+	var (
+		currencyID                uuid.UUID
+		amount, paidBy, recipient string
+		err                       error
+	)
+	err = row.Scan(&p.ID, &p.Payment.TxHash, &p.Payment.Lt, &currencyID, &amount, &paidBy, &recipient, &p.Payment.Comment,
+		&p.Reason, &p.Payment.CreatedAt, &p.ReceivedAt, &p.AttachedTo, &p.AttachedAt)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	p.Payment.Amount, _ = new(big.Int).SetString(amount, 10)
+	p.Payment.PaidBy, err = ton.ParseAccountID(paidBy)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	p.Payment.Recipient, err = ton.ParseAccountID(recipient)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return currencyID, nil
+}
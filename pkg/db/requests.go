@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"math/big"
+	"time"
+)
+
+const paymentRequestColumns = `id, status, recipient, currency, min_amount, amount, private_info, metadata, created_at, updated_at`
+
+func (c *Connection) CreatePaymentRequest(ctx context.Context, r core.PaymentRequest) error {
+	currencyID, err := c.getCurrencyID(ctx, r.Currency)
+	if err != nil {
+		return err
+	}
+	metaBytes, err := marshalJsonForDb(r.Metadata)
+	if err != nil {
+		return err
+	}
+	privateInfoBytes, err := marshalJsonForDb(r.PrivateInfo)
+	if err != nil {
+		return err
+	}
+	var amount *string
+	if r.Amount != nil {
+		s := r.Amount.String()
+		amount = &s
+	}
+	_, err = c.postgres.Exec(ctx, `
+		INSERT INTO payments.payment_requests
+		(id, status, recipient, currency, min_amount, amount, private_info, metadata, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		r.ID, r.Status, r.Recipient.ToRaw(), currencyID, r.MinAmount.String(), amount, privateInfoBytes, metaBytes,
+		r.CreatedAt, r.UpdatedAt)
+	return err
+}
+
+func (c *Connection) GetPaymentRequest(ctx context.Context, id uuid.UUID) (core.PaymentRequest, error) {
+	r, err := scanPaymentRequest(c.postgres.QueryRow(ctx, `
+		SELECT `+paymentRequestColumns+`
+		FROM payments.payment_requests
+		WHERE id = $1`, id))
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.PaymentRequest{}, core.ErrNotFound
+	} else if err != nil {
+		return core.PaymentRequest{}, err
+	}
+	return c.convertPaymentRequestRow(ctx, r)
+}
+
+// GetPaymentRequests returns payment requests of the recipient
+func (c *Connection) GetPaymentRequests(ctx context.Context, recipient ton.AccountID, after uuid.UUID, limit int64) ([]core.PaymentRequest, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT `+paymentRequestColumns+`
+		FROM payments.payment_requests
+		WHERE recipient = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`, recipient.ToRaw(), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rowsData []paymentRequestRow
+	for rows.Next() {
+		r, err := scanPaymentRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		rowsData = append(rowsData, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]core.PaymentRequest, 0, len(rowsData))
+	for _, r := range rowsData {
+		request, err := c.convertPaymentRequestRow(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, request)
+	}
+	return res, nil
+}
+
+// DisablePaymentRequest stops accepting payments to the recipient request. Further payments are saved as unmatched.
+func (c *Connection) DisablePaymentRequest(ctx context.Context, recipient ton.AccountID, id uuid.UUID) (core.PaymentRequest, error) {
+	tag, err := c.postgres.Exec(ctx, `
+		UPDATE payments.payment_requests
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4 AND recipient = $5`,
+		core.DisabledPaymentRequestStatus, time.Now(), id, core.ActivePaymentRequestStatus, recipient.ToRaw())
+	if err != nil {
+		return core.PaymentRequest{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return core.PaymentRequest{}, core.ErrNotFound
+	}
+	return c.GetPaymentRequest(ctx, id)
+}
+
+// processRequestPayment creates a paid child invoice if the payment is made to the payment request.
+// Returns the events of the child invoice and false if there is no payment request with the payment ID.
+func (c *Connection) processRequestPayment(ctx context.Context, tx pgx.Tx, currencyID uuid.UUID, p core.Payment) ([]core.InvoiceEvent, bool, error) {
+	r, err := scanPaymentRequest(tx.QueryRow(ctx, `
+		SELECT `+paymentRequestColumns+`
+		FROM payments.payment_requests
+		WHERE id = $1 AND recipient = $2`, p.InvoiceID, p.Recipient.ToRaw()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	request, err := c.convertPaymentRequestRow(ctx, r)
+	if err != nil {
+		return nil, false, err
+	}
+	switch {
+	case r.currencyID != currencyID:
+		return nil, true, c.saveUnmatchedPaymentWithReason(ctx, tx, currencyID, p, core.CurrencyMismatchUnmatchedReason)
+	case request.Status != core.ActivePaymentRequestStatus:
+		return nil, true, c.saveUnmatchedPaymentWithReason(ctx, tx, currencyID, p, core.DisabledRequestUnmatchedReason)
+	case p.Amount.Cmp(request.MinAmount) < 0:
+		return nil, true, c.saveUnmatchedPaymentWithReason(ctx, tx, currencyID, p, core.BelowMinimumUnmatchedReason)
+	}
+	invoice := request.NewChildInvoice(p, time.Now())
+	err = c.saveInvoice(ctx, tx, invoice)
+	if err != nil {
+		return nil, false, fmt.Errorf("save child invoice of payment request %v: %w", request.ID, err)
+	}
+	p.InvoiceID = invoice.ID
+	err = c.savePayment(ctx, tx, currencyID, p)
+	if err != nil {
+		return nil, false, err
+	}
+	return newPaymentEvents(p, nil, invoice.CreatedAt, core.PaymentReceivedEvent, core.InvoicePaidEvent), true, nil
+}
+
+type paymentRequestRow struct {
+	r          core.PaymentRequest
+	recipient  string
+	currencyID uuid.UUID
+	minAmount  string
+	amount     *string
+}
+
+func scanPaymentRequest(row pgx.Row) (paymentRequestRow, error) {
+	var r paymentRequestRow
+	err := row.Scan(&r.r.ID, &r.r.Status, &r.recipient, &r.currencyID, &r.minAmount, &r.amount,
+		&r.r.PrivateInfo, &r.r.Metadata, &r.r.CreatedAt, &r.r.UpdatedAt)
+	return r, err
+}
+
+func (c *Connection) convertPaymentRequestRow(ctx context.Context, row paymentRequestRow) (core.PaymentRequest, error) {
+	currency, err := c.getCurrencyByID(ctx, row.currencyID)
+	if err != nil {
+		return core.PaymentRequest{}, err
+	}
+	recipient, err := ton.ParseAccountID(row.recipient)
+	if err != nil {
+		return core.PaymentRequest{}, err
+	}
+	r := row.r
+	r.Currency = *currency
+	r.Recipient = recipient
+	r.MinAmount, _ = new(big.Int).SetString(row.minAmount, 10)
+	if row.amount != nil {
+		r.Amount, _ = new(big.Int).SetString(*row.amount, 10)
+	}
+	return r, nil
+}
@@ -0,0 +1,306 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"math/big"
+	"time"
+)
+
+const subscriptionColumns = `id, status, recipient, currency, amount, life_time, private_info, metadata, period, interval, start_at, end_at,
+	notify_before, next_cycle, created_at, updated_at`
+
+func (c *Connection) CreateSubscription(ctx context.Context, s core.Subscription) error {
+	currencyID, err := c.getCurrencyID(ctx, s.Template.Currency)
+	if err != nil {
+		return err
+	}
+	metaBytes, err := marshalJsonForDb(s.Template.Metadata)
+	if err != nil {
+		return err
+	}
+	privateInfoBytes, err := marshalJsonForDb(s.Template.PrivateInfo)
+	if err != nil {
+		return err
+	}
+	_, err = c.postgres.Exec(ctx, `
+		INSERT INTO payments.subscriptions
+		(id, status, recipient, currency, amount, life_time, private_info, metadata, period, interval, start_at, end_at,
+		 notify_before, next_cycle, next_cycle_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		s.ID, s.Status, s.Recipient.ToRaw(), currencyID, s.Template.Amount.String(), int64(s.Template.LifeTime.Seconds()),
+		privateInfoBytes, metaBytes, s.Period, s.Interval, s.StartAt, s.EndAt,
+		int64(s.NotifyBefore.Seconds()), s.NextCycle, s.CycleAt(s.NextCycle), s.CreatedAt, s.UpdatedAt)
+	return err
+}
+
+func (c *Connection) GetSubscription(ctx context.Context, id uuid.UUID) (core.Subscription, error) {
+	return c.getSubscription(ctx, c.postgres.QueryRow(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM payments.subscriptions
+		WHERE id = $1`, id))
+}
+
+// GetSubscriptions returns subscriptions of the recipient
+func (c *Connection) GetSubscriptions(ctx context.Context, recipient ton.AccountID, after uuid.UUID, limit int64) ([]core.Subscription, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM payments.subscriptions
+		WHERE recipient = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`, recipient.ToRaw(), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rowsData []subscriptionRow
+	for rows.Next() {
+		r, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		rowsData = append(rowsData, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]core.Subscription, 0, len(rowsData))
+	for _, r := range rowsData {
+		s, err := c.convertSubscriptionRow(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// CancelSubscription stops issuing new invoices of the recipient subscription. Already issued invoices are not changed.
+func (c *Connection) CancelSubscription(ctx context.Context, recipient ton.AccountID, id uuid.UUID) (core.Subscription, error) {
+	tag, err := c.postgres.Exec(ctx, `
+		UPDATE payments.subscriptions
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4 AND recipient = $5`,
+		core.CanceledSubscriptionStatus, time.Now(), id, core.ActiveSubscriptionStatus, recipient.ToRaw())
+	if err != nil {
+		return core.Subscription{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return core.Subscription{}, core.ErrNotFound
+	}
+	return c.GetSubscription(ctx, id)
+}
+
+// maxCyclesPerRun limits cycles of one subscription issued in one transaction after a long downtime
+const maxCyclesPerRun = 10
+
+// ProcessSubscriptions issues invoices of the due cycles and creates notifications about upcoming cycles
+func (c *Connection) ProcessSubscriptions(ctx context.Context) error {
+	tx, err := c.postgres.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer rollbackDbTx(ctx, tx)
+
+	now := time.Now()
+	rows, err := tx.Query(ctx, `
+		SELECT `+subscriptionColumns+`, upcoming_notified
+		FROM payments.subscriptions
+		WHERE status = $1 AND (next_cycle_at <= $2 OR (NOT upcoming_notified AND next_cycle_at - notify_before * interval '1 second' <= $2))
+		ORDER BY next_cycle_at
+		LIMIT 100
+		FOR UPDATE SKIP LOCKED`, core.ActiveSubscriptionStatus, now)
+	if err != nil {
+		return err
+	}
+	var (
+		rowsData         []subscriptionRow
+		upcomingNotified []bool
+	)
+	for rows.Next() {
+		var (
+			r        subscriptionRow
+			notified bool
+		)
+		err = rows.Scan(append(r.fields(), &notified)...)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rowsData = append(rowsData, r)
+		upcomingNotified = append(upcomingNotified, notified)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for i, r := range rowsData {
+		s, err := c.convertSubscriptionRow(ctx, r)
+		if err != nil {
+			return err
+		}
+		notified := upcomingNotified[i]
+		// the subscription stays due if more cycles were missed, they are issued by the next runs
+		for n := 0; n < maxCyclesPerRun && s.HasCycle(s.NextCycle) && !s.CycleAt(s.NextCycle).After(now); n++ {
+			event := core.SubscriptionEvent{
+				SubscriptionID: s.ID,
+				Type:           core.MissedSubscriptionEvent,
+				Cycle:          s.NextCycle,
+				CycleAt:        s.CycleAt(s.NextCycle),
+				CreatedAt:      now,
+			}
+			if event.CycleAt.Add(s.Template.LifeTime).After(now) {
+				invoice := s.NewCycleInvoice(s.NextCycle, now)
+				err = c.saveInvoice(ctx, tx, invoice)
+				if err != nil {
+					return fmt.Errorf("save invoice of subscription %v: %w", s.ID, err)
+				}
+				err = c.saveInvoiceEvents(ctx, tx, []core.InvoiceEvent{
+					core.NewInvoiceEvent(core.InvoiceCreatedEvent, invoice.ID, nil, nil, now),
+				})
+				if err != nil {
+					return err
+				}
+				event.Type = core.CreatedSubscriptionEvent
+				event.InvoiceID = &invoice.ID
+			} // else the cycle was missed while the service was not running
+			err = saveSubscriptionEvent(ctx, tx, event)
+			if err != nil {
+				return err
+			}
+			s.NextCycle++
+			notified = false
+		}
+		status := core.ActiveSubscriptionStatus
+		if !s.HasCycle(s.NextCycle) {
+			status = core.FinishedSubscriptionStatus
+		} else if next := s.CycleAt(s.NextCycle); !notified && s.NotifyBefore > 0 && next.After(now) && !next.Add(-s.NotifyBefore).After(now) {
+			err = saveSubscriptionEvent(ctx, tx, core.SubscriptionEvent{
+				SubscriptionID: s.ID,
+				Type:           core.UpcomingSubscriptionEvent,
+				Cycle:          s.NextCycle,
+				CycleAt:        s.CycleAt(s.NextCycle),
+				CreatedAt:      now,
+			})
+			if err != nil {
+				return err
+			}
+			notified = true
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE payments.subscriptions
+			SET status = $1, next_cycle = $2, next_cycle_at = $3, upcoming_notified = $4, updated_at = $5
+			WHERE id = $6`, status, s.NextCycle, s.CycleAt(s.NextCycle), notified, now, s.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func saveSubscriptionEvent(ctx context.Context, exec executor, event core.SubscriptionEvent) error {
+	_, err := exec.Exec(ctx, `
+		INSERT INTO payments.subscription_notifications (id, subscription_id, type, cycle, cycle_at, invoice_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO NOTHING`,
+		core.SubscriptionEventID(event.SubscriptionID, event.Type, event.Cycle), event.SubscriptionID, event.Type, event.Cycle, event.CycleAt, event.InvoiceID, event.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return notify(ctx, exec)
+}
+
+// GetSubscriptionNotifications returns subscription events waiting for notification in the order they happened.
+// Failed events are skipped.
+func (c *Connection) GetSubscriptionNotifications(ctx context.Context, limit int) ([]core.SubscriptionEvent, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT id, subscription_id, type, cycle, cycle_at, invoice_id, created_at
+		FROM payments.subscription_notifications
+		WHERE failed_at IS NULL
+		ORDER BY seq
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []core.SubscriptionEvent
+	for rows.Next() {
+		var e core.SubscriptionEvent
+		err = rows.Scan(&e.ID, &e.SubscriptionID, &e.Type, &e.Cycle, &e.CycleAt, &e.InvoiceID, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// FailSubscriptionNotification marks the subscription event which can not be sent, so it does not block the following events.
+// Failed events are deleted after the retention period.
+func (c *Connection) FailSubscriptionNotification(ctx context.Context, id uuid.UUID, reason string) error {
+	_, err := c.postgres.Exec(ctx, `
+		UPDATE payments.subscription_notifications
+		SET failed_at = $2, error = $3
+		WHERE id = $1`, id, time.Now(), reason)
+	return err
+}
+
+type subscriptionRow struct {
+	s                      core.Subscription
+	recipient              string
+	currencyID             uuid.UUID
+	amount                 string
+	lifeTime, notifyBefore int64
+}
+
+func (r *subscriptionRow) fields() []any {
+	return []any{&r.s.ID, &r.s.Status, &r.recipient, &r.currencyID, &r.amount, &r.lifeTime, &r.s.Template.PrivateInfo, &r.s.Template.Metadata,
+		&r.s.Period, &r.s.Interval, &r.s.StartAt, &r.s.EndAt, &r.notifyBefore, &r.s.NextCycle, &r.s.CreatedAt, &r.s.UpdatedAt}
+}
+
+func scanSubscription(row pgx.Row) (subscriptionRow, error) {
+	var r subscriptionRow
+	err := row.Scan(r.fields()...)
+	return r, err
+}
+
+func (c *Connection) getSubscription(ctx context.Context, row pgx.Row) (core.Subscription, error) {
+	r, err := scanSubscription(row)
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.Subscription{}, core.ErrNotFound
+	} else if err != nil {
+		return core.Subscription{}, err
+	}
+	return c.convertSubscriptionRow(ctx, r)
+}
+
+func (c *Connection) convertSubscriptionRow(ctx context.Context, r subscriptionRow) (core.Subscription, error) {
+	currency, err := c.getCurrencyByID(ctx, r.currencyID)
+	if err != nil {
+		return core.Subscription{}, err
+	}
+	amount, ok := new(big.Int).SetString(r.amount, 10)
+	if !ok {
+		return core.Subscription{}, fmt.Errorf("invalid subscription amount: %s", r.amount)
+	}
+	recipient, err := ton.ParseAccountID(r.recipient)
+	if err != nil {
+		return core.Subscription{}, err
+	}
+	s := r.s
+	s.Recipient = recipient
+	s.Template.Currency = *currency
+	s.Template.Amount = amount
+	s.Template.LifeTime = time.Duration(r.lifeTime) * time.Second
+	s.NotifyBefore = time.Duration(r.notifyBefore) * time.Second
+	return s, nil
+}
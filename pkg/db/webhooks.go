@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/txsociety/spice-harvester/pkg/core"
+)
+
+const webhookColumns = `id, merchant_id, url, secret, previous_secret, event_types, currencies, enabled, created_at, updated_at`
+
+func (c *Connection) CreateWebhook(ctx context.Context, w core.Webhook) error {
+	_, err := c.postgres.Exec(ctx, `
+		INSERT INTO payments.webhooks
+		(id, merchant_id, url, secret, previous_secret, event_types, currencies, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		w.ID, w.MerchantID, w.URL, w.Secret, w.PreviousSecret, nonNilStrings(w.EventTypes), nonNilStrings(w.Currencies), w.Enabled,
+		w.CreatedAt, w.UpdatedAt)
+	return err
+}
+
+func (c *Connection) GetWebhook(ctx context.Context, id uuid.UUID) (core.Webhook, error) {
+	w, err := scanWebhook(c.postgres.QueryRow(ctx, `
+		SELECT `+webhookColumns+`
+		FROM payments.webhooks
+		WHERE id = $1`, id))
+	if err != nil && errors.Is(err, pgx.ErrNoRows) {
+		return core.Webhook{}, core.ErrNotFound
+	}
+	return w, err
+}
+
+// GetWebhooks returns webhooks of all merchants
+func (c *Connection) GetWebhooks(ctx context.Context) ([]core.Webhook, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT `+webhookColumns+`
+		FROM payments.webhooks
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return collectWebhooks(rows)
+}
+
+func (c *Connection) GetMerchantWebhooks(ctx context.Context, merchantID uuid.UUID) ([]core.Webhook, error) {
+	rows, err := c.postgres.Query(ctx, `
+		SELECT `+webhookColumns+`
+		FROM payments.webhooks
+		WHERE merchant_id = $1
+		ORDER BY id`, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	return collectWebhooks(rows)
+}
+
+func collectWebhooks(rows pgx.Rows) ([]core.Webhook, error) {
+	defer rows.Close()
+	var res []core.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, w)
+	}
+	return res, rows.Err()
+}
+
+func (c *Connection) UpdateWebhook(ctx context.Context, w core.Webhook) error {
+	tag, err := c.postgres.Exec(ctx, `
+		UPDATE payments.webhooks
+		SET url = $1, secret = $2, previous_secret = $3, event_types = $4, currencies = $5, enabled = $6, updated_at = $7
+		WHERE id = $8`,
+		w.URL, w.Secret, w.PreviousSecret, nonNilStrings(w.EventTypes), nonNilStrings(w.Currencies), w.Enabled, w.UpdatedAt, w.ID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return core.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook with its deliveries
+func (c *Connection) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	tag, err := c.postgres.Exec(ctx, `
+		DELETE FROM payments.webhooks
+		WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return core.ErrNotFound
+	}
+	return nil
+}
+
+func scanWebhook(row pgx.Row) (core.Webhook, error) {
+	var w core.Webhook
+	err := row.Scan(&w.ID, &w.MerchantID, &w.URL, &w.Secret, &w.PreviousSecret, &w.EventTypes, &w.Currencies, &w.Enabled,
+		&w.CreatedAt, &w.UpdatedAt)
+	return w, err
+}
+
+// nonNilStrings prevents saving NULL to not null array columns
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
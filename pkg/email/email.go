@@ -0,0 +1,376 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"github.com/txsociety/spice-harvester/pkg/notifier"
+	htmltemplate "html/template"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	Sender        = "email"         // sender name of merchant notice deliveries
+	ReceiptSender = "email_receipt" // sender name of buyer receipt deliveries
+)
+
+const sendTimeout = 30 * time.Second
+
+// Template is a source of the email. Subject is always text/template, Body is text/template for merchant notices
+// and html/template for receipts. Both are executed with notifier.MessageData.
+type Template struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// BodyFile is a path to the body source relative to the templates file, it is used instead of Body
+	BodyFile string `json:"body_file,omitempty"`
+}
+
+// Templates of emails. Events without a merchant template are not sent to the merchant.
+// The receipt is sent to the buyer email of the invoice after the invoice is paid, an empty receipt body disables receipts.
+type Templates struct {
+	Merchant map[string]Template `json:"merchant"`
+	Receipt  Template            `json:"receipt"`
+}
+
+// DefaultTemplates are used if templates are not configured
+var DefaultTemplates = Templates{
+	Merchant: map[string]Template{
+		core.InvoicePaidEvent: {
+			Subject: `Invoice paid: {{.Amount}} {{.Currency}}{{with .ExternalReference}} (order {{.}}){{end}}`,
+			Body: `Invoice {{.InvoiceID}} is paid: {{.Amount}} {{.Currency}}
+{{- with .ExternalReference}}
+Order: {{.}}{{end}}
+{{- with .Goods}}
+Goods:{{range .}}
+  - {{.}}{{end}}{{end}}
+{{- with .BuyerEmail}}
+Buyer: {{.}}{{end}}
+Transaction: {{.Event.Invoice.TxHash}}
+`,
+		},
+		core.InvoiceExpiredEvent: {
+			Subject: `Invoice expired: {{.Amount}} {{.Currency}}{{with .ExternalReference}} (order {{.}}){{end}}`,
+			Body: `Invoice {{.InvoiceID}} has expired. Received {{.Received}} of {{.Amount}} {{.Currency}}.
+{{- with .ExternalReference}}
+Order: {{.}}{{end}}
+`,
+		},
+	},
+	Receipt: Template{
+		Subject: `Receipt{{with .MerchantName}} from {{.}}{{end}}`,
+		Body: `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>{{if .MerchantURL}}<a href="{{.MerchantURL}}">{{.MerchantName}}</a>{{else}}{{.MerchantName}}{{end}}</h2>
+<p>Thank you for your payment.</p>
+{{- with .Goods}}
+<ul>
+{{- range .}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+<table>
+<tr><td>Amount</td><td><b>{{.Amount}} {{.Currency}}</b></td></tr>
+{{- with .ExternalReference}}
+<tr><td>Order</td><td>{{.}}</td></tr>
+{{- end}}
+<tr><td>Invoice</td><td>{{.InvoiceID}}</td></tr>
+<tr><td>Date</td><td>{{.CreatedAt.UTC.Format "2006-01-02 15:04 MST"}}</td></tr>
+<tr><td>Transaction</td><td>{{.Event.Invoice.TxHash}}</td></tr>
+</table>
+</body>
+</html>
+`,
+	},
+}
+
+type textTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+type htmlTemplate struct {
+	subject *template.Template
+	body    *htmltemplate.Template
+}
+
+// Client sends emails about events via SMTP server
+type Client struct {
+	addr           string
+	host           string
+	auth           smtp.Auth
+	from           string
+	merchantEmails []string
+	merchant       map[string]textTemplate
+	receipt        *htmlTemplate // nil if receipts are disabled
+	currencies     *core.CurrencyRegistry
+}
+
+// NewClient creates email sender. Addr is host:port of SMTP server, STARTTLS is used if the server supports it.
+// Authentication is used if username is not empty. Merchant notices are not sent if merchantEmails is empty.
+func NewClient(addr, username, password, from string, merchantEmails []string, templates Templates, currencies *core.CurrencyRegistry) (*Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp address: %s", addr)
+	}
+	for _, email := range append([]string{from}, merchantEmails...) {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return nil, fmt.Errorf("invalid email %s: %w", email, err)
+		}
+	}
+	c := &Client{
+		addr:           addr,
+		host:           host,
+		from:           from,
+		merchantEmails: merchantEmails,
+		merchant:       make(map[string]textTemplate, len(templates.Merchant)),
+		currencies:     currencies,
+	}
+	if len(username) > 0 {
+		c.auth = smtp.PlainAuth("", username, password, host)
+	}
+	for eventType, t := range templates.Merchant {
+		if err := core.ValidateWebhookEventType(eventType); err != nil {
+			return nil, err
+		}
+		var parsed textTemplate
+		parsed.subject, err = template.New("subject").Parse(t.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject template of %s: %w", eventType, err)
+		}
+		parsed.body, err = template.New(eventType).Parse(t.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of %s: %w", eventType, err)
+		}
+		c.merchant[eventType] = parsed
+	}
+	if len(templates.Receipt.Body) > 0 {
+		c.receipt = &htmlTemplate{}
+		c.receipt.subject, err = template.New("subject").Parse(templates.Receipt.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject template of receipt: %w", err)
+		}
+		c.receipt.body, err = htmltemplate.New("receipt").Parse(templates.Receipt.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of receipt: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// LoadTemplates reads templates from JSON file: {"merchant": {"<event type>": {"subject": "...", "body": "..."}}, "receipt": {...}}
+func LoadTemplates(path string) (Templates, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Templates{}, err
+	}
+	var res Templates
+	err = json.Unmarshal(b, &res)
+	if err != nil {
+		return Templates{}, fmt.Errorf("invalid templates file %s: %w", path, err)
+	}
+	load := func(t *Template) error {
+		if len(t.BodyFile) == 0 {
+			return nil
+		}
+		body, err := os.ReadFile(filepath.Join(filepath.Dir(path), t.BodyFile))
+		if err != nil {
+			return err
+		}
+		t.Body = string(body)
+		return nil
+	}
+	for eventType, t := range res.Merchant {
+		if err := load(&t); err != nil {
+			return Templates{}, err
+		}
+		res.Merchant[eventType] = t
+	}
+	if err := load(&res.Receipt); err != nil {
+		return Templates{}, err
+	}
+	return res, nil
+}
+
+// Send emails the merchant notice of the event
+func (c *Client) Send(ctx context.Context, event core.EventEnvelope) error {
+	t, ok := c.merchant[event.Type]
+	if !ok || len(c.merchantEmails) == 0 {
+		return nil
+	}
+	data := notifier.NewMessageData(event, c.currencies.All())
+	subject, err := execute(t.subject, data)
+	if err != nil {
+		return fmt.Errorf("execute subject template of %s: %w", event.Type, err)
+	}
+	var body bytes.Buffer
+	err = t.body.Execute(&body, data)
+	if err != nil {
+		return fmt.Errorf("execute template of %s: %w", event.Type, err)
+	}
+	err = c.sendMail(ctx, c.merchantEmails, subject, "text/plain", body.Bytes())
+	if err != nil {
+		return fmt.Errorf("send merchant notice: %w", err)
+	}
+	return nil
+}
+
+// Receipts emails receipts to buyers of all merchants
+type Receipts struct {
+	client *Client
+}
+
+// Receipts returns the buyer receipt sender or nil if receipts are disabled
+func (c *Client) Receipts() *Receipts {
+	if c.receipt == nil {
+		return nil
+	}
+	return &Receipts{client: c}
+}
+
+// SendsToBuyer marks Receipts as notifier.BuyerSender
+func (r *Receipts) SendsToBuyer() {}
+
+// Send emails the receipt to the buyer email of the paid invoice
+func (r *Receipts) Send(ctx context.Context, event core.EventEnvelope) error {
+	data := notifier.NewMessageData(event, r.client.currencies.All())
+	if event.Type != core.InvoicePaidEvent || len(data.BuyerEmail) == 0 {
+		return nil
+	}
+	subject, err := execute(r.client.receipt.subject, data)
+	if err != nil {
+		return fmt.Errorf("execute subject template of receipt: %w", err)
+	}
+	var body bytes.Buffer
+	err = r.client.receipt.body.Execute(&body, data)
+	if err != nil {
+		return fmt.Errorf("execute template of receipt: %w", err)
+	}
+	err = r.client.sendMail(ctx, []string{data.BuyerEmail}, subject, "text/html", body.Bytes())
+	if err != nil {
+		return fmt.Errorf("send receipt: %w", err)
+	}
+	return nil
+}
+
+// Alert emails the operator alert to the merchant addresses
+func (c *Client) Alert(ctx context.Context, text string) error {
+	if len(c.merchantEmails) == 0 {
+		return nil
+	}
+	err := c.sendMail(ctx, c.merchantEmails, "Spice harvester alert", "text/plain", []byte(text))
+	if err != nil {
+		return fmt.Errorf("send alert: %w", err)
+	}
+	return nil
+}
+
+func execute(t *template.Template, data notifier.MessageData) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func (c *Client) sendMail(ctx context.Context, to []string, subject, contentType string, body []byte) error {
+	msg, err := buildMessage(c.from, to, subject, contentType, body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: c.host})
+		if err != nil {
+			return err
+		}
+	}
+	if c.auth != nil {
+		err = client.Auth(c.auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(c.from)
+	if err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		err = client.Rcpt(rcpt)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func buildMessage(from string, to []string, subject, contentType string, body []byte) ([]byte, error) {
+	var msg bytes.Buffer
+	header := [][2]string{
+		{"From", from},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", contentType + "; charset=UTF-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, h := range header {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&msg) // converts line breaks to CRLF
+	_, err := w.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,246 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type sinkMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpSink is a local SMTP server which stores all received messages
+type smtpSink struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []sinkMessage
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP sink")
+	var m sinkMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			m = sinkMessage{from: strings.Trim(line[len("MAIL FROM:"):], "<>")}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			rcpt := strings.Trim(line[len("RCPT TO:"):], "<>")
+			if strings.HasPrefix(rcpt, "rejected@") {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			m.to = append(m.to, rcpt)
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			m.data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, m)
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpSink) received() []sinkMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sinkMessage(nil), s.messages...)
+}
+
+func parseMessage(t *testing.T, data string) (subject, contentType, body string) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subject, msg.Header.Get("Content-Type"), string(b)
+}
+
+func TestSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	currencies := core.NewCurrencyRegistry(map[string]core.ExtendedCurrency{core.DefaultTonTicker: {Currency: core.TonCurrency()}})
+	client, err := NewClient(sink.listener.Addr().String(), "", "", "payments@shop.com", []string{"owner@shop.com"}, DefaultTemplates, currencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice := core.PrivateInvoicePrintable{
+		PublicInvoicePrintable: core.PublicInvoicePrintable{
+			ID:       "03cfc582-b1c3-410a-a9a7-1f3afe326b3b",
+			Status:   string(core.PaidInvoiceStatus),
+			Amount:   "1500000000",
+			Received: "1500000000",
+			Currency: core.DefaultTonTicker,
+			TxHash:   "a1b2",
+		},
+		Metadata: map[string]json.RawMessage{
+			"merchant_name": json.RawMessage(`"Coffee & Tea"`),
+			"merchant_url":  json.RawMessage(`"https://shop.com"`),
+			"goods":         json.RawMessage(`[{"name":"Latte <large>"},{"name":"Croissant"}]`),
+		},
+		ExternalReference: "order-42",
+		BuyerEmail:        "buyer@example.com",
+	}
+	receipts := client.Receipts()
+	send := func(event core.EventEnvelope) error {
+		if err := client.Send(context.Background(), event); err != nil {
+			return err
+		}
+		return receipts.Send(context.Background(), event)
+	}
+	err = send(core.EventEnvelope{ID: "paid", Type: core.InvoicePaidEvent, Invoice: &invoice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := sink.received()
+	if len(messages) != 2 {
+		t.Fatalf("expected merchant notice and receipt, got %d messages", len(messages))
+	}
+
+	notice := messages[0]
+	if notice.from != "payments@shop.com" || len(notice.to) != 1 || notice.to[0] != "owner@shop.com" {
+		t.Fatalf("invalid notice envelope: %v -> %v", notice.from, notice.to)
+	}
+	subject, contentType, body := parseMessage(t, notice.data)
+	if subject != "Invoice paid: 1.5 TON (order order-42)" {
+		t.Errorf("invalid notice subject: %q", subject)
+	}
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("invalid notice content type: %s", contentType)
+	}
+	for _, s := range []string{"1.5 TON", "order-42", "- Latte <large>", "buyer@example.com", "a1b2"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("notice must contain %q:\n%s", s, body)
+		}
+	}
+
+	receipt := messages[1]
+	if len(receipt.to) != 1 || receipt.to[0] != "buyer@example.com" {
+		t.Fatalf("invalid receipt recipients: %v", receipt.to)
+	}
+	subject, contentType, body = parseMessage(t, receipt.data)
+	if subject != "Receipt from Coffee & Tea" {
+		t.Errorf("invalid receipt subject: %q", subject)
+	}
+	if !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("invalid receipt content type: %s", contentType)
+	}
+	for _, s := range []string{`<a href="https://shop.com">Coffee &amp; Tea</a>`, "<li>Latte &lt;large&gt;</li>", "<li>Croissant</li>", "1.5 TON", "order-42"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("receipt must contain %q:\n%s", s, body)
+		}
+	}
+
+	// no receipt for events other than invoice.paid and for invoices without buyer email
+	invoice.Status = string(core.ExpiredInvoiceStatus)
+	err = send(core.EventEnvelope{ID: "expired", Type: core.InvoiceExpiredEvent, Invoice: &invoice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice.BuyerEmail = ""
+	err = send(core.EventEnvelope{ID: "paid2", Type: core.InvoicePaidEvent, Invoice: &invoice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = send(core.EventEnvelope{ID: "created", Type: core.InvoiceCreatedEvent, Invoice: &invoice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages = sink.received()
+	if len(messages) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(messages))
+	}
+	if subject, _, _ := parseMessage(t, messages[2].data); !strings.HasPrefix(subject, "Invoice expired") {
+		t.Errorf("invalid expired notice subject: %q", subject)
+	}
+	if messages[3].to[0] != "owner@shop.com" {
+		t.Errorf("receipt must not be sent without buyer email")
+	}
+
+	invoice.BuyerEmail = "rejected@example.com"
+	err = receipts.Send(context.Background(), core.EventEnvelope{ID: "paid3", Type: core.InvoicePaidEvent, Invoice: &invoice})
+	if err == nil || !strings.Contains(err.Error(), "mailbox unavailable") {
+		t.Errorf("expected rejected receipt error, got %v", err)
+	}
+
+	disabled, err := NewClient(sink.listener.Addr().String(), "", "", "payments@shop.com", nil,
+		Templates{Merchant: DefaultTemplates.Merchant}, currencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disabled.Receipts() != nil {
+		t.Error("receipts must be disabled without the receipt template")
+	}
+
+	_, err = NewClient(sink.listener.Addr().String(), "", "", "payments@shop.com", nil,
+		Templates{Merchant: map[string]Template{"invoice.unknown": {Subject: "x", Body: "x"}}}, currencies)
+	if err == nil {
+		t.Error("unknown event type must be rejected")
+	}
+}
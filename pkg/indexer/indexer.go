@@ -7,7 +7,6 @@ import (
 	"github.com/tonkeeper/tongo/abi"
 	"github.com/tonkeeper/tongo/ton"
 	"github.com/txsociety/spice-harvester/pkg/core"
-	"log/slog"
 	"math/big"
 	"time"
 )
@@ -15,15 +14,17 @@ import (
 type indexerWorker struct {
 	account     core.Account
 	storage     storage
+	confirmer   confirmer // optional
 	lastIndexed uint64
 }
 
-func newIndexerWorker(storage storage, a core.Account) (*indexerWorker, error) {
+func newIndexerWorker(ctx context.Context, storage storage, confirmer confirmer, a core.Account) (*indexerWorker, error) {
 	t := &indexerWorker{
-		storage: storage,
-		account: a,
+		storage:   storage,
+		confirmer: confirmer,
+		account:   a,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	lastIndexed, err := t.storage.LastProcessedLT(ctx, t.account.AccountID)
 	if err != nil {
@@ -33,34 +34,53 @@ func newIndexerWorker(storage storage, a core.Account) (*indexerWorker, error) {
 	return t, nil
 }
 
-func (i *indexerWorker) Run() {
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		tx, err := i.storage.GetTransactionByParentLt(ctx, i.account.AccountID, i.lastIndexed)
-		if err != nil {
-			if !errors.Is(err, core.ErrNotFound) {
-				slog.Error("error getting tx", " error", err)
+// Run processes loaded transactions one by one in the order of lt. Returns on database errors.
+func (i *indexerWorker) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		err := i.processNext(ctx)
+		if errors.Is(err, core.ErrNotFound) {
+			// the next transaction is not loaded yet
+			if err := sleep(ctx, 5*time.Second); err != nil {
+				return err
 			}
-			time.Sleep(5 * time.Second)
-			cancel()
 			continue
 		}
-		var payments []core.Payment
-		if i.account.Info.Jetton != nil {
-			payments, err = extractJettonPayments(tx, i.account)
-		} else {
-			payments, err = extractNativePayments(tx, i.account)
-		}
-		err = i.storage.SavePayments(ctx, i.account.AccountID, tx.Lt, payments, err)
 		if err != nil {
-			slog.Error("saving tx", "error", err)
-			time.Sleep(5 * time.Second)
-			cancel()
-			continue
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (i *indexerWorker) processNext(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	tx, err := i.storage.GetTransactionByParentLt(ctx, i.account.AccountID, i.lastIndexed)
+	if errors.Is(err, core.ErrNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("get transaction: %w", err)
+	}
+	var payments []core.Payment
+	if i.account.Info.Jetton != nil {
+		payments, err = extractJettonPayments(tx, i.account)
+	} else {
+		payments, err = extractNativePayments(tx, i.account)
+	}
+	if err == nil && len(payments) > 0 && i.confirmer != nil {
+		// payments are not saved until the transaction is confirmed, the worker retries it after restart
+		cErr := i.confirmer.ConfirmTransaction(ctx, i.account.AccountID, tx.Lt, tx.Hash)
+		if cErr != nil {
+			return fmt.Errorf("confirm tx %d: %w", tx.Lt, cErr)
 		}
-		i.lastIndexed = tx.Lt
-		cancel()
 	}
+	err = i.storage.SavePayments(ctx, i.account.AccountID, tx.Lt, payments, err)
+	if err != nil {
+		return fmt.Errorf("save payments of tx %d: %w", tx.Lt, err)
+	}
+	i.lastIndexed = tx.Lt
+	return nil
 }
 
 func extractNativePayments(tx core.Transaction, account core.Account) ([]core.Payment, error) {
@@ -99,16 +119,19 @@ func extractNativePayments(tx core.Transaction, account core.Account) ([]core.Pa
 	}
 	id, err := core.ParseInvoiceID(idS)
 	if err != nil {
-		return nil, nil
+		id = core.InvoiceID{} // unmatched payment, keep it with the original comment
 	}
 
 	var res []core.Payment
 	tons := tx.InMessage.Value
 	res = append(res, core.Payment{
 		InvoiceID: id,
+		Comment:   idS,
 		PaidBy:    *tx.InMessage.Source,
 		Amount:    big.NewInt(int64(tons)),
 		TxHash:    tx.Hash,
+		Lt:        tx.Lt,
+		CreatedAt: time.Unix(int64(tx.Utime), 0),
 		Currency:  core.TonCurrency(),
 		Recipient: account.AccountID,
 	})
@@ -119,10 +142,13 @@ func extractNativePayments(tx core.Transaction, account core.Account) ([]core.Pa
 		}
 		res = append(res, core.Payment{
 			InvoiceID: id,
+			Comment:   idS,
 			Recipient: account.AccountID,
 			PaidBy:    *tx.InMessage.Source,
 			Amount:    &amount,
 			TxHash:    tx.Hash,
+			Lt:        tx.Lt,
+			CreatedAt: time.Unix(int64(tx.Utime), 0),
 			Currency:  core.ExtraCurrency(extraID),
 		})
 	}
@@ -189,7 +215,7 @@ func extractJettonPayments(tx core.Transaction, account core.Account) ([]core.Pa
 		}
 		id, err := core.ParseInvoiceID(idS)
 		if err != nil {
-			return nil, nil
+			id = core.InvoiceID{} // unmatched payment, keep it with the original comment
 		}
 
 		amountS, err := valueFromBody[string](body, "Amount")
@@ -219,11 +245,14 @@ func extractJettonPayments(tx core.Transaction, account core.Account) ([]core.Pa
 		return []core.Payment{
 			{
 				InvoiceID: id,
+				Comment:   idS,
 				Amount:    amount,
 				Currency:  core.JettonCurrency(*account.Info.Jetton),
 				PaidBy:    sender,
 				Recipient: account.Info.Recipient,
 				TxHash:    tx.Hash,
+				Lt:        tx.Lt,
+				CreatedAt: time.Unix(int64(tx.Utime), 0),
 			},
 		}, nil
 	}
@@ -14,6 +14,7 @@ type blockchain interface {
 
 type storage interface {
 	MarkExpired(ctx context.Context) error
+	ProcessSubscriptions(ctx context.Context) error
 	SavePayments(ctx context.Context, account ton.AccountID, txLt uint64, payments []core.Payment, err error) error
 	UpdateAccount(ctx context.Context, account ton.AccountID, lastTX core.TxID, mcSeqno uint32) error
 	DeleteExpiredKeys(ctx context.Context) error
@@ -22,3 +23,7 @@ type storage interface {
 	LastProcessedLT(ctx context.Context, a ton.AccountID) (uint64, error)
 	GetTransactionByParentLt(ctx context.Context, a ton.AccountID, lt uint64) (core.Transaction, error)
 }
+
+type confirmer interface {
+	ConfirmTransaction(ctx context.Context, account ton.AccountID, lt uint64, hash ton.Bits256) error
+}
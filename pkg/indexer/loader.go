@@ -26,32 +26,32 @@ func newLoaderWorker(a core.Account, blockchain blockchain, storage storage) *lo
 	return &w
 }
 
-func (w *loaderWorker) Run() {
-	gaps, lastLt, err := w.storage.GetGaps(context.Background(), w.account)
+// Run loads missing history of the account and then follows new transactions.
+// Returns on database errors and on failures to load the history.
+func (w *loaderWorker) Run(ctx context.Context) error {
+	gaps, lastLt, err := w.storage.GetGaps(ctx, w.account)
 	if err != nil {
-		time.Sleep(time.Minute) // maybe database is unavailable
-		gaps, lastLt, err = w.storage.GetGaps(context.Background(), w.account)
-		if err != nil {
-			panic(err) //it's mean some problem in database. we can't work without database
-		}
+		return fmt.Errorf("get gaps: %w", err)
 	}
 	w.lastLt = lastLt
 	for _, gap := range gaps {
-		err := w.syncHistoryGap(gap.StartHash, gap.StartLt, gap.EndLt)
+		err := w.syncHistoryGap(ctx, gap.StartHash, gap.StartLt, gap.EndLt)
 		if err != nil {
-			panic(err) //it's mean some problem in database. we can't work without database
+			return fmt.Errorf("sync history gap: %w", err)
 		}
 	}
 	for {
-		err := w.refreshAccount()
+		err := w.refreshAccount(ctx)
 		if err != nil {
-			slog.Error("refresh account", "error", err.Error())
+			return err
+		}
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
 		}
-		time.Sleep(time.Second * 5)
 	}
 }
 
-func (w *loaderWorker) syncHistoryGap(startHash ton.Bits256, startLt, endLt uint64) error {
+func (w *loaderWorker) syncHistoryGap(ctx context.Context, startHash ton.Bits256, startLt, endLt uint64) error {
 	if endLt < w.maxDepthLt { // sync only up to maxDepthLt TODO: optimize
 		endLt = w.maxDepthLt
 	}
@@ -60,7 +60,7 @@ func (w *loaderWorker) syncHistoryGap(startHash ton.Bits256, startLt, endLt uint
 		if startLt == endLt {
 			return nil
 		}
-		nextHash, nextLt, err := w.syncGapIteration(startHash, startLt, endLt)
+		nextHash, nextLt, err := w.syncGapIteration(ctx, startHash, startLt, endLt)
 		if err != nil {
 			return err
 		}
@@ -74,18 +74,20 @@ func (w *loaderWorker) syncHistoryGap(startHash ton.Bits256, startLt, endLt uint
 	return nil
 }
 
-func (w *loaderWorker) syncGapIteration(startHash ton.Bits256, startLt, endLt uint64) (nextHash ton.Bits256, nextLt uint64, err error) {
+func (w *loaderWorker) syncGapIteration(ctx context.Context, startHash ton.Bits256, startLt, endLt uint64) (nextHash ton.Bits256, nextLt uint64, err error) {
 	var txs []core.Transaction
 	for i := range 200 {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		txs, err = w.blockchain.GetTransactions(ctx, w.account, startLt, endLt, startHash)
+		ctx1, cancel := context.WithTimeout(ctx, 10*time.Second)
+		txs, err = w.blockchain.GetTransactions(ctx1, w.account, startLt, endLt, startHash)
 		cancel()
 		if err == nil && len(txs) > 0 {
 			break
 		}
 		slog.Error("get transactions", "error", err)
 		slog.Info("retry", "sleep seconds", i)
-		time.Sleep(time.Second * time.Duration(i))
+		if err := sleep(ctx, time.Second*time.Duration(i)); err != nil {
+			return ton.Bits256{}, 0, err
+		}
 	}
 	if err != nil {
 		return ton.Bits256{}, 0, fmt.Errorf("get transactions: %w", err)
@@ -93,9 +95,9 @@ func (w *loaderWorker) syncGapIteration(startHash ton.Bits256, startLt, endLt ui
 	if len(txs) == 0 {
 		return ton.Bits256{}, 0, fmt.Errorf("no transactions for %v %v %v %v", w.account.String(), startLt, endLt, time.Now())
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx1, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	err = w.storage.SaveTransactions(ctx, w.account, txs)
+	err = w.storage.SaveTransactions(ctx1, w.account, txs)
 	if err != nil {
 		return ton.Bits256{}, 0, fmt.Errorf("save transactions: %w", err)
 	}
@@ -104,28 +106,35 @@ func (w *loaderWorker) syncGapIteration(startHash ton.Bits256, startLt, endLt ui
 	return nextHash, nextLt, nil
 }
 
-func (w *loaderWorker) refreshAccount() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// refreshAccount loads new transactions of the account. Errors of getting the account state are only logged,
+// the next refresh can get it from another node. Returns errors which require restart of the worker.
+func (w *loaderWorker) refreshAccount(ctx context.Context) error {
+	ctx1, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	account, blockSeqno, err := w.blockchain.GetAccountState(ctx, w.account)
+	account, blockSeqno, err := w.blockchain.GetAccountState(ctx1, w.account)
 	if err != nil {
-		return fmt.Errorf("get account: %w", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Error("refresh account", "error", fmt.Errorf("get account: %w", err).Error())
+		return nil
 	}
 	if account.LastTransLt < w.lastLt {
-		return fmt.Errorf("account has older state than previous: %v %v (probably unsinc between nodes)", account.LastTransLt, w.lastLt)
+		slog.Error("refresh account", "error", fmt.Sprintf("account has older state than previous: %v %v (probably unsinc between nodes)", account.LastTransLt, w.lastLt))
+		return nil
 	}
 	txID := core.TxID{
 		Lt:   account.LastTransLt,
 		Hash: ton.Bits256(account.LastTransHash),
 	}
-	err = w.storage.UpdateAccount(ctx, w.account, txID, blockSeqno)
+	err = w.storage.UpdateAccount(ctx1, w.account, txID, blockSeqno)
 	if err != nil {
 		return fmt.Errorf("save account: %w", err)
 	}
-	err = w.syncHistoryGap(ton.Bits256(account.LastTransHash), account.LastTransLt, w.lastLt)
+	err = w.syncHistoryGap(ctx, ton.Bits256(account.LastTransHash), account.LastTransLt, w.lastLt)
 	if err != nil {
-		panic(err) //yes, panic. better to fall down
+		return fmt.Errorf("sync new transactions: %w", err)
 	}
 	return nil
 }
@@ -2,6 +2,7 @@ package indexer
 
 import (
 	"context"
+	"github.com/tonkeeper/tongo/ton"
 	"github.com/txsociety/spice-harvester/pkg/core"
 	"log/slog"
 	"sync"
@@ -12,6 +13,8 @@ type Indexer struct {
 	blockchain blockchain
 	storage    storage
 	accounts   chan core.Account
+	workers    *supervisor
+	confirmer  confirmer
 }
 
 func New(blockchain blockchain, storage storage) (*Indexer, error) {
@@ -20,12 +23,19 @@ func New(blockchain blockchain, storage storage) (*Indexer, error) {
 		blockchain: blockchain,
 		storage:    storage,
 		accounts:   accountsChan,
+		workers:    newSupervisor(),
 	}
 	return processor, nil
 }
 
+// SetConfirmer enables the confirmation of payment transactions before they are saved. Must be called before Run.
+func (i *Indexer) SetConfirmer(c confirmer) {
+	i.confirmer = c
+}
+
 func (i *Indexer) Run(ctx context.Context, wg *sync.WaitGroup) chan core.Account {
 	go i.runExpirationProcessor(ctx, wg)
+	go i.runSubscriptionProcessor(ctx, wg)
 	go i.runIndexer(ctx, wg)
 	return i.accounts
 }
@@ -57,6 +67,28 @@ func (i *Indexer) runExpirationProcessor(ctx context.Context, wg *sync.WaitGroup
 	}
 }
 
+// runSubscriptionProcessor issues invoices of subscription cycles
+func (i *Indexer) runSubscriptionProcessor(ctx context.Context, wg *sync.WaitGroup) {
+	slog.Info("subscription processor started")
+	wg.Add(1)
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("subscription processor stopped")
+			return
+		case <-time.After(5 * time.Second):
+			ctx1, cancel := context.WithTimeout(ctx, 10*time.Second)
+			err := i.storage.ProcessSubscriptions(ctx1)
+			cancel()
+			if err != nil {
+				slog.Error("failed to process subscriptions", "err", err)
+				continue
+			}
+		}
+	}
+}
+
 func (i *Indexer) runIndexer(ctx context.Context, wg *sync.WaitGroup) {
 	slog.Info("indexer started")
 	wg.Add(1)
@@ -64,30 +96,42 @@ func (i *Indexer) runIndexer(ctx context.Context, wg *sync.WaitGroup) {
 	for {
 		select {
 		case <-ctx.Done():
-			// TODO: stop all workers
+			i.workers.wait()
 			slog.Info("indexer stopped")
 			return
 		case acc, ok := <-i.accounts:
 			if !ok {
 				slog.Error("account channel closed")
+				i.workers.wait()
 				return
 			}
-			err := i.trackAccount(acc)
-			if err != nil {
-				slog.Error("failed to start track account", "err", err, "address", acc.AccountID.ToRaw())
-			}
+			i.trackAccount(ctx, acc)
 		}
 	}
 }
 
-func (i *Indexer) trackAccount(account core.Account) error {
-	// TODO: map workers
-	loader := newLoaderWorker(account, i.blockchain, i.storage)
-	idx, err := newIndexerWorker(i.storage, account)
-	if err != nil {
-		return err
+// trackAccount starts supervised loader and indexer workers of the account if it is not tracked yet
+func (i *Indexer) trackAccount(ctx context.Context, account core.Account) {
+	started := i.workers.start(ctx, account.AccountID, map[string]func(ctx context.Context) (worker, error){
+		core.WorkerKindLoader: func(ctx context.Context) (worker, error) {
+			return newLoaderWorker(account, i.blockchain, i.storage), nil
+		},
+		core.WorkerKindIndexer: func(ctx context.Context) (worker, error) {
+			return newIndexerWorker(ctx, i.storage, i.confirmer, account)
+		},
+	})
+	if !started {
+		slog.Warn("account is already tracked", "address", account.AccountID.ToRaw())
 	}
-	go loader.Run()
-	go idx.Run()
-	return nil
+}
+
+// UntrackAccount stops loader and indexer workers of the account and waits until they are stopped.
+// Returns false if the account is not tracked.
+func (i *Indexer) UntrackAccount(account ton.AccountID) bool {
+	return i.workers.stop(account)
+}
+
+// WorkerStates returns states of loader and indexer workers of all tracked accounts
+func (i *Indexer) WorkerStates() []core.WorkerState {
+	return i.workers.states()
 }
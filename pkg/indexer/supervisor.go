@@ -0,0 +1,192 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// restart delays grow exponentially from baseRestartDelay up to maxRestartDelay.
+	// The delay is reset if the worker has been running for stableRunTime before the failure.
+	baseRestartDelay = 5 * time.Second
+	maxRestartDelay  = 5 * time.Minute
+	stableRunTime    = 10 * time.Minute
+)
+
+type worker interface {
+	// Run works until the context is cancelled or an error which requires restart of the worker
+	Run(ctx context.Context) error
+}
+
+// supervisor runs loader and indexer workers of every tracked account, restarts failed workers and keeps their states
+type supervisor struct {
+	mu       sync.Mutex
+	accounts map[ton.AccountID]*accountWorkers
+	wg       sync.WaitGroup
+}
+
+type accountWorkers struct {
+	cancel context.CancelFunc
+	states map[string]*core.WorkerState // by worker kind
+	wg     sync.WaitGroup
+}
+
+func newSupervisor() *supervisor {
+	return &supervisor{accounts: make(map[ton.AccountID]*accountWorkers)}
+}
+
+// start runs workers of the account. Workers are created by the factories on every (re)start.
+// Returns false if the account is already tracked.
+func (s *supervisor) start(ctx context.Context, account ton.AccountID, factories map[string]func(ctx context.Context) (worker, error)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[account]; ok {
+		return false
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	workers := &accountWorkers{cancel: cancel, states: make(map[string]*core.WorkerState, len(factories))}
+	s.accounts[account] = workers
+	now := time.Now()
+	for kind, factory := range factories {
+		workers.states[kind] = &core.WorkerState{
+			Account:   account,
+			Kind:      kind,
+			Status:    core.RunningWorkerStatus,
+			StartedAt: now,
+			UpdatedAt: now,
+		}
+		s.wg.Add(1)
+		workers.wg.Add(1)
+		go s.supervise(ctx, workers, workers.states[kind], factory)
+	}
+	return true
+}
+
+// stop cancels workers of the account, removes them and waits until they are stopped.
+// Returns false if the account is not tracked.
+func (s *supervisor) stop(account ton.AccountID) bool {
+	s.mu.Lock()
+	workers, ok := s.accounts[account]
+	if !ok {
+		s.mu.Unlock()
+		return false
+	}
+	workers.cancel()
+	delete(s.accounts, account)
+	s.mu.Unlock()
+	workers.wg.Wait()
+	return true
+}
+
+// wait blocks until all workers are stopped
+func (s *supervisor) wait() {
+	s.wg.Wait()
+}
+
+// states returns states of all workers ordered by account and kind
+func (s *supervisor) states() []core.WorkerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []core.WorkerState
+	for _, workers := range s.accounts {
+		for _, state := range workers.states {
+			res = append(res, *state)
+		}
+	}
+	slices.SortFunc(res, func(a, b core.WorkerState) int {
+		if c := strings.Compare(a.Account.ToRaw(), b.Account.ToRaw()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Kind, b.Kind)
+	})
+	return res
+}
+
+func (s *supervisor) supervise(ctx context.Context, workers *accountWorkers, state *core.WorkerState, factory func(ctx context.Context) (worker, error)) {
+	defer s.wg.Done()
+	defer workers.wg.Done()
+	attempts := 0
+	for {
+		started := time.Now()
+		s.setState(state, func() {
+			state.Status = core.RunningWorkerStatus
+			state.StartedAt = started
+		})
+		err := s.run(ctx, factory)
+		if ctx.Err() != nil {
+			s.setState(state, func() { state.Status = core.StoppedWorkerStatus })
+			return
+		}
+		if err == nil {
+			err = errors.New("worker stopped unexpectedly")
+		}
+		if time.Since(started) >= stableRunTime {
+			attempts = 0
+		}
+		attempts++
+		delay := restartDelay(attempts)
+		msg := err.Error()
+		s.setState(state, func() {
+			state.Status = core.RestartingWorkerStatus
+			state.Restarts++
+			state.LastError = &msg
+		})
+		slog.Error("worker failed", "kind", state.Kind, "account", state.Account.ToRaw(), "error", msg, "restart in", delay)
+		select {
+		case <-ctx.Done():
+			s.setState(state, func() { state.Status = core.StoppedWorkerStatus })
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// run creates and runs the worker. Panics are turned into errors, so the worker is restarted instead of the process.
+func (s *supervisor) run(ctx context.Context, factory func(ctx context.Context) (worker, error)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	w, err := factory(ctx)
+	if err != nil {
+		return fmt.Errorf("create worker: %w", err)
+	}
+	return w.Run(ctx)
+}
+
+func (s *supervisor) setState(state *core.WorkerState, update func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update()
+	state.UpdatedAt = time.Now()
+}
+
+// restartDelay returns delay before the restart after the number of consecutive failures
+func restartDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		return baseRestartDelay
+	}
+	if attempts > 20 { // prevents overflow
+		return maxRestartDelay
+	}
+	return min(baseRestartDelay<<(attempts-1), maxRestartDelay)
+}
+
+// sleep waits for the duration or until the context is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
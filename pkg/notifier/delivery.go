@@ -0,0 +1,226 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// retry delays grow exponentially from baseRetryDelay up to maxRetryDelay,
+	// so a delivery becomes dead in about a day and a half
+	baseRetryDelay      = 30 * time.Second
+	maxRetryDelay       = 6 * time.Hour
+	maxDeliveryAttempts = 15
+)
+
+// retryDelay returns delay before the next attempt after the number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		return baseRetryDelay
+	}
+	if attempts > 20 { // prevents overflow
+		return maxRetryDelay
+	}
+	return min(baseRetryDelay<<(attempts-1), maxRetryDelay)
+}
+
+// deliveryKey identifies the invoice and the destination, deliveries with the same key are sent one by one
+type deliveryKey struct {
+	invoiceID core.InvoiceID
+	sender    string
+	webhookID uuid.UUID
+}
+
+func newDeliveryKey(d core.Delivery) (deliveryKey, bool) {
+	if d.InvoiceID == nil {
+		return deliveryKey{}, false // not ordered
+	}
+	key := deliveryKey{invoiceID: *d.InvoiceID, sender: d.Sender}
+	if d.WebhookID != nil {
+		key.webhookID = *d.WebhookID
+	}
+	return key, true
+}
+
+type deliveryJob struct {
+	delivery core.Delivery
+	webhooks map[uuid.UUID]core.Webhook
+}
+
+// runDeliveryWorker sends due deliveries by the pool of workers. New due deliveries are taken as soon as a worker
+// is free, so a slow destination occupies only its own worker. A failed delivery is rescheduled without blocking
+// the others, except the later deliveries of the same invoice to the same destination which wait for it.
+func (n *Notifier) runDeliveryWorker(ctx context.Context, wg *sync.WaitGroup) {
+	slog.Info("delivery worker started")
+	wg.Add(1)
+	defer wg.Done()
+	concurrency := max(n.delivery.Concurrency, 1)
+	queue := make(chan deliveryJob)
+	done := make(chan core.Delivery, concurrency) // never blocks, at most concurrency deliveries are in flight
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range queue {
+				n.attemptDelivery(ctx, job.delivery, job.webhooks)
+				done <- job.delivery
+			}
+		}()
+	}
+	// due deliveries stay pending in the database until their attempt is saved, so the ones in flight are skipped
+	inFlight := make(map[uuid.UUID]struct{}, concurrency)
+	inFlightKeys := make(map[deliveryKey]struct{}, concurrency)
+	fetch, more := true, false
+	for {
+		if fetch && len(inFlight) < concurrency {
+			more = false
+			limit := max(n.delivery.BatchSize, 1) + len(inFlight)
+			deliveries, webhooks, err := n.getDueDeliveries(ctx, limit)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("get due deliveries", "error", err.Error())
+				sleep(ctx, 3*time.Second)
+				continue
+			}
+			for _, d := range deliveries {
+				if _, ok := inFlight[d.ID]; ok {
+					continue
+				}
+				key, ordered := newDeliveryKey(d)
+				if _, ok := inFlightKeys[key]; ordered && ok {
+					continue
+				}
+				if len(inFlight) == concurrency {
+					more = true
+					break
+				}
+				inFlight[d.ID] = struct{}{}
+				if ordered {
+					inFlightKeys[key] = struct{}{}
+				}
+				queue <- deliveryJob{delivery: d, webhooks: webhooks}
+			}
+			more = more || len(deliveries) == limit
+		}
+		select {
+		case <-ctx.Done():
+			close(queue)
+			workers.Wait()
+			slog.Info("delivery worker stopped")
+			return
+		case d := <-done:
+			delete(inFlight, d.ID)
+			if key, ordered := newDeliveryKey(d); ordered {
+				delete(inFlightKeys, key)
+			}
+			// the next delivery of the same invoice and destination can be due now
+			fetch = more || d.InvoiceID != nil
+		case <-n.deliveryWake:
+			fetch = true
+		case <-time.After(2 * time.Second): // retries become due over time
+			fetch = true
+		}
+	}
+}
+
+// getDueDeliveries returns due deliveries and webhooks for them
+func (n *Notifier) getDueDeliveries(ctx context.Context, limit int) ([]core.Delivery, map[uuid.UUID]core.Webhook, error) {
+	deliveries, err := n.storage.GetDueDeliveries(ctx, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(deliveries) == 0 || n.webhooks == nil {
+		return deliveries, nil, nil
+	}
+	webhooks, err := n.getWebhooks(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get webhooks: %w", err)
+	}
+	return deliveries, webhooks, nil
+}
+
+func (n *Notifier) getWebhooks(ctx context.Context) (map[uuid.UUID]core.Webhook, error) {
+	webhooks, err := n.storage.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[uuid.UUID]core.Webhook, len(webhooks))
+	for _, w := range webhooks {
+		res[w.ID] = w
+	}
+	return res, nil
+}
+
+// attemptDelivery makes one attempt and saves it with the new delivery state
+func (n *Notifier) attemptDelivery(ctx context.Context, d core.Delivery, webhooks map[uuid.UUID]core.Webhook) {
+	start := time.Now()
+	err := n.deliver(ctx, d, webhooks)
+	if err != nil && ctx.Err() != nil {
+		return // interrupted by shutdown, the delivery stays pending
+	}
+	now := time.Now()
+	id, idErr := uuid.NewV7()
+	if idErr != nil {
+		slog.Error("new delivery attempt id", "error", idErr.Error())
+		return
+	}
+	attempt := core.DeliveryAttempt{
+		ID:          id,
+		DeliveryID:  d.ID,
+		AttemptedAt: start,
+		Duration:    now.Sub(start),
+	}
+	d.Attempts++
+	d.UpdatedAt = now
+	if err == nil {
+		d.Status = core.DeliveredDeliveryStatus
+		d.DeliveredAt = &now
+		d.LastError = nil
+	} else {
+		msg := err.Error()
+		attempt.Error = &msg
+		d.LastError = &msg
+		if d.Attempts >= maxDeliveryAttempts {
+			d.Status = core.DeadDeliveryStatus
+			slog.Error("delivery is dead", "id", d.ID, "sender", d.Sender, "event", d.EventType, "error", msg)
+		} else {
+			d.NextAttemptAt = now.Add(retryDelay(d.Attempts))
+			slog.Warn("delivery failed", "id", d.ID, "sender", d.Sender, "event", d.EventType,
+				"attempts", d.Attempts, "error", msg)
+		}
+	}
+	err = n.storage.SaveDeliveryAttempt(ctx, d, attempt)
+	if err != nil {
+		slog.Error("save delivery attempt", "id", d.ID, "error", err.Error())
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, d core.Delivery, webhooks map[uuid.UUID]core.Webhook) error {
+	if d.Sender == core.WebhookSender {
+		if n.webhooks == nil || d.WebhookID == nil {
+			return errors.New("webhooks are not supported")
+		}
+		webhook, ok := webhooks[*d.WebhookID]
+		if !ok {
+			return fmt.Errorf("webhook %v not found", *d.WebhookID)
+		}
+		return n.webhooks.Deliver(ctx, webhook, d.Payload)
+	}
+	s, ok := n.senders[d.Sender]
+	if !ok {
+		return fmt.Errorf("sender %s is not configured", d.Sender)
+	}
+	var event core.EventEnvelope
+	err := json.Unmarshal(d.Payload, &event)
+	if err != nil {
+		return err
+	}
+	return s.Send(ctx, event)
+}
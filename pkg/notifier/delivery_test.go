@@ -0,0 +1,164 @@
+package notifier
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deliveryStorage keeps deliveries in memory and returns due ones like the database does
+type deliveryStorage struct {
+	storage
+	mu         sync.Mutex
+	deliveries []core.Delivery
+}
+
+func (s *deliveryStorage) GetDueDeliveries(ctx context.Context, limit int) ([]core.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []core.Delivery
+	seen := make(map[deliveryKey]struct{})
+	for _, d := range s.deliveries {
+		if d.Status != core.PendingDeliveryStatus {
+			continue
+		}
+		key, ordered := newDeliveryKey(d)
+		if _, ok := seen[key]; ordered && ok {
+			continue // waits for the previous delivery of the invoice
+		}
+		seen[key] = struct{}{}
+		if d.NextAttemptAt.After(time.Now()) || len(res) == limit {
+			continue
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+func (s *deliveryStorage) SaveDeliveryAttempt(ctx context.Context, d core.Delivery, attempt core.DeliveryAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == d.ID {
+			s.deliveries[i] = d
+		}
+	}
+	return nil
+}
+
+type recordingSender struct {
+	mu     sync.Mutex
+	events []string
+	delay  time.Duration
+}
+
+func (s *recordingSender) Send(ctx context.Context, event core.EventEnvelope) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.delay):
+	}
+	s.mu.Lock()
+	s.events = append(s.events, event.ID)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *recordingSender) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.events)
+}
+
+func TestDeliveryWorkerSlowDestination(t *testing.T) {
+	slow := &recordingSender{delay: 10 * time.Second}
+	fast := &recordingSender{}
+	invoiceID := core.NewInvoiceID()
+	store := &deliveryStorage{}
+	newDelivery := func(sender, eventID string, invoice *core.InvoiceID) core.Delivery {
+		return core.Delivery{
+			ID:        uuid.New(),
+			Sender:    sender,
+			InvoiceID: invoice,
+			Payload:   []byte(`{"id":"` + eventID + `"}`),
+			Status:    core.PendingDeliveryStatus,
+		}
+	}
+	store.deliveries = []core.Delivery{
+		newDelivery("slow", "slow", nil),
+		newDelivery("fast", "created", &invoiceID),
+		newDelivery("fast", "paid", &invoiceID),
+	}
+	for i := 0; i < 5; i++ {
+		other := core.NewInvoiceID()
+		store.deliveries = append(store.deliveries, newDelivery("fast", "other", &other))
+	}
+	n := New(map[string]Sender{"slow": slow, "fast": fast}, nil, nil, nil, nil, nil, store,
+		DeliveryConfig{Concurrency: 2, BatchSize: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	go n.runDeliveryWorker(ctx, &wg)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(fast.sent()) < 7 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := fast.sent()
+	if len(sent) != 7 {
+		t.Fatalf("fast deliveries are blocked by the slow one: %v", sent)
+	}
+	if i, j := slices.Index(sent, "created"), slices.Index(sent, "paid"); i > j {
+		t.Errorf("deliveries of the invoice are out of order: %v", sent)
+	}
+	if len(slow.sent()) != 0 {
+		t.Errorf("slow delivery is finished too early")
+	}
+}
+
+type buyerSender struct {
+	recordingSender
+}
+
+func (s *buyerSender) SendsToBuyer() {}
+
+func TestNewDeliveries(t *testing.T) {
+	n := New(map[string]Sender{"merchant": &recordingSender{}, "buyer": &buyerSender{}}, nil, nil, nil, nil, nil, nil, DeliveryConfig{})
+	other := uuid.New()
+	webhooks := []core.Webhook{{ID: uuid.New(), MerchantID: other, Enabled: true}}
+	invoiceID := core.NewInvoiceID()
+	for _, tt := range []struct {
+		name       string
+		merchantID uuid.UUID
+		hasBuyer   bool
+		want       []string
+	}{
+		{name: "default merchant", merchantID: core.DefaultMerchantID, want: []string{"merchant"}},
+		{name: "default merchant with buyer", merchantID: core.DefaultMerchantID, hasBuyer: true, want: []string{"buyer", "merchant"}},
+		{name: "other merchant", merchantID: other, want: []string{core.WebhookSender}},
+		{name: "other merchant with buyer", merchantID: other, hasBuyer: true, want: []string{"buyer", core.WebhookSender}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries, err := n.newDeliveries(tt.merchantID, uuid.New(), &invoiceID, core.InvoicePaidEvent, core.DefaultTonTicker, tt.hasBuyer, nil, webhooks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var senders []string
+			for _, d := range deliveries {
+				senders = append(senders, d.Sender)
+			}
+			slices.Sort(senders)
+			if !slices.Equal(senders, tt.want) {
+				t.Errorf("expected %v senders, got %v", tt.want, senders)
+			}
+		})
+	}
+}
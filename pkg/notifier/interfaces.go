@@ -2,15 +2,47 @@ package notifier
 
 import (
 	"context"
+	"github.com/google/uuid"
 	"github.com/txsociety/spice-harvester/pkg/core"
 )
 
-type sender interface {
-	Send(ctx context.Context, invoice core.PrivateInvoicePrintable) error
+// Sender delivers notifications to a destination configured at startup.
+// Senders receive all events of the default merchant and can skip the ones they are not interested in by returning nil.
+type Sender interface {
+	Send(ctx context.Context, event core.EventEnvelope) error
+}
+
+// BuyerSender is a Sender which delivers notifications to the buyer of the invoice.
+// Buyer senders receive events of invoices with the buyer email of all merchants instead of the default merchant events.
+type BuyerSender interface {
+	Sender
+	SendsToBuyer()
+}
+
+// webhookSender delivers notifications to webhooks managed via API
+type webhookSender interface {
+	Deliver(ctx context.Context, webhook core.Webhook, payload any) error
+}
+
+// publisher broadcasts saved events to the event streams
+type publisher interface {
+	Publish(event core.Event)
 }
 
 type storage interface {
-	GetInvoiceNotifications(ctx context.Context, limit int) ([]core.Invoice, error)
-	DeleteInvoiceNotification(ctx context.Context, invoiceID core.InvoiceID) error
-	DeleteOldNotifications(ctx context.Context) error
+	GetInvoiceEvents(ctx context.Context, limit int) ([]core.InvoiceEvent, error)
+	GetInvoice(ctx context.Context, id core.InvoiceID) (core.Invoice, error)
+	GetSubscriptionNotifications(ctx context.Context, limit int) ([]core.SubscriptionEvent, error)
+	GetSubscription(ctx context.Context, id uuid.UUID) (core.Subscription, error)
+	GetWebhooks(ctx context.Context) ([]core.Webhook, error)
+	GetMerchants(ctx context.Context) ([]core.Merchant, error)
+	EnqueueInvoiceDeliveries(ctx context.Context, event core.Event, deliveries []core.Delivery) (core.Event, error)
+	EnqueueSubscriptionDeliveries(ctx context.Context, event core.Event, deliveries []core.Delivery) (core.Event, error)
+	FailInvoiceEvent(ctx context.Context, id uuid.UUID, reason string) error
+	FailSubscriptionNotification(ctx context.Context, id uuid.UUID, reason string) error
+	GetDueDeliveries(ctx context.Context, limit int) ([]core.Delivery, error)
+	SaveDeliveryAttempt(ctx context.Context, d core.Delivery, attempt core.DeliveryAttempt) error
+	DeleteOldDeliveries(ctx context.Context) error
+	DeleteOldEvents(ctx context.Context) error
+	ListenNotifications(ctx context.Context) (<-chan struct{}, error)
 }
@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"encoding/json"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"math/big"
+	"time"
+)
+
+// MessageData is the data of message templates of senders. Amounts are in whole units of the currency.
+type MessageData struct {
+	Event           core.EventEnvelope
+	Type            string
+	CreatedAt       time.Time
+	PreviousStatus  string
+	InvoiceID       string
+	Status          string
+	Amount          string
+	Currency        string
+	Received        string
+	Overpayment     string
+	PaymentAmount   string // for payment events
+	PaymentCurrency string
+	MerchantName    string
+	MerchantURL     string
+	Goods           []string
+	// ExternalReference is the merchant order reference of the invoice. Other references can be taken from PrivateInfo.
+	ExternalReference string
+	OrderReference    string            // the first of orderReferenceKeys found in PrivateInfo
+	PrivateInfo       map[string]string // JSON strings are unquoted, other values are kept as JSON
+	BuyerEmail        string
+}
+
+// orderReferenceKeys are keys of private_info which usually hold the merchant order reference
+var orderReferenceKeys = []string{"order_id", "order_number", "order"}
+
+// NewMessageData prepares the event for message templates
+func NewMessageData(event core.EventEnvelope, currencies map[string]core.ExtendedCurrency) MessageData {
+	res := MessageData{
+		Event:          event,
+		Type:           event.Type,
+		CreatedAt:      time.Unix(event.CreatedAt, 0),
+		PreviousStatus: event.PreviousStatus,
+		PrivateInfo:    map[string]string{},
+	}
+	if event.Invoice != nil {
+		invoice := event.Invoice
+		res.InvoiceID = invoice.ID
+		res.Status = invoice.Status
+		res.Currency = invoice.Currency
+		res.Amount = formatAmount(invoice.Amount, invoice.Currency, currencies)
+		res.Received = formatAmount(invoice.Received, invoice.Currency, currencies)
+		res.Overpayment = formatAmount(invoice.Overpayment, invoice.Currency, currencies)
+		res.ExternalReference = invoice.ExternalReference
+		res.BuyerEmail = invoice.BuyerEmail
+		for key, value := range invoice.PrivateInfo {
+			res.PrivateInfo[key] = rawString(value)
+		}
+		for _, key := range orderReferenceKeys {
+			if ref, ok := res.PrivateInfo[key]; ok && len(ref) > 0 {
+				res.OrderReference = ref
+				break
+			}
+		}
+		metadata := parseMetadata(invoice.Metadata)
+		res.MerchantName = metadata.MerchantName
+		res.MerchantURL = metadata.MerchantURL
+		for _, item := range metadata.Goods {
+			res.Goods = append(res.Goods, item.Name)
+		}
+	}
+	if event.Payment != nil {
+		res.PaymentCurrency = event.Payment.Currency
+		res.PaymentAmount = formatAmount(event.Payment.Amount, event.Payment.Currency, currencies)
+	}
+	return res
+}
+
+// formatAmount converts the amount in base units to whole units. The amount is returned as is for unknown currencies.
+func formatAmount(amount, ticker string, currencies map[string]core.ExtendedCurrency) string {
+	currency, ok := currencies[ticker]
+	if !ok {
+		return amount
+	}
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok {
+		return amount
+	}
+	return currency.FormatAmount(value)
+}
+
+func parseMetadata(raw map[string]json.RawMessage) core.InvoiceMetadata {
+	var res core.InvoiceMetadata
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return res
+	}
+	_ = json.Unmarshal(b, &res) // metadata is validated on invoice creation, invalid fields are just skipped
+	return res
+}
+
+func rawString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
@@ -2,7 +2,9 @@ package notifier
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/tonkeeper/tongo/ton"
 	"github.com/txsociety/spice-harvester/pkg/core"
 	"log/slog"
@@ -10,35 +12,64 @@ import (
 	"time"
 )
 
+// fallbackPollInterval is how often notifications are checked without signals from the database,
+// e.g. while the listening connection is lost
+const fallbackPollInterval = 30 * time.Second
+
 type Notifier struct {
-	sender          sender
-	currencies      map[string]core.ExtendedCurrency
+	senders         map[string]Sender
+	webhooks        webhookSender
+	publisher       publisher
+	currencies      *core.CurrencyRegistry
 	adnlAddress     *ton.Bits256
 	paymentPrefixes map[string]string
 	storage         storage
+	delivery        DeliveryConfig
+	// deliveryWake wakes the delivery worker up when new deliveries are enqueued
+	deliveryWake chan struct{}
+}
+
+// DeliveryConfig sets up the delivery worker
+type DeliveryConfig struct {
+	// Concurrency is the number of deliveries sent in parallel. Deliveries of the same invoice to the same destination
+	// are never sent in parallel, they are sent in the order of events.
+	Concurrency int
+	// BatchSize is the maximum number of due deliveries taken from the database at once, the ones which do not fit
+	// into free workers are taken again later
+	BatchSize int
 }
 
-func New(sender sender, currencies map[string]core.ExtendedCurrency, adnlAddress *ton.Bits256, paymentPrefixes map[string]string, storage storage) *Notifier {
+// New creates notifier. Senders are identified by names which are saved in their deliveries.
+// Saved events are also passed to the publisher if it is not nil.
+func New(senders map[string]Sender, webhooks webhookSender, publisher publisher, currencies *core.CurrencyRegistry, adnlAddress *ton.Bits256, paymentPrefixes map[string]string, storage storage, delivery DeliveryConfig) *Notifier {
 	return &Notifier{
-		sender:          sender,
+		senders:         senders,
+		webhooks:        webhooks,
+		publisher:       publisher,
 		currencies:      currencies,
 		adnlAddress:     adnlAddress,
 		paymentPrefixes: paymentPrefixes,
 		storage:         storage,
+		delivery:        delivery,
+		deliveryWake:    make(chan struct{}, 1),
 	}
 }
 
 func (n *Notifier) Run(ctx context.Context, wg *sync.WaitGroup) {
 	go n.runNotifyExpirationProcessor(ctx, wg)
-	if n.sender != nil {
+	if len(n.senders) > 0 || n.webhooks != nil || n.publisher != nil {
 		go n.runNotifier(ctx, wg)
+		go n.runDeliveryWorker(ctx, wg)
 	}
 }
 
+// runNotifier turns notifications into the events for streams and deliveries for every sender and matching webhook
+// of the merchant which owns the invoice or subscription. It waits for new notifications by signals from the database and polls them only as a fallback.
 func (n *Notifier) runNotifier(ctx context.Context, wg *sync.WaitGroup) {
 	slog.Info("notifier started")
 	wg.Add(1)
 	defer wg.Done()
+	signals := n.listen(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -46,44 +77,252 @@ func (n *Notifier) runNotifier(ctx context.Context, wg *sync.WaitGroup) {
 			return
 		default:
 			limit := 10
-			invoices, err := n.storage.GetInvoiceNotifications(ctx, limit)
+			invoiceEvents, err := n.storage.GetInvoiceEvents(ctx, limit)
+			if err != nil {
+				slog.Error("get invoice events", "error", err.Error())
+				sleep(ctx, 3*time.Second)
+				continue
+			}
+			merchants, err := n.getMerchants(ctx)
 			if err != nil {
-				slog.Error("get notifications", "error", err.Error())
-				time.Sleep(3 * time.Second)
+				slog.Error("get merchants", "error", err.Error())
+				sleep(ctx, 3*time.Second)
 				continue
 			}
-			err = n.notify(ctx, invoices)
+			var webhooks []core.Webhook
+			if n.webhooks != nil {
+				webhooks, err = n.storage.GetWebhooks(ctx)
+				if err != nil {
+					slog.Error("get webhooks", "error", err.Error())
+					sleep(ctx, 3*time.Second)
+					continue
+				}
+			}
+			err = n.enqueueInvoiceEvents(ctx, invoiceEvents, webhooks, merchants)
 			if err != nil {
-				slog.Error("notify failed", "error", err.Error())
-				time.Sleep(3 * time.Second)
+				slog.Error("enqueue invoice deliveries", "error", err.Error())
+				sleep(ctx, 3*time.Second)
 				continue
 			}
-			if len(invoices) < limit {
-				time.Sleep(2 * time.Second)
+			events, err := n.storage.GetSubscriptionNotifications(ctx, limit)
+			if err != nil {
+				slog.Error("get subscription notifications", "error", err.Error())
+				sleep(ctx, 3*time.Second)
+				continue
+			}
+			err = n.enqueueSubscriptionEvents(ctx, events, webhooks, merchants)
+			if err != nil {
+				slog.Error("enqueue subscription deliveries", "error", err.Error())
+				sleep(ctx, 3*time.Second)
+				continue
+			}
+			if len(invoiceEvents) < limit && len(events) < limit {
+				if signals == nil {
+					signals = n.listen(ctx)
+				}
+				select {
+				case <-ctx.Done():
+				case _, ok := <-signals:
+					if !ok {
+						signals = nil // the connection is lost, check missed notifications and listen again
+					}
+				case <-time.After(fallbackPollInterval):
+				}
 			}
 		}
 	}
 }
 
-func (n *Notifier) notify(ctx context.Context, invoices []core.Invoice) error {
-	for _, invoice := range invoices {
-		invoiceP, err := core.ConvertInvoiceToPrintablePrivate(n.paymentPrefixes, invoice, n.currencies, n.adnlAddress)
+// sleep waits for the duration, it is interrupted on shutdown
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// getMerchants returns merchant IDs by their recipients
+func (n *Notifier) getMerchants(ctx context.Context) (map[ton.AccountID]uuid.UUID, error) {
+	merchants, err := n.storage.GetMerchants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[ton.AccountID]uuid.UUID, len(merchants))
+	for _, m := range merchants {
+		res[m.Recipient] = m.ID
+	}
+	return res, nil
+}
+
+// merchantOf returns the merchant of the recipient. Recipients without a merchant belong to the default one.
+func merchantOf(merchants map[ton.AccountID]uuid.UUID, recipient ton.AccountID) uuid.UUID {
+	if id, ok := merchants[recipient]; ok {
+		return id
+	}
+	return core.DefaultMerchantID
+}
+
+func (n *Notifier) enqueueInvoiceEvents(ctx context.Context, events []core.InvoiceEvent, webhooks []core.Webhook, merchants map[ton.AccountID]uuid.UUID) error {
+	for _, event := range events {
+		invoice, err := n.storage.GetInvoice(ctx, event.InvoiceID)
 		if err != nil {
-			slog.Error("convert invoice to printable", "error", err.Error())
-			continue // can not send this invoice
+			return fmt.Errorf("get invoice %v err: %w", event.InvoiceID, err)
 		}
-		err = n.sender.Send(ctx, invoiceP)
+		invoiceP, err := core.ConvertInvoiceToPrintablePrivate(n.paymentPrefixes, invoice, n.currencies.All(), n.adnlAddress)
 		if err != nil {
-			return fmt.Errorf("send invoice to sender err: %w", err)
+			slog.Error("convert invoice to printable", "event", event.ID, "error", err.Error())
+			err = n.storage.FailInvoiceEvent(ctx, event.ID, err.Error()) // can not send this invoice
+			if err != nil {
+				return fmt.Errorf("fail invoice event %v err: %w", event.ID, err)
+			}
+			continue
+		}
+		var paymentP *core.PaymentPrintable
+		if event.Payment != nil {
+			p, err := core.ConvertPaymentToPrintable(*event.Payment, n.currencies.All())
+			if err != nil {
+				slog.Error("convert payment to printable", "event", event.ID, "error", err.Error())
+				err = n.storage.FailInvoiceEvent(ctx, event.ID, err.Error()) // can not send this payment
+				if err != nil {
+					return fmt.Errorf("fail invoice event %v err: %w", event.ID, err)
+				}
+				continue
+			}
+			paymentP = &p
 		}
-		err = n.storage.DeleteInvoiceNotification(ctx, invoice.ID)
+		payload, err := json.Marshal(core.NewInvoiceEnvelope(event, invoiceP, paymentP))
 		if err != nil {
-			return fmt.Errorf("delete notification err: %w", err)
+			return err
 		}
+		invoiceID := event.InvoiceID
+		merchantID := merchantOf(merchants, invoice.Recipient)
+		deliveries, err := n.newDeliveries(merchantID, event.ID, &invoiceID, event.Type, invoiceP.Currency, len(invoiceP.BuyerEmail) > 0, payload, webhooks)
+		if err != nil {
+			return err
+		}
+		logEvent := core.Event{EventID: event.ID, MerchantID: merchantID, InvoiceID: &invoiceID, Type: event.Type, Payload: payload, CreatedAt: time.Now()}
+		logEvent, err = n.storage.EnqueueInvoiceDeliveries(ctx, logEvent, deliveries)
+		if err != nil {
+			return fmt.Errorf("enqueue deliveries of invoice event %v err: %w", event.ID, err)
+		}
+		if len(deliveries) > 0 {
+			n.wakeDeliveryWorker()
+		}
+		n.publish(logEvent)
 	}
 	return nil
 }
 
+func (n *Notifier) enqueueSubscriptionEvents(ctx context.Context, events []core.SubscriptionEvent, webhooks []core.Webhook, merchants map[ton.AccountID]uuid.UUID) error {
+	for _, event := range events {
+		subscription, err := n.storage.GetSubscription(ctx, event.SubscriptionID)
+		if err != nil {
+			return fmt.Errorf("get subscription err: %w", err)
+		}
+		eventP, err := core.ConvertSubscriptionEventToPrintable(event, subscription, n.currencies.All())
+		if err != nil {
+			slog.Error("convert subscription event to printable", "event", event.ID, "error", err.Error())
+			err = n.storage.FailSubscriptionNotification(ctx, event.ID, err.Error()) // can not send this event
+			if err != nil {
+				return fmt.Errorf("fail subscription event %v err: %w", event.ID, err)
+			}
+			continue
+		}
+		payload, err := json.Marshal(core.NewSubscriptionEnvelope(event.ID, eventP))
+		if err != nil {
+			return err
+		}
+		merchantID := merchantOf(merchants, subscription.Recipient)
+		deliveries, err := n.newDeliveries(merchantID, event.ID, nil, eventP.Event, eventP.Subscription.Template.Currency, false, payload, webhooks)
+		if err != nil {
+			return err
+		}
+		logEvent := core.Event{EventID: event.ID, MerchantID: merchantID, Type: eventP.Event, Payload: payload, CreatedAt: time.Now()}
+		logEvent, err = n.storage.EnqueueSubscriptionDeliveries(ctx, logEvent, deliveries)
+		if err != nil {
+			return fmt.Errorf("enqueue deliveries of subscription event %v err: %w", event.ID, err)
+		}
+		if len(deliveries) > 0 {
+			n.wakeDeliveryWorker()
+		}
+		n.publish(logEvent)
+	}
+	return nil
+}
+
+// listen returns signals about new notifications or nil if listening failed
+func (n *Notifier) listen(ctx context.Context) <-chan struct{} {
+	signals, err := n.storage.ListenNotifications(ctx)
+	if err != nil {
+		slog.Error("listen notifications", "error", err.Error())
+		return nil
+	}
+	return signals
+}
+
+func (n *Notifier) wakeDeliveryWorker() {
+	select {
+	case n.deliveryWake <- struct{}{}:
+	default: // already woken up
+	}
+}
+
+func (n *Notifier) publish(event core.Event) {
+	if n.publisher != nil {
+		n.publisher.Publish(event)
+	}
+}
+
+// newDeliveries creates pending deliveries of the merchant event for every matching webhook of the merchant.
+// Configured senders receive events of the default merchant only, buyer senders receive events with the buyer email.
+func (n *Notifier) newDeliveries(merchantID, eventID uuid.UUID, invoiceID *core.InvoiceID, eventType, ticker string, hasBuyer bool, payload []byte, webhooks []core.Webhook) ([]core.Delivery, error) {
+	var res []core.Delivery
+	now := time.Now()
+	newDelivery := func(sender string) error {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		res = append(res, core.Delivery{
+			ID:            id,
+			MerchantID:    merchantID,
+			Sender:        sender,
+			EventID:       eventID,
+			InvoiceID:     invoiceID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        core.PendingDeliveryStatus,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		return nil
+	}
+	for name, sender := range n.senders {
+		if _, ok := sender.(BuyerSender); ok {
+			if !hasBuyer {
+				continue
+			}
+		} else if merchantID != core.DefaultMerchantID {
+			continue
+		}
+		if err := newDelivery(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, webhook := range webhooks {
+		if webhook.MerchantID != merchantID || !webhook.Matches(eventType, ticker) {
+			continue
+		}
+		if err := newDelivery(core.WebhookSender); err != nil {
+			return nil, err
+		}
+		id := webhook.ID
+		res[len(res)-1].WebhookID = &id
+	}
+	return res, nil
+}
+
 func (n *Notifier) runNotifyExpirationProcessor(ctx context.Context, wg *sync.WaitGroup) {
 	slog.Info("notify expiration processor started")
 	wg.Add(1)
@@ -94,9 +333,13 @@ func (n *Notifier) runNotifyExpirationProcessor(ctx context.Context, wg *sync.Wa
 			slog.Info("notify expiration processor stopped")
 			return
 		case <-time.After(30 * time.Second):
-			err := n.storage.DeleteOldNotifications(ctx)
+			err := n.storage.DeleteOldDeliveries(ctx)
+			if err != nil {
+				slog.Error("delete old deliveries", "error", err.Error())
+			}
+			err = n.storage.DeleteOldEvents(ctx)
 			if err != nil {
-				slog.Error("delete old notifications", "error", err.Error())
+				slog.Error("delete old events", "error", err.Error())
 			}
 		}
 	}
@@ -0,0 +1,116 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// outboxStorage keeps invoices in memory and records what happens to their events
+type outboxStorage struct {
+	storage
+	invoices map[core.InvoiceID]core.Invoice
+	enqueued []uuid.UUID
+	failed   []uuid.UUID
+}
+
+func (s *outboxStorage) GetInvoice(ctx context.Context, id core.InvoiceID) (core.Invoice, error) {
+	invoice, ok := s.invoices[id]
+	if !ok {
+		return core.Invoice{}, core.ErrNotFound
+	}
+	return invoice, nil
+}
+
+func (s *outboxStorage) EnqueueInvoiceDeliveries(ctx context.Context, event core.Event, deliveries []core.Delivery) (core.Event, error) {
+	s.enqueued = append(s.enqueued, event.EventID)
+	return event, nil
+}
+
+func (s *outboxStorage) FailInvoiceEvent(ctx context.Context, id uuid.UUID, reason string) error {
+	s.failed = append(s.failed, id)
+	return nil
+}
+
+func TestEnqueueInvoiceEventsFailsUnprintable(t *testing.T) {
+	newInvoice := func(currency core.Currency) core.Invoice {
+		return core.Invoice{
+			ID:          core.NewInvoiceID(),
+			Status:      core.WaitingInvoiceStatus,
+			Amount:      big.NewInt(100),
+			Overpayment: big.NewInt(0),
+			Received:    big.NewInt(0),
+			Currency:    currency,
+			CreatedAt:   time.Now(),
+			ExpireAt:    time.Now().Add(time.Hour),
+			UpdatedAt:   time.Now(),
+		}
+	}
+	// the currency of the invoice is no longer registered, e.g. its ticker is taken by a configured currency
+	unknown := newInvoice(core.JettonCurrency(ton.AccountID{}))
+	known := newInvoice(core.TonCurrency())
+	s := &outboxStorage{invoices: map[core.InvoiceID]core.Invoice{unknown.ID: unknown, known.ID: known}}
+	currencies := core.NewCurrencyRegistry(map[string]core.ExtendedCurrency{core.DefaultTonTicker: {Currency: core.TonCurrency()}})
+	n := New(map[string]Sender{"merchant": &recordingSender{}}, nil, nil, currencies, nil, nil, s, DeliveryConfig{})
+
+	events := []core.InvoiceEvent{
+		{ID: uuid.New(), InvoiceID: unknown.ID, Type: core.InvoiceCreatedEvent},
+		{ID: uuid.New(), InvoiceID: known.ID, Type: core.InvoiceCreatedEvent},
+	}
+	err := n.enqueueInvoiceEvents(context.Background(), events, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.failed, []uuid.UUID{events[0].ID}) {
+		t.Errorf("expected failed event %v, got %v", events[0].ID, s.failed)
+	}
+	if !slices.Equal(s.enqueued, []uuid.UUID{events[1].ID}) {
+		t.Errorf("expected enqueued event %v, got %v", events[1].ID, s.enqueued)
+	}
+}
+
+// failingStorage fails all requests like an unavailable database
+type failingStorage struct {
+	storage
+}
+
+var errUnavailable = errors.New("database is unavailable")
+
+func (failingStorage) ListenNotifications(ctx context.Context) (<-chan struct{}, error) {
+	return nil, errUnavailable
+}
+
+func (failingStorage) GetInvoiceEvents(ctx context.Context, limit int) ([]core.InvoiceEvent, error) {
+	return nil, errUnavailable
+}
+
+func (failingStorage) GetDueDeliveries(ctx context.Context, limit int) ([]core.Delivery, error) {
+	return nil, errUnavailable
+}
+
+func TestStopWhileRetrying(t *testing.T) {
+	n := New(map[string]Sender{"merchant": &recordingSender{}}, nil, nil, nil, nil, nil, failingStorage{}, DeliveryConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	go n.runNotifier(ctx, &wg)
+	go n.runDeliveryWorker(ctx, &wg)
+	time.Sleep(100 * time.Millisecond) // both loops wait to retry
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("workers must stop without waiting for the retry")
+	}
+}
@@ -0,0 +1,1068 @@
+// Package proof checks liteserver responses. They are trusted only if they are proven from the trusted masterchain block.
+// New masterchain blocks are proven by the chain of links signed by validators, account states are proven by
+// merkle proofs of masterchain and shard blocks and states. Parts of the blockchain state which are required for
+// a check must not be pruned from the proof, otherwise the absence of data could be faked.
+package proof
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/liteclient"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"math/bits"
+)
+
+const (
+	blockTag         = 0x11ef55aa
+	blockExtraTag    = 0x4a33f6fd
+	mcBlockExtraTag  = 0xcca5
+	shardStateTag    = 0x9023afe2
+	mcStateExtraTag  = 0xcc26
+	blockIDMagic     = 0xc50b6e70 // ton.blockId, the message signed by validators
+	ed25519KeyMagic  = 0x4813b4c6 // pub.ed25519, the short node ID is the hash of the key with this prefix
+	maxCellDepth     = 1024
+	validatorsParam  = 34
+	masterchainShard = 0x8000000000000000
+)
+
+var errPruned = errors.New("required data is pruned from the proof")
+
+// cellHashes are hashes and depths of all significant levels of the cell
+type cellHashes struct {
+	cellType boc.CellType
+	mask     uint32
+	data     []byte // content of a pruned branch cell, it keeps hashes and depths of the original cell
+	hashes   [][]byte
+	depths   []int
+}
+
+// hashCell calculates hashes of the cell like boc.Cell.Hash, but the level mask is calculated from children instead of
+// being taken from the serialized cell and hashes of lower levels are available.
+// The level 0 hash of the cell with pruned branches is the hash of the original cell.
+func hashCell(c *boc.Cell, cache map[*boc.Cell]*cellHashes) (*cellHashes, error) {
+	if h, ok := cache[c]; ok {
+		return h, nil
+	}
+	refs := c.Refs()
+	children := make([]*cellHashes, 0, len(refs))
+	for _, ref := range refs {
+		child, err := hashCell(ref, cache)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	bitLen := c.BitSize()
+	raw := c.RawBitString()
+	data := make([]byte, (bitLen+7)/8)
+	copy(data, raw.Buffer())
+	if bitLen%8 != 0 {
+		data[len(data)-1] &= 0xff << (8 - bitLen%8)
+	}
+	h := &cellHashes{cellType: c.CellType(), data: data}
+	offset := 0
+	switch h.cellType {
+	case boc.PrunedBranchCell:
+		if len(data) < 2 || data[0] != 1 || len(children) > 0 {
+			return nil, errors.New("invalid pruned branch cell")
+		}
+		h.mask = uint32(data[1])
+		offset = bits.OnesCount32(h.mask)
+		if h.mask == 0 || h.mask > 7 || bitLen != 16+offset*(32+2)*8 {
+			return nil, errors.New("invalid pruned branch cell")
+		}
+	case boc.MerkleProofCell, boc.MerkleUpdateCell:
+		for _, child := range children {
+			h.mask |= child.mask >> 1
+		}
+	default:
+		for _, child := range children {
+			h.mask |= child.mask
+		}
+	}
+	level := 32 - bits.LeadingZeros32(h.mask)
+	hashIndex := -1
+	for i := 0; i <= level; i++ {
+		if i > 0 && h.mask&(1<<(i-1)) == 0 {
+			continue
+		}
+		hashIndex++
+		if hashIndex < offset {
+			continue
+		}
+		d1 := byte(len(children) + 32*int(h.mask&(1<<i-1)))
+		if h.cellType != boc.OrdinaryCell {
+			d1 += 8
+		}
+		d2 := byte((bitLen+7)/8 + bitLen/8)
+		x := sha256.New()
+		x.Write([]byte{d1, d2})
+		if hashIndex == offset {
+			repr := append([]byte{}, data...)
+			if bitLen%8 != 0 {
+				repr[len(repr)-1] |= 1 << (7 - bitLen%8)
+			}
+			x.Write(repr)
+		} else {
+			x.Write(h.hashes[hashIndex-offset-1])
+		}
+		childLevel := i
+		if h.cellType == boc.MerkleProofCell || h.cellType == boc.MerkleUpdateCell {
+			childLevel++
+		}
+		depth := 0
+		for _, child := range children {
+			d := child.depth(childLevel)
+			x.Write([]byte{byte(d >> 8), byte(d)})
+			depth = max(depth, d)
+		}
+		if len(children) > 0 {
+			if depth >= maxCellDepth {
+				return nil, errors.New("cell depth is too big")
+			}
+			depth++
+		}
+		for _, child := range children {
+			x.Write(child.hash(childLevel))
+		}
+		h.hashes = append(h.hashes, x.Sum(nil))
+		h.depths = append(h.depths, depth)
+	}
+	cache[c] = h
+	return h, nil
+}
+
+func (h *cellHashes) index(level int) (int, bool) {
+	index := bits.OnesCount32(h.mask & (1<<level - 1))
+	if h.cellType == boc.PrunedBranchCell {
+		offset := bits.OnesCount32(h.mask)
+		if index != offset {
+			return index, true
+		}
+		return 0, false
+	}
+	return index, false
+}
+
+func (h *cellHashes) hash(level int) []byte {
+	index, original := h.index(level)
+	if original {
+		return h.data[2+index*32 : 2+(index+1)*32]
+	}
+	return h.hashes[index]
+}
+
+func (h *cellHashes) depth(level int) int {
+	index, original := h.index(level)
+	if original {
+		offset := 2 + 32*bits.OnesCount32(h.mask) + index*2
+		return int(binary.BigEndian.Uint16(h.data[offset:]))
+	}
+	return h.depths[index]
+}
+
+// representationHash returns the hash of the original cell, pruned branches are replaced with the cells they prune
+func representationHash(c *boc.Cell) (ton.Bits256, error) {
+	h, err := hashCell(c, map[*boc.Cell]*cellHashes{})
+	if err != nil {
+		return ton.Bits256{}, err
+	}
+	return ton.Bits256(h.hash(0)), nil
+}
+
+// provenRoot checks that the merkle proof is built for the cell with the hash and returns the root of the proven tree
+func provenRoot(proof *boc.Cell, hash ton.Bits256) (*boc.Cell, error) {
+	if proof.CellType() != boc.MerkleProofCell || proof.RefsSize() != 1 {
+		return nil, errors.New("not a merkle proof")
+	}
+	root := proof.Refs()[0]
+	h, err := representationHash(root)
+	if err != nil {
+		return nil, err
+	}
+	if h != hash {
+		return nil, fmt.Errorf("merkle proof is built for %v, expected %v", h.Hex(), hash.Hex())
+	}
+	if root.CellType() == boc.PrunedBranchCell {
+		return nil, errPruned
+	}
+	root.ResetCounters()
+	return root, nil
+}
+
+func deserializeProof(b []byte, roots int) ([]*boc.Cell, error) {
+	cells, err := boc.DeserializeBoc(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(cells) < roots {
+		return nil, fmt.Errorf("proof must contain %d roots, got %d", roots, len(cells))
+	}
+	return cells, nil
+}
+
+// ref returns the ref of the cell with the reset cursor, pruned refs can not be read
+func ref(c *boc.Cell, i int) (*boc.Cell, error) {
+	refs := c.Refs()
+	if i >= len(refs) {
+		return nil, boc.ErrNotEnoughRefs
+	}
+	if refs[i].CellType() == boc.PrunedBranchCell {
+		return nil, errPruned
+	}
+	refs[i].ResetCounters()
+	return refs[i], nil
+}
+
+// nextRef is boc.Cell.NextRef which does not allow reading pruned refs
+func nextRef(c *boc.Cell) (*boc.Cell, error) {
+	r, err := c.NextRef()
+	if err != nil {
+		return nil, err
+	}
+	if r.CellType() == boc.PrunedBranchCell {
+		return nil, errPruned
+	}
+	return r, nil
+}
+
+func readTag(c *boc.Cell, size int, tag uint64, name string) error {
+	v, err := c.ReadUint(size)
+	if err != nil {
+		return err
+	}
+	if v != tag {
+		return fmt.Errorf("invalid %v tag %x", name, v)
+	}
+	return nil
+}
+
+func keyBit(key []byte, i int) bool {
+	return key[i/8]&(0x80>>(i%8)) != 0
+}
+
+func uint32Key(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// dictLookup finds the key in the dictionary and returns the leaf cell with the cursor at the value.
+// Nil is returned if the dictionary has no key. The lookup fails if any cell on the path to the key is pruned,
+// so the absence of the key is proven too.
+func dictLookup(root *boc.Cell, key []byte, keyLen int) (*boc.Cell, error) {
+	if root.CellType() == boc.PrunedBranchCell {
+		return nil, errPruned
+	}
+	root.ResetCounters()
+	c := root
+	for pos := 0; ; pos++ {
+		n := keyLen - pos
+		label, err := readLabel(c, n)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range label {
+			if b != keyBit(key, pos) {
+				return nil, nil
+			}
+			pos++
+		}
+		if pos == keyLen {
+			return c, nil
+		}
+		i := 0
+		if keyBit(key, pos) {
+			i = 1
+		}
+		c, err = ref(c, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readLabel reads HmLabel of the dictionary edge with the max length n
+func readLabel(c *boc.Cell, n int) ([]bool, error) {
+	short, err := c.ReadBit()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		length int
+		same   *bool
+	)
+	if !short { // hml_short$0 len:(Unary ~n) s:(n * Bit)
+		l, err := c.ReadUnary()
+		if err != nil {
+			return nil, err
+		}
+		length = int(l)
+	} else {
+		isSame, err := c.ReadBit()
+		if err != nil {
+			return nil, err
+		}
+		if isSame { // hml_same$11 v:Bit n:(#<= m)
+			v, err := c.ReadBit()
+			if err != nil {
+				return nil, err
+			}
+			same = &v
+		}
+		// hml_long$10 n:(#<= m) s:(n * Bit)
+		l, err := c.ReadUint(bits.Len(uint(n)))
+		if err != nil {
+			return nil, err
+		}
+		length = int(l)
+	}
+	if length > n {
+		return nil, errors.New("invalid dictionary label")
+	}
+	label := make([]bool, length)
+	for i := range label {
+		if same != nil {
+			label[i] = *same
+			continue
+		}
+		label[i], err = c.ReadBit()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return label, nil
+}
+
+func skipCurrencyCollection(c *boc.Cell) error {
+	l, err := c.ReadUint(4)
+	if err != nil {
+		return err
+	}
+	err = c.Skip(int(l) * 8)
+	if err != nil {
+		return err
+	}
+	hasExtra, err := c.ReadBit()
+	if err != nil {
+		return err
+	}
+	if hasExtra {
+		_, err = c.NextRef()
+	}
+	return err
+}
+
+// proveBlock checks the merkle proof of the block and returns the root of the block and its header
+func proveBlock(proof *boc.Cell, id ton.BlockIDExt) (*boc.Cell, tlb.BlockInfoPart, error) {
+	root, err := provenRoot(proof, id.RootHash)
+	if err != nil {
+		return nil, tlb.BlockInfoPart{}, err
+	}
+	err = readTag(root, 32, blockTag, "block")
+	if err != nil {
+		return nil, tlb.BlockInfoPart{}, err
+	}
+	infoCell, err := ref(root, 0)
+	if err != nil {
+		return nil, tlb.BlockInfoPart{}, err
+	}
+	var info struct {
+		Magic tlb.Magic `tlb:"block_info#9bc7a987"`
+		Info  tlb.BlockInfoPart
+	}
+	err = tlb.Unmarshal(infoCell, &info)
+	if err != nil {
+		return nil, tlb.BlockInfoPart{}, fmt.Errorf("invalid block info: %w", err)
+	}
+	shard := info.Info.Shard
+	if info.Info.SeqNo != id.Seqno || shard.WorkchainID != id.Workchain || shard.ShardPfxBits > 60 ||
+		shard.ShardPrefix|1<<(63-shard.ShardPfxBits) != id.Shard {
+		return nil, tlb.BlockInfoPart{}, fmt.Errorf("block info does not match block %v", id.String())
+	}
+	return root, info.Info, nil
+}
+
+func proveBlockBoc(b []byte, id ton.BlockIDExt) (*boc.Cell, tlb.BlockInfoPart, error) {
+	cells, err := deserializeProof(b, 1)
+	if err != nil {
+		return nil, tlb.BlockInfoPart{}, err
+	}
+	return proveBlock(cells[0], id)
+}
+
+// stateHash returns the hash of the state after the block from the state update
+func stateHash(block *boc.Cell) (ton.Bits256, error) {
+	update, err := ref(block, 2)
+	if err != nil {
+		return ton.Bits256{}, err
+	}
+	if update.CellType() != boc.MerkleUpdateCell {
+		return ton.Bits256{}, errors.New("state update is not a merkle update")
+	}
+	err = update.Skip(8 + 256) // tag and the hash of the old state
+	if err != nil {
+		return ton.Bits256{}, err
+	}
+	b, err := update.ReadBytes(32)
+	if err != nil {
+		return ton.Bits256{}, err
+	}
+	return ton.Bits256(b), nil
+}
+
+// provenState checks the merkle proof of the state after the proven block
+func provenState(block *boc.Cell, proof *boc.Cell) (*boc.Cell, error) {
+	hash, err := stateHash(block)
+	if err != nil {
+		return nil, err
+	}
+	root, err := provenRoot(proof, hash)
+	if err != nil {
+		return nil, err
+	}
+	err = readTag(root, 32, shardStateTag, "shard state")
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// keyBlockConfig returns the config dictionary from the extra of the key block
+func keyBlockConfig(block *boc.Cell) (*boc.Cell, error) {
+	extra, err := ref(block, 3)
+	if err != nil {
+		return nil, err
+	}
+	err = readTag(extra, 32, blockExtraTag, "block extra")
+	if err != nil {
+		return nil, err
+	}
+	err = extra.Skip(256 + 256) // rand_seed and created_by
+	if err != nil {
+		return nil, err
+	}
+	hasCustom, err := extra.ReadBit()
+	if err != nil {
+		return nil, err
+	}
+	if !hasCustom {
+		return nil, errors.New("not a masterchain block")
+	}
+	mcExtra, err := ref(extra, 3)
+	if err != nil {
+		return nil, err
+	}
+	err = readTag(mcExtra, 16, mcBlockExtraTag, "masterchain block extra")
+	if err != nil {
+		return nil, err
+	}
+	isKey, err := mcExtra.ReadBit()
+	if err != nil {
+		return nil, err
+	}
+	if !isKey {
+		return nil, errors.New("not a key block")
+	}
+	hasShardHashes, err := mcExtra.ReadBit()
+	if err != nil {
+		return nil, err
+	}
+	if hasShardHashes {
+		_, err = mcExtra.NextRef()
+		if err != nil {
+			return nil, err
+		}
+	}
+	hasShardFees, err := mcExtra.ReadBit()
+	if err != nil {
+		return nil, err
+	}
+	if hasShardFees {
+		_, err = mcExtra.NextRef()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < 2; i++ { // fees and create of ShardFeeCreated
+		err = skipCurrencyCollection(mcExtra)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = mcExtra.NextRef() // prev_blk_signatures, recover_create_msg and mint_msg
+	if err != nil {
+		return nil, err
+	}
+	err = mcExtra.Skip(256) // config_addr
+	if err != nil {
+		return nil, err
+	}
+	return nextRef(mcExtra)
+}
+
+// mcStateExtra returns McStateExtra of the masterchain state with the cursor after the tag
+func mcStateExtra(state *boc.Cell) (*boc.Cell, error) {
+	refs := state.Refs()
+	if len(refs) < 4 {
+		return nil, errors.New("not a masterchain state")
+	}
+	extra, err := ref(state, 3)
+	if err != nil {
+		return nil, err
+	}
+	err = readTag(extra, 16, mcStateExtraTag, "masterchain state extra")
+	if err != nil {
+		return nil, err
+	}
+	return extra, nil
+}
+
+type validator struct {
+	key    ed25519.PublicKey
+	weight uint64
+}
+
+// masterchainValidators returns validators of the masterchain from the current validator set of the config.
+// They are the first main validators of the set, the shuffle of the catchain config only changes their order.
+func masterchainValidators(config *boc.Cell) ([]validator, error) {
+	leaf, err := dictLookup(config, uint32Key(validatorsParam), 32)
+	if err != nil {
+		return nil, err
+	}
+	if leaf == nil {
+		return nil, errors.New("config has no validator set")
+	}
+	param, err := nextRef(leaf)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := param.ReadUint(8)
+	if err != nil {
+		return nil, err
+	}
+	err = param.Skip(64) // utime_since and utime_until
+	if err != nil {
+		return nil, err
+	}
+	total, err := param.ReadUint(16)
+	if err != nil {
+		return nil, err
+	}
+	main, err := param.ReadUint(16)
+	if err != nil {
+		return nil, err
+	}
+	if main == 0 || main > total {
+		return nil, errors.New("invalid validator set")
+	}
+	var list *boc.Cell
+	switch tag {
+	case 0x11: // validators#11 list:(Hashmap 16 ValidatorDescr)
+		list = param.CopyRemaining()
+	case 0x12: // validators_ext#12 total_weight:uint64 list:(HashmapE 16 ValidatorDescr)
+		err = param.Skip(64)
+		if err != nil {
+			return nil, err
+		}
+		hasList, err := param.ReadBit()
+		if err != nil {
+			return nil, err
+		}
+		if !hasList {
+			return nil, errors.New("empty validator set")
+		}
+		list, err = nextRef(param)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid validator set tag %x", tag)
+	}
+	res := make([]validator, 0, main)
+	for i := uint16(0); i < uint16(main); i++ {
+		leaf, err := dictLookup(list, binary.BigEndian.AppendUint16(nil, i), 16)
+		if err != nil {
+			return nil, err
+		}
+		if leaf == nil {
+			return nil, fmt.Errorf("validator %d is not found", i)
+		}
+		var descr tlb.ValidatorDescr
+		err = tlb.Unmarshal(leaf, &descr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validator %d: %w", i, err)
+		}
+		switch {
+		case descr.Validator != nil:
+			res = append(res, validator{key: descr.Validator.PublicKey.PubKey[:], weight: descr.Validator.Weight})
+		case descr.ValidatorAddr != nil:
+			res = append(res, validator{key: descr.ValidatorAddr.PublicKey.PubKey[:], weight: descr.ValidatorAddr.Weight})
+		default:
+			return nil, fmt.Errorf("invalid validator %d", i)
+		}
+	}
+	return res, nil
+}
+
+// checkSignatures checks that the block is signed by validators with more than 2/3 of the total weight
+func checkSignatures(validators []validator, block ton.BlockIDExt, signatures []liteclient.LiteServerSignatureC) error {
+	msg := binary.LittleEndian.AppendUint32(nil, blockIDMagic)
+	msg = append(msg, block.RootHash[:]...)
+	msg = append(msg, block.FileHash[:]...)
+	byNodeID := make(map[ton.Bits256]validator, len(validators))
+	var total, signed, carry uint64
+	for _, v := range validators {
+		id := sha256.Sum256(append(binary.LittleEndian.AppendUint32(nil, ed25519KeyMagic), v.key...))
+		byNodeID[id] = v
+		total, carry = bits.Add64(total, v.weight, 0)
+		if carry != 0 {
+			return errors.New("total weight of validators overflows")
+		}
+	}
+	seen := make(map[ton.Bits256]struct{}, len(signatures))
+	for _, s := range signatures {
+		id := ton.Bits256(s.NodeIdShort)
+		v, ok := byNodeID[id]
+		if !ok {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		if !ed25519.Verify(v.key, msg, s.Signature) {
+			return fmt.Errorf("invalid signature of validator %v", id.Hex())
+		}
+		signed += v.weight // can not overflow because it is not greater than total
+	}
+	// signed * 3 > total * 2 without overflow
+	signedHi, signedLo := bits.Mul64(signed, 3)
+	totalHi, totalLo := bits.Mul64(total, 2)
+	if signedHi < totalHi || signedHi == totalHi && signedLo <= totalLo {
+		return fmt.Errorf("block %v is signed by %d of %d weight of validators", block.Seqno, signed, total)
+	}
+	return nil
+}
+
+// IsMasterchainBlock reports whether the block is a block of the masterchain
+func IsMasterchainBlock(id ton.BlockIDExt) bool {
+	return id.Workchain == -1 && id.Shard == masterchainShard
+}
+
+// VerifyLink checks the link of the masterchain proof chain starting at the trusted block and returns the proven block.
+// A forward link is signed by validators of the trusted key block, a backward link is proven by the list of previous
+// blocks in the state of the trusted block.
+func VerifyLink(trusted ton.BlockIDExt, link liteclient.LiteServerBlockLink) (ton.BlockIDExt, bool, error) {
+	switch link.SumType {
+	case "LiteServerBlockLinkForward":
+		l := link.LiteServerBlockLinkForward
+		to := l.To.ToBlockIdExt()
+		if l.From.ToBlockIdExt() != trusted {
+			return to, false, errors.New("link does not start at the trusted block")
+		}
+		if !IsMasterchainBlock(to) || to.Seqno <= trusted.Seqno {
+			return to, false, errors.New("invalid forward link")
+		}
+		_, info, err := proveBlockBoc(l.DestProof, to)
+		if err != nil {
+			return to, false, fmt.Errorf("invalid destination proof: %w", err)
+		}
+		if info.KeyBlock != l.ToKeyBlock {
+			return to, false, errors.New("key block flag does not match")
+		}
+		if info.PrevKeyBlockSeqno != trusted.Seqno {
+			return to, false, errors.New("block is not signed by validators of the trusted key block")
+		}
+		if info.GenCatchainSeqno != l.Signatures.CatchainSeqno || info.GenValidatorListHashShort != l.Signatures.ValidatorSetHash {
+			return to, false, errors.New("signatures are not made by validators of the block")
+		}
+		block, _, err := proveBlockBoc(l.ConfigProof, trusted)
+		if err != nil {
+			return to, false, fmt.Errorf("invalid config proof: %w", err)
+		}
+		config, err := keyBlockConfig(block)
+		if err != nil {
+			return to, false, fmt.Errorf("invalid config proof: %w", err)
+		}
+		validators, err := masterchainValidators(config)
+		if err != nil {
+			return to, false, fmt.Errorf("invalid config proof: %w", err)
+		}
+		err = checkSignatures(validators, to, l.Signatures.Signatures)
+		if err != nil {
+			return to, false, err
+		}
+		return to, info.KeyBlock, nil
+	case "LiteServerBlockLinkBack":
+		l := link.LiteServerBlockLinkBack
+		to := l.To.ToBlockIdExt()
+		if l.From.ToBlockIdExt() != trusted {
+			return to, false, errors.New("link does not start at the trusted block")
+		}
+		if !IsMasterchainBlock(to) || to.Seqno >= trusted.Seqno {
+			return to, false, errors.New("invalid backward link")
+		}
+		cells, err := deserializeProof(l.StateProof, 1)
+		if err != nil {
+			return to, false, err
+		}
+		block, _, err := proveBlockBoc(l.Proof, trusted)
+		if err != nil {
+			return to, false, err
+		}
+		state, err := provenState(block, cells[0])
+		if err != nil {
+			return to, false, fmt.Errorf("invalid state proof: %w", err)
+		}
+		prev, isKey, err := prevMasterchainBlock(state, to.Seqno)
+		if err != nil {
+			return to, false, fmt.Errorf("invalid state proof: %w", err)
+		}
+		if prev != to || isKey != l.ToKeyBlock {
+			return to, false, errors.New("block is not found in previous blocks of the trusted block")
+		}
+		return to, isKey, nil
+	default:
+		return ton.BlockIDExt{}, false, fmt.Errorf("unknown link type %v", link.SumType)
+	}
+}
+
+// prevMasterchainBlock finds the block in prev_blocks of the masterchain state
+func prevMasterchainBlock(state *boc.Cell, seqno uint32) (ton.BlockIDExt, bool, error) {
+	extra, err := mcStateExtra(state)
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	hasShardHashes, err := extra.ReadBit()
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	if hasShardHashes {
+		_, err = extra.NextRef()
+		if err != nil {
+			return ton.BlockIDExt{}, false, err
+		}
+	}
+	_, err = extra.NextRef() // config
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	other, err := nextRef(extra)
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	err = other.Skip(16 + 65) // flags and validator_info
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	hasPrevBlocks, err := other.ReadBit()
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	if !hasPrevBlocks {
+		return ton.BlockIDExt{}, false, errors.New("no previous blocks")
+	}
+	prevBlocks, err := nextRef(other)
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	leaf, err := dictLookup(prevBlocks, uint32Key(seqno), 32)
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	if leaf == nil {
+		return ton.BlockIDExt{}, false, fmt.Errorf("block %d is not found", seqno)
+	}
+	err = leaf.Skip(65) // KeyMaxLt of the leaf
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	isKey, err := leaf.ReadBit()
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	var blockRef tlb.ExtBlkRef
+	err = tlb.Unmarshal(leaf, &blockRef)
+	if err != nil {
+		return ton.BlockIDExt{}, false, err
+	}
+	if blockRef.SeqNo != seqno {
+		return ton.BlockIDExt{}, false, fmt.Errorf("block %d is not found", seqno)
+	}
+	return ton.BlockIDExt{
+		BlockID:  ton.BlockID{Workchain: -1, Shard: masterchainShard, Seqno: seqno},
+		RootHash: ton.Bits256(blockRef.RootHash),
+		FileHash: ton.Bits256(blockRef.FileHash),
+	}, isKey, nil
+}
+
+// shardBlock finds the latest block of the shard which contains the account in shard_hashes of the masterchain state
+func shardBlock(mcState *boc.Cell, account ton.AccountID) (ton.BlockIDExt, error) {
+	extra, err := mcStateExtra(mcState)
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	hasShardHashes, err := extra.ReadBit()
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	if !hasShardHashes {
+		return ton.BlockIDExt{}, errors.New("no shards")
+	}
+	shardHashes, err := nextRef(extra)
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	return findShard(shardHashes, account)
+}
+
+// findShard finds the block of the shard which contains the account in ShardHashes
+func findShard(shardHashes *boc.Cell, account ton.AccountID) (ton.BlockIDExt, error) {
+	leaf, err := dictLookup(shardHashes, uint32Key(uint32(account.Workchain)), 32)
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	if leaf == nil {
+		return ton.BlockIDExt{}, fmt.Errorf("workchain %d is not found", account.Workchain)
+	}
+	node, err := nextRef(leaf) // BinTree ShardDescr
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	depth := 0
+	for {
+		isFork, err := node.ReadBit()
+		if err != nil {
+			return ton.BlockIDExt{}, err
+		}
+		if !isFork {
+			break
+		}
+		if depth >= 60 {
+			return ton.BlockIDExt{}, errors.New("invalid shard tree")
+		}
+		i := 0
+		if keyBit(account.Address[:], depth) {
+			i = 1
+		}
+		node, err = ref(node, i)
+		if err != nil {
+			return ton.BlockIDExt{}, err
+		}
+		depth++
+	}
+	tag, err := node.ReadUint(4)
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	if tag != 0xa && tag != 0xb {
+		return ton.BlockIDExt{}, fmt.Errorf("invalid shard description tag %x", tag)
+	}
+	seqno, err := node.ReadUint(32)
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	err = node.Skip(32 + 64 + 64) // reg_mc_seqno, start_lt and end_lt
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	rootHash, err := node.ReadBytes(32)
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	fileHash, err := node.ReadBytes(32)
+	if err != nil {
+		return ton.BlockIDExt{}, err
+	}
+	prefix := binary.BigEndian.Uint64(account.Address[:8])
+	shard := uint64(masterchainShard)
+	if depth > 0 {
+		shard = prefix>>(64-depth)<<(64-depth) | 1<<(63-depth)
+	}
+	return ton.BlockIDExt{
+		BlockID:  ton.BlockID{Workchain: account.Workchain, Shard: shard, Seqno: uint32(seqno)},
+		RootHash: ton.Bits256(rootHash),
+		FileHash: ton.Bits256(fileHash),
+	}, nil
+}
+
+// VerifyAccountState checks that the state of the account is proven by the trusted masterchain block.
+// The state of an absent account is AccountNone.
+func VerifyAccountState(trusted ton.BlockIDExt, account ton.AccountID, res liteclient.LiteServerAccountStateC) (tlb.ShardAccount, error) {
+	if res.Id.ToBlockIdExt() != trusted {
+		return tlb.ShardAccount{}, errors.New("account state is not for the trusted block")
+	}
+	shardID := res.Shardblk.ToBlockIdExt()
+	if account.Workchain == -1 {
+		if shardID != trusted {
+			return tlb.ShardAccount{}, errors.New("masterchain account state is not for the trusted block")
+		}
+	} else {
+		cells, err := deserializeProof(res.ShardProof, 2)
+		if err != nil {
+			return tlb.ShardAccount{}, fmt.Errorf("invalid shard proof: %w", err)
+		}
+		block, _, err := proveBlock(cells[0], trusted)
+		if err != nil {
+			return tlb.ShardAccount{}, fmt.Errorf("invalid shard proof: %w", err)
+		}
+		state, err := provenState(block, cells[1])
+		if err != nil {
+			return tlb.ShardAccount{}, fmt.Errorf("invalid shard proof: %w", err)
+		}
+		proven, err := shardBlock(state, account)
+		if err != nil {
+			return tlb.ShardAccount{}, fmt.Errorf("invalid shard proof: %w", err)
+		}
+		if proven != shardID {
+			return tlb.ShardAccount{}, errors.New("shard block is not the latest block of the account shard")
+		}
+	}
+	cells, err := deserializeProof(res.Proof, 2)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	block, _, err := proveBlock(cells[0], shardID)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	state, err := provenState(block, cells[1])
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	accounts, err := ref(state, 1)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	var leaf *boc.Cell
+	hasAccounts, err := accounts.ReadBit()
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	if hasAccounts {
+		root, err := ref(accounts, 0)
+		if err != nil {
+			return tlb.ShardAccount{}, err
+		}
+		leaf, err = dictLookup(root, account.Address[:], 256)
+		if err != nil {
+			return tlb.ShardAccount{}, err
+		}
+	}
+	if leaf == nil {
+		if len(res.State) > 0 {
+			return tlb.ShardAccount{}, errors.New("state of the absent account")
+		}
+		return tlb.ShardAccount{Account: tlb.Account{SumType: "AccountNone"}}, nil
+	}
+	err = leaf.Skip(5) // split_depth of DepthBalanceInfo
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	err = skipCurrencyCollection(leaf)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	accountCell, err := leaf.NextRef()
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	accountHash, err := representationHash(accountCell)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	lastTransHash, err := leaf.ReadBytes(32)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	lastTransLt, err := leaf.ReadUint(64)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	stateCells, err := deserializeProof(res.State, 1)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	hash, err := representationHash(stateCells[0])
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	if hash != accountHash {
+		return tlb.ShardAccount{}, errors.New("account state does not match the proof")
+	}
+	var acc tlb.Account
+	err = tlb.Unmarshal(stateCells[0], &acc)
+	if err != nil {
+		return tlb.ShardAccount{}, err
+	}
+	return tlb.ShardAccount{Account: acc, LastTransHash: tlb.Bits256(lastTransHash), LastTransLt: lastTransLt}, nil
+}
+
+// VerifyConfig checks that the config is proven by the trusted masterchain block
+func VerifyConfig(trusted ton.BlockIDExt, res liteclient.LiteServerConfigInfoC) (tlb.ConfigParams, error) {
+	if res.Id.ToBlockIdExt() != trusted {
+		return tlb.ConfigParams{}, errors.New("config is not for the trusted block")
+	}
+	block, _, err := proveBlockBoc(res.StateProof, trusted)
+	if err != nil {
+		return tlb.ConfigParams{}, err
+	}
+	cells, err := deserializeProof(res.ConfigProof, 1)
+	if err != nil {
+		return tlb.ConfigParams{}, err
+	}
+	state, err := provenState(block, cells[0])
+	if err != nil {
+		return tlb.ConfigParams{}, err
+	}
+	extra, err := mcStateExtra(state)
+	if err != nil {
+		return tlb.ConfigParams{}, err
+	}
+	hasShardHashes, err := extra.ReadBit()
+	if err != nil {
+		return tlb.ConfigParams{}, err
+	}
+	configRef := 0
+	if hasShardHashes {
+		_, err = extra.NextRef()
+		if err != nil {
+			return tlb.ConfigParams{}, err
+		}
+		configRef = 1
+	}
+	refs := extra.Refs()
+	if len(refs) <= configRef {
+		return tlb.ConfigParams{}, boc.ErrNotEnoughRefs
+	}
+	// the emulator would silently run without pruned params
+	if hasPruned(refs[configRef], map[*boc.Cell]struct{}{}) {
+		return tlb.ConfigParams{}, errPruned
+	}
+	var config tlb.ConfigParams
+	err = tlb.Unmarshal(extra, &config)
+	if err != nil {
+		return tlb.ConfigParams{}, err
+	}
+	return config, nil
+}
+
+func hasPruned(c *boc.Cell, visited map[*boc.Cell]struct{}) bool {
+	if _, ok := visited[c]; ok {
+		return false
+	}
+	visited[c] = struct{}{}
+	if c.CellType() == boc.PrunedBranchCell {
+		return true
+	}
+	for _, r := range c.Refs() {
+		if hasPruned(r, visited) {
+			return true
+		}
+	}
+	return false
+}
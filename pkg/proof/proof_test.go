@@ -0,0 +1,675 @@
+package proof
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/liteclient"
+	"github.com/tonkeeper/tongo/tl"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"math/bits"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test data are mainnet blocks and states:
+//   - block-mc-17734191.boc is the masterchain block 17734191;
+//   - block-0-40484438.boc is the basechain block 40484438;
+//   - config-proof-33651872.boc is the proof of the masterchain state with the full config after the block 33651872.
+//
+// Liteserver responses are built from them the way liteservers build them: merkle proofs keep the data required
+// for the check and prune the rest, states are taken from state updates of the blocks. Signatures of real validators
+// are not available, so forward links are signed by test validators of the key block built from the masterchain block.
+// Other responses which do not fit the real blocks (the basechain block in the masterchain state, the config state
+// in the masterchain block) are proven by the masterchain block with the replaced state.
+
+const (
+	mcSeqno       = 17734191
+	bcSeqno       = 40484438
+	electorLastLt = 24836995000002
+	bcAccountLt   = 43049086000005
+)
+
+var (
+	electorAddress   = mustAccountID("-1:3333333333333333333333333333333333333333333333333333333333333333")
+	bcAccountAddress = mustAccountID("0:027f1e403415519d26aaaeef18fc120d742a04a5b6cf62c0a63940f21da952bc")
+)
+
+func mustAccountID(s string) ton.AccountID {
+	id, err := ton.ParseAccountID(s)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+type testBlock struct {
+	id    ton.BlockIDExt
+	root  *boc.Cell
+	info  tlb.BlockInfo
+	state *boc.Cell // the state after the block from the state update
+}
+
+func loadBlock(t *testing.T, name string) testBlock {
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells, err := boc.DeserializeBoc(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestBlock(t, cells[0], sha256.Sum256(b))
+}
+
+func newTestBlock(t *testing.T, root *boc.Cell, fileHash ton.Bits256) testBlock {
+	var info tlb.BlockInfo
+	root.Refs()[0].ResetCounters()
+	err := tlb.Unmarshal(root.Refs()[0], &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := representationHash(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard := info.Shard.ShardPrefix | 1<<(63-info.Shard.ShardPfxBits)
+	return testBlock{
+		id: ton.BlockIDExt{
+			BlockID:  ton.BlockID{Workchain: info.Shard.WorkchainID, Shard: shard, Seqno: info.SeqNo},
+			RootHash: hash,
+			FileHash: fileHash,
+		},
+		root:  root,
+		info:  info,
+		state: root.Refs()[2].Refs()[1],
+	}
+}
+
+// withState returns the block with the state replaced in the state update
+func (b testBlock) withState(t *testing.T, state *boc.Cell) testBlock {
+	hashes := mustHash(t, state)
+	update := b.root.Refs()[2]
+	updateBits := update.RawBitString()
+	updateBits = updateBits.Copy()
+	setBits(t, &updateBits, 8+256, hashes.hash(0))
+	setBits(t, &updateBits, 8+256+256+16, binary.BigEndian.AppendUint16(nil, uint16(hashes.depth(0))))
+	update = newCell(t, boc.MerkleUpdateCell, updateBits, update.Refs()[0], state)
+	return newTestBlock(t, withRef(t, b.root, 2, update), sha256.Sum256([]byte("file of the block with the replaced state")))
+}
+
+// proof returns the merkle proof of the block header, the extra is kept for config proofs of key blocks
+func (b testBlock) proof(t *testing.T, withExtra bool) *boc.Cell {
+	update := b.root.Refs()[2]
+	update = newCell(t, boc.MerkleUpdateCell, update.RawBitString(), prune(t, update.Refs()[0], 2), prune(t, update.Refs()[1], 2))
+	extra := b.root.Refs()[3]
+	if !withExtra {
+		extra = prune(t, extra, 1)
+	}
+	root := newCell(t, boc.OrdinaryCell, b.root.RawBitString(), b.root.Refs()[0], prune(t, b.root.Refs()[1], 1), update, extra)
+	return merkleProof(t, root)
+}
+
+func mustHash(t *testing.T, c *boc.Cell) *cellHashes {
+	h, err := hashCell(c, map[*boc.Cell]*cellHashes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func newCell(t *testing.T, cellType boc.CellType, data boc.BitString, refs ...*boc.Cell) *boc.Cell {
+	c := boc.NewCellExotic(cellType)
+	err := c.WriteBitString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range refs {
+		err = c.AddRef(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func withRef(t *testing.T, c *boc.Cell, i int, r *boc.Cell) *boc.Cell {
+	refs := c.Refs()
+	refs[i] = r
+	return newCell(t, c.CellType(), c.RawBitString(), refs...)
+}
+
+func setBits(t *testing.T, s *boc.BitString, offset int, value []byte) {
+	for i := 0; i < len(value)*8; i++ {
+		var err error
+		if keyBit(value, i) {
+			err = s.On(offset + i)
+		} else {
+			err = s.Off(offset + i)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// withBits returns the copy of the cell with the bits at the offset replaced by the value
+func withBits(t *testing.T, c *boc.Cell, offset int, value []byte) *boc.Cell {
+	data := c.RawBitString()
+	data = data.Copy()
+	setBits(t, &data, offset, value)
+	return newCell(t, c.CellType(), data, c.Refs()...)
+}
+
+// replace returns the tree with the target cell replaced
+func replace(t *testing.T, root, target, replacement *boc.Cell) *boc.Cell {
+	cache := map[*boc.Cell]*boc.Cell{}
+	var walk func(c *boc.Cell) *boc.Cell
+	walk = func(c *boc.Cell) *boc.Cell {
+		if c == target {
+			return replacement
+		}
+		if r, ok := cache[c]; ok {
+			return r
+		}
+		res := c
+		for i, r := range c.Refs() {
+			if n := walk(r); n != r {
+				res = withRef(t, res, i, n)
+			}
+		}
+		cache[c] = res
+		return res
+	}
+	return walk(root)
+}
+
+// prune returns the pruned branch of the cell for the level of merkle proofs and updates
+func prune(t *testing.T, c *boc.Cell, level int) *boc.Cell {
+	h := mustHash(t, c)
+	mask := h.mask | 1<<(level-1)
+	res := boc.NewCellExotic(boc.PrunedBranchCell)
+	var hashes, depths []byte
+	for i := 0; i < bits.Len32(mask); i++ {
+		if i > 0 && mask&(1<<(i-1)) == 0 {
+			continue
+		}
+		hashes = append(hashes, h.hash(i)...)
+		depths = binary.BigEndian.AppendUint16(depths, uint16(h.depth(i)))
+	}
+	err := res.WriteBytes(append([]byte{1, byte(mask)}, append(hashes, depths...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func merkleProof(t *testing.T, root *boc.Cell) *boc.Cell {
+	h := mustHash(t, root)
+	data := append([]byte{3}, h.hash(0)...)
+	data = binary.BigEndian.AppendUint16(data, uint16(h.depth(0)))
+	res := boc.NewCellExotic(boc.MerkleProofCell)
+	err := res.WriteBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = res.AddRef(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// serialize builds the bag of cells like liteservers do. boc.SerializeBoc takes level masks from deserialized
+// cells, so cells built by tests would be serialized with wrong masks.
+func serialize(t *testing.T, roots ...*boc.Cell) []byte {
+	index := map[*boc.Cell]int{}
+	var order []*boc.Cell
+	var visit func(c *boc.Cell)
+	visit = func(c *boc.Cell) {
+		if _, ok := index[c]; ok {
+			return
+		}
+		index[c] = -1
+		for _, r := range c.Refs() {
+			visit(r)
+		}
+		order = append(order, c)
+	}
+	for _, r := range roots {
+		visit(r)
+	}
+	// parents go before children
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	for i, c := range order {
+		index[c] = i
+	}
+	refSize := (bits.Len(uint(len(order))) + 7) / 8
+	cache := map[*boc.Cell]*cellHashes{}
+	var cells []byte
+	for _, c := range order {
+		h, err := hashCell(c, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d1 := byte(len(c.Refs())) + 32*byte(h.mask)
+		if c.CellType() != boc.OrdinaryCell {
+			d1 += 8
+		}
+		bitLen := c.BitSize()
+		data := append([]byte{}, h.data...)
+		if bitLen%8 != 0 {
+			data[len(data)-1] |= 1 << (7 - bitLen%8)
+		}
+		cells = append(cells, d1, byte((bitLen+7)/8+bitLen/8))
+		cells = append(cells, data...)
+		for _, r := range c.Refs() {
+			cells = appendUint(cells, index[r], refSize)
+		}
+	}
+	offSize := (bits.Len(uint(len(cells))) + 7) / 8
+	res := []byte{0xb5, 0xee, 0x9c, 0x72, byte(refSize), byte(offSize)}
+	res = appendUint(res, len(order), refSize)
+	res = appendUint(res, len(roots), refSize)
+	res = appendUint(res, 0, refSize)
+	res = appendUint(res, len(cells), offSize)
+	for _, r := range roots {
+		res = appendUint(res, index[r], refSize)
+	}
+	return append(res, cells...)
+}
+
+func appendUint(b []byte, v, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+type testValidator struct {
+	key    ed25519.PrivateKey
+	weight uint64
+}
+
+func newTestValidators() []testValidator {
+	var res []testValidator
+	for i, weight := range []uint64{10, 20, 30, 60} {
+		seed := sha256.Sum256([]byte{byte(i)})
+		res = append(res, testValidator{key: ed25519.NewKeyFromSeed(seed[:]), weight: weight})
+	}
+	return res
+}
+
+// sign returns signatures of the block by the validators
+func sign(block ton.BlockIDExt, validators ...testValidator) []liteclient.LiteServerSignatureC {
+	msg := binary.LittleEndian.AppendUint32(nil, blockIDMagic)
+	msg = append(msg, block.RootHash[:]...)
+	msg = append(msg, block.FileHash[:]...)
+	var res []liteclient.LiteServerSignatureC
+	for _, v := range validators {
+		key := v.key.Public().(ed25519.PublicKey)
+		res = append(res, liteclient.LiteServerSignatureC{
+			NodeIdShort: tl.Int256(sha256.Sum256(append(binary.LittleEndian.AppendUint32(nil, ed25519KeyMagic), key...))),
+			Signature:   ed25519.Sign(v.key, msg),
+		})
+	}
+	return res
+}
+
+// keyBlock builds the key block with the validators from the block, so the block is signed by them
+func keyBlock(t *testing.T, block testBlock, validators []testValidator) testBlock {
+	var keys []tlb.Uint16
+	var descrs []tlb.ValidatorDescr
+	for i, v := range validators {
+		keys = append(keys, tlb.Uint16(i))
+		var descr tlb.ValidatorDescr
+		descr.SumType = "Validator"
+		descr.Validator = &struct {
+			PublicKey tlb.SigPubKey
+			Weight    uint64
+		}{Weight: v.weight}
+		copy(descr.Validator.PublicKey.PubKey[:], v.key.Public().(ed25519.PublicKey))
+		descrs = append(descrs, descr)
+	}
+	list := tlb.NewHashmap(keys, descrs)
+	validatorSet := boc.NewCell()
+	for _, v := range []struct {
+		value uint64
+		size  int
+	}{{0x11, 8}, {0, 32}, {1<<32 - 1, 32}, {uint64(len(validators)), 16}, {uint64(len(validators)), 16}} {
+		err := validatorSet.WriteUint(v.value, v.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := tlb.Marshal(validatorSet, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := boc.NewCell()
+	err = tlb.Marshal(config, tlb.NewHashmap([]tlb.Uint32{validatorsParam}, []tlb.Ref[boc.Cell]{{Value: *validatorSet}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// masterchain_block_extra#cca5 key_block:(## 1) ... config:key_block?ConfigParams
+	extra := block.root.Refs()[3]
+	mcExtra := extra.Refs()[3]
+	mcExtraBits := mcExtra.RawBitString()
+	mcExtraBits = mcExtraBits.Copy()
+	err = mcExtraBits.On(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcExtraBits.Grow(256)
+	err = mcExtraBits.WriteBytes(electorAddress.Address[:]) // config_addr, it is not checked
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcExtra = newCell(t, boc.OrdinaryCell, mcExtraBits, append(mcExtra.Refs(), config)...)
+
+	// block_info#9bc7a987 version:uint32 not_master:(## 1) after_merge:(## 1) before_split:(## 1) after_split:(## 1)
+	// want_split:Bool want_merge:Bool key_block:Bool vert_seqno_incr:(## 1) flags:(## 8) seq_no:# ...
+	infoBits := block.root.Refs()[0].RawBitString()
+	infoBits = infoBits.Copy()
+	err = infoBits.On(64 + 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := newCell(t, boc.OrdinaryCell, infoBits, block.root.Refs()[0].Refs()...)
+	info = withBits(t, info, 64+16, binary.BigEndian.AppendUint32(nil, block.info.PrevKeyBlockSeqno))
+
+	root := withRef(t, withRef(t, block.root, 0, info), 3, withRef(t, extra, 3, mcExtra))
+	return newTestBlock(t, root, sha256.Sum256([]byte("file of the key block")))
+}
+
+func TestVerifyLink(t *testing.T) {
+	mc := loadBlock(t, "block-mc-17734191.boc")
+	validators := newTestValidators()
+	key := keyBlock(t, mc, validators)
+	prev, _, err := prevMasterchainBlock(stateCursor(t, mc.state), mcSeqno-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	back := func(from testBlock, to ton.BlockIDExt) liteclient.LiteServerBlockLink {
+		var link liteclient.LiteServerBlockLink
+		link.SumType = "LiteServerBlockLinkBack"
+		link.LiteServerBlockLinkBack.From = liteclient.BlockIDExt(from.id)
+		link.LiteServerBlockLinkBack.To = liteclient.BlockIDExt(to)
+		link.LiteServerBlockLinkBack.Proof = serialize(t, from.proof(t, false))
+		link.LiteServerBlockLinkBack.StateProof = serialize(t, merkleProof(t, from.state))
+		return link
+	}
+	forward := func(signatures []liteclient.LiteServerSignatureC) liteclient.LiteServerBlockLink {
+		var link liteclient.LiteServerBlockLink
+		link.SumType = "LiteServerBlockLinkForward"
+		l := &link.LiteServerBlockLinkForward
+		l.From = liteclient.BlockIDExt(key.id)
+		l.To = liteclient.BlockIDExt(mc.id)
+		l.DestProof = serialize(t, mc.proof(t, false))
+		l.ConfigProof = serialize(t, key.proof(t, true))
+		l.Signatures = liteclient.LiteServerSignatureSet{
+			ValidatorSetHash: mc.info.GenValidatorListHashShort,
+			CatchainSeqno:    mc.info.GenCatchainSeqno,
+			Signatures:       signatures,
+		}
+		return link
+	}
+	tampered := sign(mc.id, validators...)
+	tampered[2].Signature[0] ^= 1
+	unknown := newTestValidators()
+	for i := range unknown {
+		unknown[i].key = ed25519.NewKeyFromSeed(make([]byte, 32))
+	}
+	wrongFile := mc.id
+	wrongFile.FileHash[0] ^= 1
+	wrongProof := forward(sign(mc.id, validators...))
+	wrongProof.LiteServerBlockLinkForward.ConfigProof = serialize(t, mc.proof(t, true))
+	wrongPrev := prev
+	wrongPrev.RootHash[0] ^= 1
+
+	tests := []struct {
+		name    string
+		trusted ton.BlockIDExt
+		link    liteclient.LiteServerBlockLink
+		want    ton.BlockIDExt
+		err     string
+	}{
+		{name: "backward link", trusted: mc.id, link: back(mc, prev), want: prev},
+		{name: "backward link from another block", trusted: prev, link: back(mc, prev), err: "does not start at the trusted block"},
+		{name: "backward link to another block", trusted: mc.id, link: back(mc, wrongPrev), err: "not found in previous blocks"},
+		{name: "backward link to a pruned block", trusted: mc.id, link: back(mc, ton.BlockIDExt{BlockID: ton.BlockID{Workchain: -1, Shard: masterchainShard, Seqno: mcSeqno - 1000}}), err: errPruned.Error()},
+		{name: "forward link", trusted: key.id, link: forward(sign(mc.id, validators...)), want: mc.id},
+		{name: "forward link signed by more than 2/3 of weight", trusted: key.id, link: forward(sign(mc.id, validators[2:]...)), want: mc.id},
+		{name: "forward link signed by 2/3 of weight", trusted: key.id, link: forward(sign(mc.id, validators[1], validators[3])), err: "signed by 80 of 120 weight"},
+		{name: "forward link with a tampered signature", trusted: key.id, link: forward(tampered), err: "invalid signature"},
+		{name: "forward link signed by unknown validators", trusted: key.id, link: forward(sign(mc.id, unknown...)), err: "signed by 0 of 120 weight"},
+		{name: "forward link signed for another file", trusted: key.id, link: forward(sign(wrongFile, validators...)), err: "invalid signature"},
+		{name: "forward link with config of not a key block", trusted: key.id, link: wrongProof, err: "merkle proof is built for"},
+		{name: "forward link from not a key block", trusted: mc.id, link: forward(sign(mc.id, validators...)), err: "does not start at the trusted block"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := VerifyLink(tt.trusted, tt.link)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected block %v, got %v", tt.want.String(), got.String())
+			}
+		})
+	}
+}
+
+// stateCursor returns the state with the cursor after the tag
+func stateCursor(t *testing.T, state *boc.Cell) *boc.Cell {
+	state.ResetCounters()
+	err := readTag(state, 32, shardStateTag, "shard state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// accountCell returns the account from the accounts of the state
+func accountCell(t *testing.T, state *boc.Cell, account ton.AccountID) *boc.Cell {
+	accounts := state.Refs()[1]
+	accounts.ResetCounters()
+	if _, err := accounts.ReadBit(); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := dictLookup(accounts.Refs()[0], account.Address[:], 256)
+	if err != nil || leaf == nil {
+		t.Fatalf("account %v is not found: %v", account.ToRaw(), err)
+	}
+	if err = leaf.Skip(5); err != nil {
+		t.Fatal(err)
+	}
+	if err = skipCurrencyCollection(leaf); err != nil {
+		t.Fatal(err)
+	}
+	res, err := leaf.NextRef()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// withShard returns the masterchain state with the shard description of the basechain replaced by the block
+func withShard(t *testing.T, state *boc.Cell, block ton.BlockIDExt) *boc.Cell {
+	extra, err := mcStateExtra(stateCursor(t, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := dictLookup(extra.Refs()[0], uint32Key(0), 32)
+	if err != nil || leaf == nil {
+		t.Fatalf("basechain is not found: %v", err)
+	}
+	// bt_leaf$0 leaf:ShardDescr, shard_descr tag:(## 4) seq_no:uint32 reg_mc_seqno:uint32 start_lt:uint64 end_lt:uint64
+	// root_hash:bits256 file_hash:bits256 ...
+	node := leaf.Refs()[0]
+	descr := withBits(t, node, 1+4, binary.BigEndian.AppendUint32(nil, block.Seqno))
+	descr = withBits(t, descr, 1+4+32+32+64+64, block.RootHash[:])
+	descr = withBits(t, descr, 1+4+32+32+64+64+256, block.FileHash[:])
+	return replace(t, state, node, descr)
+}
+
+func TestVerifyAccountState(t *testing.T) {
+	mc := loadBlock(t, "block-mc-17734191.boc")
+	bc := loadBlock(t, "block-0-40484438.boc")
+	mcWithShard := mc.withState(t, withShard(t, mc.state, bc.id))
+	realShard, err := shardBlock(stateCursor(t, mc.state), bcAccountAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	electorState := serialize(t, accountCell(t, mc.state, electorAddress))
+	mcState := func(state []byte) liteclient.LiteServerAccountStateC {
+		return liteclient.LiteServerAccountStateC{
+			Id:       liteclient.BlockIDExt(mc.id),
+			Shardblk: liteclient.BlockIDExt(mc.id),
+			Proof:    serialize(t, mc.proof(t, false), merkleProof(t, mc.state)),
+			State:    state,
+		}
+	}
+	absent := electorAddress
+	absent.Address[31] ^= 1
+	pruned := mustAccountID("-1:0000000000000000000000000000000000000000000000000000000000000001")
+
+	bcState := liteclient.LiteServerAccountStateC{
+		Id:         liteclient.BlockIDExt(mcWithShard.id),
+		Shardblk:   liteclient.BlockIDExt(bc.id),
+		ShardProof: serialize(t, mcWithShard.proof(t, false), merkleProof(t, mcWithShard.state)),
+		Proof:      serialize(t, bc.proof(t, false), merkleProof(t, bc.state)),
+		State:      serialize(t, accountCell(t, bc.state, bcAccountAddress)),
+	}
+	wrongShard := bcState
+	wrongShard.Shardblk = liteclient.BlockIDExt(realShard)
+	realShardProof := bcState
+	realShardProof.ShardProof = serialize(t, mc.proof(t, false), merkleProof(t, mc.state))
+	wrongState := bcState
+	wrongState.State = electorState
+	mcAsShard := mcState(electorState)
+	mcAsShard.Shardblk = liteclient.BlockIDExt(bc.id)
+
+	tests := []struct {
+		name    string
+		trusted ton.BlockIDExt
+		account ton.AccountID
+		res     liteclient.LiteServerAccountStateC
+		lt      uint64 // of the last transaction of the account, 0 for the absent account
+		err     string
+	}{
+		{name: "masterchain account", trusted: mc.id, account: electorAddress, res: mcState(electorState), lt: electorLastLt},
+		{name: "absent masterchain account", trusted: mc.id, account: absent, res: mcState(nil)},
+		{name: "absent masterchain account with a state", trusted: mc.id, account: absent, res: mcState(electorState), err: "state of the absent account"},
+		{name: "masterchain account in a pruned branch", trusted: mc.id, account: pruned, res: mcState(nil), err: errPruned.Error()},
+		{name: "masterchain account of another block", trusted: mcWithShard.id, account: electorAddress, res: mcState(electorState), err: "not for the trusted block"},
+		{name: "masterchain account in a shard block", trusted: mc.id, account: electorAddress, res: mcAsShard, err: "not for the trusted block"},
+		{name: "basechain account", trusted: mcWithShard.id, account: bcAccountAddress, res: bcState, lt: bcAccountLt},
+		{name: "basechain account in a wrong shard block", trusted: mcWithShard.id, account: bcAccountAddress, res: wrongShard, err: "not the latest block of the account shard"},
+		{name: "basechain account with a shard proof of another block", trusted: mcWithShard.id, account: bcAccountAddress, res: realShardProof, err: "merkle proof is built for"},
+		{name: "basechain account with a state of another account", trusted: mcWithShard.id, account: bcAccountAddress, res: wrongState, err: "does not match the proof"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyAccountState(tt.trusted, tt.account, tt.res)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.lt == 0 {
+				if got.Account.SumType != "AccountNone" {
+					t.Fatalf("expected absent account, got %v", got.Account.SumType)
+				}
+				return
+			}
+			if got.Account.SumType != "Account" || got.LastTransLt != tt.lt {
+				t.Fatalf("expected account with the last transaction %d, got %v with %d", tt.lt, got.Account.SumType, got.LastTransLt)
+			}
+			addr, err := ton.AccountIDFromTlb(got.Account.Account.Addr)
+			if err != nil || addr == nil || *addr != tt.account {
+				t.Fatalf("expected state of %v, got %v", tt.account.ToRaw(), addr)
+			}
+		})
+	}
+}
+
+func TestVerifyConfig(t *testing.T) {
+	b, err := os.ReadFile("testdata/config-proof-33651872.boc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells, err := boc.DeserializeBoc(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := cells[0].Refs()[0]
+	mc := loadBlock(t, "block-mc-17734191.boc").withState(t, state)
+
+	extra, err := mcStateExtra(stateCursor(t, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configRoot := extra.Refs()[1]
+	prunedState := replace(t, state, configRoot.Refs()[1], prune(t, configRoot.Refs()[1], 1))
+
+	res := func(configProof []byte) liteclient.LiteServerConfigInfoC {
+		return liteclient.LiteServerConfigInfoC{
+			Id:          liteclient.BlockIDExt(mc.id),
+			StateProof:  serialize(t, mc.proof(t, false)),
+			ConfigProof: configProof,
+		}
+	}
+	another := loadBlock(t, "block-mc-17734191.boc")
+
+	tests := []struct {
+		name    string
+		trusted ton.BlockIDExt
+		res     liteclient.LiteServerConfigInfoC
+		err     string
+	}{
+		{name: "config", trusted: mc.id, res: res(b)},
+		{name: "config with a pruned param", trusted: mc.id, res: res(serialize(t, merkleProof(t, prunedState))), err: errPruned.Error()},
+		{name: "config of another block", trusted: another.id, res: res(b), err: "not for the trusted block"},
+		{name: "config of another state", trusted: mc.id, res: res(serialize(t, merkleProof(t, another.state))), err: "merkle proof is built for"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := VerifyConfig(tt.trusted, tt.res)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(config.ConfigAddr[:]) != strings.Repeat("55", 32) {
+				t.Errorf("invalid config address %x", config.ConfigAddr)
+			}
+			if _, ok := config.Config.Get(validatorsParam); !ok {
+				t.Error("config has no validator set")
+			}
+		})
+	}
+}
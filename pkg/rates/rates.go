@@ -0,0 +1,123 @@
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Provider returns price of one whole unit of currency (by ticker) in fiat currency
+type Provider interface {
+	GetRate(ctx context.Context, fiat, ticker string) (*big.Rat, error)
+}
+
+// Rates is a price of one whole unit of currency (by ticker) in fiat currency: fiat -> ticker -> rate.
+// JSON layout: {"USD": {"TON": "3.25", "USDT": 1}}
+type Rates map[string]map[string]json.Number
+
+func (r Rates) get(fiat, ticker string) (*big.Rat, error) {
+	rate, ok := r[fiat][ticker]
+	if !ok {
+		return nil, fmt.Errorf("rate %s/%s not found", ticker, fiat)
+	}
+	res, ok := new(big.Rat).SetString(rate.String())
+	if !ok || res.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate %s/%s: %s", ticker, fiat, rate)
+	}
+	return res, nil
+}
+
+// StaticProvider returns rates from config file
+type StaticProvider struct {
+	rates Rates
+}
+
+func NewStaticProvider(rates Rates) *StaticProvider {
+	return &StaticProvider{rates: rates}
+}
+
+func LoadStaticProvider(path string) (*StaticProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rates, err := decodeRates(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rates file %s: %w", path, err)
+	}
+	return NewStaticProvider(rates), nil
+}
+
+func (p *StaticProvider) GetRate(ctx context.Context, fiat, ticker string) (*big.Rat, error) {
+	return p.rates.get(fiat, ticker)
+}
+
+// HTTPProvider loads rates from JSON endpoint and caches them for ttl
+type HTTPProvider struct {
+	client *http.Client
+	url    string
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	rates     Rates
+	updatedAt time.Time
+}
+
+func NewHTTPProvider(ratesURL string, ttl time.Duration) (*HTTPProvider, error) {
+	_, err := url.ParseRequestURI(ratesURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %s", ratesURL)
+	}
+	return &HTTPProvider{
+		client: &http.Client{Timeout: 10 * time.Second},
+		url:    ratesURL,
+		ttl:    ttl,
+	}, nil
+}
+
+func (p *HTTPProvider) GetRate(ctx context.Context, fiat, ticker string) (*big.Rat, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rates == nil || time.Since(p.updatedAt) > p.ttl {
+		rates, err := p.load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load rates: %w", err)
+		}
+		p.rates = rates
+		p.updatedAt = time.Now()
+	}
+	return p.rates.get(fiat, ticker)
+}
+
+func (p *HTTPProvider) load(ctx context.Context) (Rates, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rates response status: %v", response.Status)
+	}
+	return decodeRates(response.Body)
+}
+
+func decodeRates(r io.Reader) (Rates, error) {
+	var rates Rates
+	err := json.NewDecoder(r).Decode(&rates)
+	if err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
@@ -0,0 +1,130 @@
+package rates
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPProvider(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"USD": {"TON": "3.25", "USDT": 1}}`))
+	}))
+	defer server.Close()
+
+	provider, err := NewHTTPProvider(server.URL, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fiat, ticker string
+		want         *big.Rat
+		wantErr      bool
+	}{
+		{fiat: "USD", ticker: "TON", want: big.NewRat(13, 4)},
+		{fiat: "USD", ticker: "USDT", want: big.NewRat(1, 1)},
+		{fiat: "USD", ticker: "NOT", wantErr: true},
+		{fiat: "EUR", ticker: "TON", wantErr: true},
+	}
+	for _, tt := range tests {
+		rate, err := provider.GetRate(context.Background(), tt.fiat, tt.ticker)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s/%s: expected error", tt.ticker, tt.fiat)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s/%s: %v", tt.ticker, tt.fiat, err)
+		}
+		if rate.Cmp(tt.want) != 0 {
+			t.Errorf("%s/%s: got %v, want %v", tt.ticker, tt.fiat, rate, tt.want)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("rates must be cached, got %d requests", requests.Load())
+	}
+}
+
+func TestHTTPProviderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	provider, err := NewHTTPProvider(server.URL, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.GetRate(context.Background(), "USD", "TON")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLoadStaticProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(path, []byte(`{"USD": {"TON": "3.25", "USDT": 1, "NOT": "0"}, "EUR": {"TON": 3}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := LoadStaticProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fiat, ticker string
+		want         *big.Rat
+		wantErr      bool
+	}{
+		{fiat: "USD", ticker: "TON", want: big.NewRat(13, 4)},
+		{fiat: "USD", ticker: "USDT", want: big.NewRat(1, 1)},
+		{fiat: "EUR", ticker: "TON", want: big.NewRat(3, 1)},
+		{fiat: "EUR", ticker: "USDT", wantErr: true},
+		{fiat: "USD", ticker: "NOT", wantErr: true}, // rate must be positive
+		{fiat: "GBP", ticker: "TON", wantErr: true},
+	}
+	for _, tt := range tests {
+		rate, err := provider.GetRate(context.Background(), tt.fiat, tt.ticker)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s/%s: expected error", tt.ticker, tt.fiat)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s/%s: %v", tt.ticker, tt.fiat, err)
+		}
+		if rate.Cmp(tt.want) != 0 {
+			t.Errorf("%s/%s: got %v, want %v", tt.ticker, tt.fiat, rate, tt.want)
+		}
+	}
+}
+
+func TestLoadStaticProviderError(t *testing.T) {
+	dir := t.TempDir()
+	_, err := LoadStaticProvider(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Error("expected error for missing file")
+	}
+	path := filepath.Join(dir, "rates.json")
+	for _, content := range []string{`{"USD": ["TON"]}`, `{"USD": {"TON": "x"}}`} {
+		err = os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadStaticProvider(path)
+		if err == nil {
+			t.Errorf("expected error for invalid file %s", content)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package stream
+
+import (
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"sync"
+)
+
+// subscriberBuffer is the number of events a subscriber can lag behind before it is dropped
+const subscriberBuffer = 64
+
+// Hub broadcasts events from the notifier to the stream subscribers
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[chan core.Event]struct{}
+	closed      bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[chan core.Event]struct{}),
+	}
+}
+
+// Publish sends the event to all subscribers without blocking.
+// A subscriber which does not keep up is dropped by closing its channel, it can resume from the event log.
+func (h *Hub) Publish(event core.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the channel of new events and the function to unsubscribe
+func (h *Hub) Subscribe() (<-chan core.Event, func()) {
+	ch := make(chan core.Event, subscriberBuffer)
+	h.mu.Lock()
+	if h.closed {
+		close(ch)
+	} else {
+		h.subscribers[ch] = struct{}{}
+	}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close drops all subscribers and closes channels of new ones, so streams are finished on the server shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,173 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"github.com/txsociety/spice-harvester/pkg/notifier"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Sender is the sender name of Telegram deliveries
+const Sender = "telegram"
+
+// DefaultTemplates are used if templates are not configured. Events without a template are not sent.
+var DefaultTemplates = map[string]string{
+	core.InvoicePaidEvent: `✅ Invoice paid: {{.Amount}} {{.Currency}}
+Status: {{.Status}}
+{{- with .MerchantName}}
+Merchant: {{.}}{{end}}
+{{- with .ExternalReference}}
+Order: {{.}}{{end}}
+{{- with .OrderReference}}
+Order number: {{.}}{{end}}
+Invoice: {{.InvoiceID}}`,
+	core.OverpaymentReceivedEvent: `⚠️ Payment of {{.PaymentAmount}} {{.PaymentCurrency}} to the {{.Status}} invoice
+{{- with .ExternalReference}}
+Order: {{.}}{{end}}
+{{- with .OrderReference}}
+Order number: {{.}}{{end}}
+Invoice: {{.InvoiceID}}`,
+}
+
+// Client posts messages about events to Telegram chats via Bot API
+type Client struct {
+	client     *http.Client
+	apiURL     string
+	token      string
+	chatIDs    []string
+	templates  map[string]*template.Template
+	currencies *core.CurrencyRegistry
+}
+
+// NewClient creates Telegram sender. Chat IDs are numeric IDs or @channel usernames.
+// Templates are text/template sources of messages by event type, executed with notifier.MessageData.
+func NewClient(apiURL, token string, chatIDs []string, templates map[string]string, currencies *core.CurrencyRegistry) (*Client, error) {
+	_, err := url.ParseRequestURI(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %s", apiURL)
+	}
+	if len(token) == 0 {
+		return nil, fmt.Errorf("empty bot token")
+	}
+	if len(chatIDs) == 0 {
+		return nil, fmt.Errorf("no chat IDs")
+	}
+	c := &Client{
+		client:     &http.Client{Timeout: 10 * time.Second},
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		token:      token,
+		chatIDs:    chatIDs,
+		templates:  make(map[string]*template.Template, len(templates)),
+		currencies: currencies,
+	}
+	for eventType, text := range templates {
+		if err := core.ValidateWebhookEventType(eventType); err != nil {
+			return nil, err
+		}
+		c.templates[eventType], err = template.New(eventType).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of %s: %w", eventType, err)
+		}
+	}
+	return c, nil
+}
+
+// LoadTemplates reads templates from JSON file: {"<event type>": "<template>"}
+func LoadTemplates(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var res map[string]string
+	err = json.Unmarshal(b, &res)
+	if err != nil {
+		return nil, fmt.Errorf("invalid templates file %s: %w", path, err)
+	}
+	return res, nil
+}
+
+// Send posts the event to all chats. Events without a template are skipped.
+// A failed delivery is retried for all chats, so the chats which already got the message can get it again.
+func (c *Client) Send(ctx context.Context, event core.EventEnvelope) error {
+	tmpl, ok := c.templates[event.Type]
+	if !ok {
+		return nil
+	}
+	var text bytes.Buffer
+	err := tmpl.Execute(&text, notifier.NewMessageData(event, c.currencies.All()))
+	if err != nil {
+		return fmt.Errorf("execute template of %s: %w", event.Type, err)
+	}
+	for _, chatID := range c.chatIDs {
+		err = c.sendMessage(ctx, chatID, text.String())
+		if err != nil {
+			return fmt.Errorf("send message to chat %s: %w", chatID, err)
+		}
+	}
+	return nil
+}
+
+// Alert sends the operator alert to all chats
+func (c *Client) Alert(ctx context.Context, text string) error {
+	for _, chatID := range c.chatIDs {
+		err := c.sendMessage(ctx, chatID, text)
+		if err != nil {
+			return fmt.Errorf("send alert to chat %s: %w", chatID, err)
+		}
+	}
+	return nil
+}
+
+type apiResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+func (c *Client) sendMessage(ctx context.Context, chatID, text string) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", c.apiURL+"/bot"+c.token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := c.client.Do(request)
+	if err != nil {
+		// the error contains URL with the bot token
+		return fmt.Errorf("bot api request error: %v", redact(err.Error(), c.token))
+	}
+	defer func() {
+		err := response.Body.Close()
+		if err != nil {
+			slog.Error("response body close", "error", err.Error())
+		}
+	}()
+	var res apiResponse
+	err = json.NewDecoder(response.Body).Decode(&res)
+	if err != nil {
+		return fmt.Errorf("bot api response status: %v", response.Status)
+	}
+	if !res.OK {
+		return fmt.Errorf("bot api error: %s", res.Description)
+	}
+	return nil
+}
+
+func redact(s, token string) string {
+	return strings.ReplaceAll(s, token, "<token>")
+}
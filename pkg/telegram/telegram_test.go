@@ -0,0 +1,108 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	type message struct {
+		ChatID string `json:"chat_id"`
+		Text   string `json:"text"`
+	}
+	var (
+		mu       sync.Mutex
+		messages []message
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottest-token/sendMessage" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"ok":false,"error_code":404,"description":"Not Found"}`))
+			return
+		}
+		var m message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Error(err)
+		}
+		if m.ChatID == "@blocked" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`))
+			return
+		}
+		mu.Lock()
+		messages = append(messages, m)
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	defer server.Close()
+
+	currencies := core.NewCurrencyRegistry(map[string]core.ExtendedCurrency{core.DefaultTonTicker: {Currency: core.TonCurrency()}})
+	client, err := NewClient(server.URL, "test-token", []string{"100", "@shop"}, DefaultTemplates, currencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paid := core.EventEnvelope{
+		ID:   "event",
+		Type: core.InvoicePaidEvent,
+		Invoice: &core.PrivateInvoicePrintable{
+			PublicInvoicePrintable: core.PublicInvoicePrintable{
+				ID:       "03cfc582-b1c3-410a-a9a7-1f3afe326b3b",
+				Status:   string(core.PaidInvoiceStatus),
+				Amount:   "1500000000",
+				Currency: core.DefaultTonTicker,
+			},
+			PrivateInfo:       map[string]json.RawMessage{"order_number": json.RawMessage(`1234`)},
+			Metadata:          map[string]json.RawMessage{"merchant_name": json.RawMessage(`"Coffee shop"`)},
+			ExternalReference: "order-42",
+		},
+	}
+	err = client.Send(context.Background(), paid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].ChatID != "100" || messages[1].ChatID != "@shop" {
+		t.Fatalf("unexpected messages: %v", messages)
+	}
+	for _, s := range []string{"1.5 TON", "Status: paid", "Coffee shop", "order-42", "Order number: 1234", "03cfc582-b1c3-410a-a9a7-1f3afe326b3b"} {
+		if !strings.Contains(messages[0].Text, s) {
+			t.Errorf("message %q does not contain %q", messages[0].Text, s)
+		}
+	}
+
+	// events without a template are skipped
+	err = client.Send(context.Background(), core.EventEnvelope{ID: "event", Type: core.InvoiceCreatedEvent, Invoice: paid.Invoice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("message for event without template: %v", messages[2:])
+	}
+
+	err = client.Alert(context.Background(), "liteservers disagree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 || messages[2].Text != "liteservers disagree" || messages[3].ChatID != "@shop" {
+		t.Fatalf("unexpected alert messages: %v", messages[2:])
+	}
+
+	blocked, err := NewClient(server.URL, "test-token", []string{"@blocked"}, DefaultTemplates, currencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = blocked.Send(context.Background(), paid)
+	if err == nil || !strings.Contains(err.Error(), "bot was blocked") {
+		t.Fatalf("expected bot api error, got %v", err)
+	}
+
+	_, err = NewClient(server.URL, "test-token", []string{"100"}, map[string]string{"invoice.unknown": "text"}, currencies)
+	if err == nil {
+		t.Fatal("expected error for unknown event type")
+	}
+}
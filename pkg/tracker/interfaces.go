@@ -0,0 +1,26 @@
+package tracker
+
+import (
+	"context"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+)
+
+type blockchain interface {
+	GetJettonWallet(ctx context.Context, jettonMaster, owner ton.AccountID) (ton.AccountID, error)
+	GetAccountState(ctx context.Context, accountID ton.AccountID) (tlb.ShardAccount, uint32, error)
+}
+
+type storage interface {
+	GetTrackedAccounts(ctx context.Context, recipient ton.AccountID, currencies map[string]core.ExtendedCurrency) (map[ton.AccountID]core.AccountInfo, error)
+	CreateAccount(ctx context.Context, account core.Account, lastTxID core.TxID) error
+	SaveCurrencies(ctx context.Context, currencies map[string]core.ExtendedCurrency) error
+	SaveCurrency(ctx context.Context, currency core.RegisteredCurrency) error
+	GetCurrencies(ctx context.Context) ([]core.RegisteredCurrency, error)
+	GetMerchants(ctx context.Context) ([]core.Merchant, error)
+}
+
+type indexer interface {
+	UntrackAccount(account ton.AccountID) bool
+}
@@ -0,0 +1,304 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tonkeeper/tongo/ton"
+	"github.com/txsociety/spice-harvester/pkg/core"
+	"log/slog"
+	"sync"
+)
+
+// Tracker manages currencies and the accounts tracked by the indexer for the recipients of all merchants.
+// Jettons can be added and disabled at runtime without the restart.
+type Tracker struct {
+	mu         sync.Mutex // serializes changes of currencies and merchants
+	currencies *core.CurrencyRegistry
+	storage    storage
+	blockchain blockchain
+	indexer    indexer
+	accounts   chan<- core.Account
+}
+
+// New creates tracker. Accounts are sent to the indexer by the accounts channel.
+func New(currencies *core.CurrencyRegistry, storage storage, blockchain blockchain, indexer indexer, accounts chan<- core.Account) *Tracker {
+	return &Tracker{
+		currencies: currencies,
+		storage:    storage,
+		blockchain: blockchain,
+		indexer:    indexer,
+		accounts:   accounts,
+	}
+}
+
+// LoadCurrencies saves configured currencies and returns the registry with them and the currencies added at runtime.
+// Disabled currencies stay disabled even if they are configured. A currency added at runtime is skipped
+// if its ticker is taken by a configured currency.
+func LoadCurrencies(ctx context.Context, storage storage, configured map[string]core.ExtendedCurrency) (*core.CurrencyRegistry, error) {
+	err := storage.SaveCurrencies(ctx, configured)
+	if err != nil {
+		return nil, fmt.Errorf("save currencies: %w", err)
+	}
+	saved, err := storage.GetCurrencies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get currencies: %w", err)
+	}
+	registry := core.NewCurrencyRegistry(configured)
+	for _, c := range saved {
+		if cur, ok := configured[c.Ticker]; ok && cur.Currency != c.Currency {
+			slog.Warn("currency ticker is used by configured currency", "ticker", c.Ticker, "currency", c.Currency.String())
+			continue
+		}
+		registry.Set(c)
+	}
+	return registry, nil
+}
+
+// Start sends accounts of enabled currencies accepted by every merchant to the indexer.
+// New accounts are tracked from their last transaction.
+func (t *Tracker) Start(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	merchants, err := t.storage.GetMerchants(ctx)
+	if err != nil {
+		return fmt.Errorf("get merchants: %w", err)
+	}
+	for _, m := range merchants {
+		err = t.trackMerchant(ctx, m)
+		if err != nil {
+			return fmt.Errorf("track merchant %v: %w", m.ID, err)
+		}
+	}
+	return nil
+}
+
+// TrackMerchant starts tracking accounts of the currencies accepted by the merchant and stops tracking
+// jetton wallets of the currencies the merchant no longer accepts. It is called after the merchant is created or updated.
+func (t *Tracker) TrackMerchant(ctx context.Context, merchant core.Merchant) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.trackMerchant(ctx, merchant)
+}
+
+func (t *Tracker) trackMerchant(ctx context.Context, merchant core.Merchant) error {
+	accepted := make(map[string]core.ExtendedCurrency)
+	rejected := make(map[string]core.ExtendedCurrency)
+	for _, c := range t.currencies.List() {
+		switch {
+		case !c.Enabled:
+		case merchant.Accepts(c.Ticker):
+			accepted[c.Ticker] = c.ExtendedCurrency
+		case c.Type == core.Jetton:
+			rejected[c.Ticker] = c.ExtendedCurrency
+		}
+	}
+	accounts, err := t.getAccountsForTracking(ctx, merchant.Recipient, accepted)
+	if err != nil {
+		return err
+	}
+	for acc, info := range accounts {
+		err = t.track(ctx, core.Account{AccountID: acc, Info: info})
+		if err != nil {
+			return err
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	accounts, err = t.storage.GetTrackedAccounts(ctx, merchant.Recipient, rejected)
+	if err != nil {
+		return fmt.Errorf("get tracked accounts: %w", err)
+	}
+	for acc, info := range accounts {
+		if info.Jetton != nil && t.indexer.UntrackAccount(acc) {
+			slog.Info("jetton wallet untracked", "merchant", merchant.ID, "wallet", acc.ToRaw())
+		}
+	}
+	return nil
+}
+
+// AddJetton adds the jetton and starts tracking jetton wallets of the merchants which accept it.
+// A disabled jetton is enabled again if it is added with the same ticker, the wallet is indexed from the last indexed transaction.
+func (t *Tracker) AddJetton(ctx context.Context, ticker string, master ton.AccountID, decimals int) (core.RegisteredCurrency, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	currency := core.RegisteredCurrency{
+		Ticker:           ticker,
+		ExtendedCurrency: core.ExtendedCurrency{Currency: core.JettonCurrency(master), JettonDecimals: decimals},
+	}
+	if existing, ok := t.currencies.Lookup(ticker); ok {
+		if existing.Currency != currency.Currency {
+			return core.RegisteredCurrency{}, fmt.Errorf("ticker %s is used by another currency: %w", ticker, core.ErrAlreadyExists)
+		}
+		if existing.Enabled {
+			return core.RegisteredCurrency{}, fmt.Errorf("currency %s: %w", ticker, core.ErrAlreadyExists)
+		}
+	} else if existing, ok := t.currencies.Find(currency.Currency); ok {
+		return core.RegisteredCurrency{}, fmt.Errorf("jetton is added with ticker %s: %w", existing.Ticker, core.ErrAlreadyExists)
+	}
+	// the currency is saved disabled until its account is created, so a failure does not leave it enabled without the account
+	err := t.storage.SaveCurrency(ctx, currency)
+	if err != nil {
+		return core.RegisteredCurrency{}, fmt.Errorf("save currency: %w", err)
+	}
+	merchants, err := t.storage.GetMerchants(ctx)
+	if err != nil {
+		return core.RegisteredCurrency{}, fmt.Errorf("get merchants: %w", err)
+	}
+	var wallets []core.Account
+	for _, m := range merchants {
+		if !m.Accepts(ticker) {
+			continue
+		}
+		accounts, err := t.getAccountsForTracking(ctx, m.Recipient, map[string]core.ExtendedCurrency{ticker: currency.ExtendedCurrency})
+		if err != nil {
+			return core.RegisteredCurrency{}, err
+		}
+		wallet, info, err := jettonAccount(accounts, master)
+		if err != nil {
+			return core.RegisteredCurrency{}, err
+		}
+		wallets = append(wallets, core.Account{AccountID: wallet, Info: info})
+	}
+	currency.Enabled = true
+	err = t.storage.SaveCurrency(ctx, currency)
+	if err != nil {
+		return core.RegisteredCurrency{}, fmt.Errorf("save currency: %w", err)
+	}
+	t.currencies.Set(currency)
+	for _, wallet := range wallets {
+		err = t.track(ctx, wallet)
+		if err != nil {
+			return core.RegisteredCurrency{}, err
+		}
+	}
+	slog.Info("jetton added", "ticker", ticker, "jetton", master.ToRaw(), "wallets", len(wallets))
+	return currency, nil
+}
+
+// DisableCurrency stops tracking jetton wallets of all merchants. New invoices can not be created in the disabled currency,
+// payments to the wallet are not processed until the jetton is added again.
+func (t *Tracker) DisableCurrency(ctx context.Context, ticker string) (core.RegisteredCurrency, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	currency, ok := t.currencies.Lookup(ticker)
+	if !ok {
+		return core.RegisteredCurrency{}, fmt.Errorf("currency %s: %w", ticker, core.ErrNotFound)
+	}
+	if currency.Type != core.Jetton {
+		return core.RegisteredCurrency{}, fmt.Errorf("only jettons can be disabled")
+	}
+	if !currency.Enabled {
+		return currency, nil
+	}
+	merchants, err := t.storage.GetMerchants(ctx)
+	if err != nil {
+		return core.RegisteredCurrency{}, fmt.Errorf("get merchants: %w", err)
+	}
+	var wallets []ton.AccountID
+	for _, m := range merchants {
+		accounts, err := t.storage.GetTrackedAccounts(ctx, m.Recipient, map[string]core.ExtendedCurrency{ticker: currency.ExtendedCurrency})
+		if err != nil {
+			return core.RegisteredCurrency{}, fmt.Errorf("get tracked accounts: %w", err)
+		}
+		if wallet, _, err := jettonAccount(accounts, *currency.Jetton()); err == nil {
+			wallets = append(wallets, wallet)
+		}
+	}
+	currency.Enabled = false
+	err = t.storage.SaveCurrency(ctx, currency)
+	if err != nil {
+		return core.RegisteredCurrency{}, fmt.Errorf("save currency: %w", err)
+	}
+	t.currencies.Set(currency)
+	for _, wallet := range wallets {
+		t.indexer.UntrackAccount(wallet)
+	}
+	slog.Info("currency disabled", "ticker", ticker)
+	return currency, nil
+}
+
+// track sends the account to the indexer
+func (t *Tracker) track(ctx context.Context, account core.Account) error {
+	select {
+	case t.accounts <- account:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getAccountsForTracking returns accounts of the recipient for the currencies. Accounts which are not saved yet
+// are created with the last transaction as the start point.
+func (t *Tracker) getAccountsForTracking(ctx context.Context, recipient ton.AccountID, currencies map[string]core.ExtendedCurrency) (map[ton.AccountID]core.AccountInfo, error) {
+	accounts, err := t.storage.GetTrackedAccounts(ctx, recipient, currencies)
+	if err != nil {
+		return nil, err
+	}
+	newAccounts := make(map[ton.AccountID]core.AccountInfo)
+	for _, cur := range currencies {
+		switch cur.Type {
+		case core.Extra: // same as TON account
+			continue
+		case core.TON:
+			_, ok := accounts[recipient]
+			if !ok {
+				newAcc := core.AccountInfo{
+					Recipient: recipient,
+				}
+				accounts[recipient] = newAcc
+				newAccounts[recipient] = newAcc
+			}
+			continue
+		}
+		found := false
+		for _, account := range accounts {
+			if account.Recipient != recipient || account.Jetton == nil || *account.Jetton != *cur.Jetton() {
+				continue
+			}
+			found = true
+		}
+		if !found {
+			jettonWallet, err := t.blockchain.GetJettonWallet(ctx, *cur.Jetton(), recipient)
+			if err != nil {
+				return nil, err
+			}
+			newAcc := core.AccountInfo{
+				Recipient: recipient,
+				Jetton:    cur.Jetton(),
+			}
+			accounts[jettonWallet] = newAcc
+			newAccounts[jettonWallet] = newAcc
+		}
+	}
+	for acc, info := range newAccounts {
+		state, _, err := t.blockchain.GetAccountState(ctx, acc)
+		if err != nil {
+			return nil, err
+		}
+		txID := core.TxID{
+			Lt:   state.LastTransLt,
+			Hash: ton.Bits256(state.LastTransHash),
+		}
+		info.MaxDepthLt = txID.Lt // set last LT as start LT for new accounts
+		accounts[acc] = info
+		err = t.storage.CreateAccount(ctx, core.Account{
+			AccountID: acc,
+			Info:      info,
+		}, txID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+func jettonAccount(accounts map[ton.AccountID]core.AccountInfo, master ton.AccountID) (ton.AccountID, core.AccountInfo, error) {
+	for acc, info := range accounts {
+		if info.Jetton != nil && *info.Jetton == master {
+			return acc, info, nil
+		}
+	}
+	return ton.AccountID{}, core.AccountInfo{}, errors.New("jetton wallet is not found")
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader contains the delivery timestamp and HMAC-SHA256 signatures in the form
+// `t=<unix timestamp>,v1=<hex signature>[,v1=<hex signature>]`.
+// The signature covers `<unix timestamp>.<body>`. During the secret rotation there is a signature for every active secret.
+const SignatureHeader = "X-Signature"
+
+// DefaultTolerance is the recommended maximum age of the delivery timestamp
+const DefaultTolerance = 5 * time.Minute
+
+const signatureScheme = "v1"
+
+var (
+	ErrNoSignature      = errors.New("no webhook signature")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrTimestampExpired = errors.New("webhook timestamp is out of tolerance")
+)
+
+// Sign returns the SignatureHeader value for the body signed at the timestamp by every secret
+func Sign(body []byte, timestamp time.Time, secrets ...string) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	parts := make([]string, 0, len(secrets)+1)
+	parts = append(parts, "t="+t)
+	for _, secret := range secrets {
+		parts = append(parts, signatureScheme+"="+hex.EncodeToString(computeSignature(secret, t, body)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Verify checks that the SignatureHeader value contains a valid signature of the body made by any of the secrets
+// and the timestamp is not older than tolerance. Zero tolerance disables the timestamp check.
+func Verify(header string, body []byte, tolerance time.Duration, secrets ...string) error {
+	if len(header) == 0 {
+		return ErrNoSignature
+	}
+	var (
+		t          string
+		signatures [][]byte
+	)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fmt.Errorf("%w: invalid header format", ErrInvalidSignature)
+		}
+		switch key {
+		case "t":
+			t = value
+		case signatureScheme:
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+			}
+			signatures = append(signatures, sig)
+		}
+	}
+	timestamp, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidSignature)
+	}
+	if len(signatures) == 0 {
+		return ErrNoSignature
+	}
+	if tolerance > 0 {
+		age := time.Since(time.Unix(timestamp, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrTimestampExpired
+		}
+	}
+	for _, secret := range secrets {
+		expected := computeSignature(secret, t, body)
+		for _, sig := range signatures {
+			if hmac.Equal(expected, sig) {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// VerifyRequest checks the signature of the webhook request. The body is expected to be already read.
+func VerifyRequest(r *http.Request, body []byte, tolerance time.Duration, secrets ...string) error {
+	return Verify(r.Header.Get(SignatureHeader), body, tolerance, secrets...)
+}
+
+func computeSignature(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -12,42 +12,75 @@ import (
 	"time"
 )
 
+// EndpointSender is the sender name of WEBHOOK_ENDPOINT deliveries
+const EndpointSender = "endpoint"
+
+// maxSecrets is the number of secrets signing deliveries at the same time: the current and the previous one during rotation
+const maxSecrets = 2
+
 type Client struct {
-	client *http.Client
-	url    string
+	client  *http.Client
+	url     string
+	secrets []string
 }
 
-func NewClient(webhookURL string) (*Client, error) {
+// NewClient creates webhook client. Deliveries are signed by every secret (see SignatureHeader).
+// Deliveries are not signed if there are no secrets.
+func NewClient(webhookURL string, secrets []string) (*Client, error) {
 	_, err := url.ParseRequestURI(webhookURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid url: %s", webhookURL)
 	}
+	if len(secrets) > maxSecrets {
+		return nil, fmt.Errorf("too many webhook secrets: %d, at most %d are allowed", len(secrets), maxSecrets)
+	}
+	for _, secret := range secrets {
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("empty webhook secret")
+		}
+	}
 	return &Client{
-		client: &http.Client{Timeout: 10 * time.Second},
-		url:    webhookURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		url:     webhookURL,
+		secrets: secrets,
 	}, nil
 }
 
-func (s *Client) Send(ctx context.Context, invoice core.PrivateInvoicePrintable) error {
-	jsonData, err := json.Marshal(invoice)
+func (s *Client) Send(ctx context.Context, event core.EventEnvelope) error {
+	return deliver(ctx, s.client, s.url, s.secrets, event)
+}
+
+// Dispatcher delivers notifications to webhooks managed via API
+type Dispatcher struct {
+	client *http.Client
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Deliver sends the payload to the webhook signed by its secrets
+func (d *Dispatcher) Deliver(ctx context.Context, webhook core.Webhook, payload any) error {
+	return deliver(ctx, d.client, webhook.URL, webhook.Secrets(), payload)
+}
+
+// deliver makes one attempt to send the payload, retries are scheduled by the notifier
+func deliver(ctx context.Context, client *http.Client, endpoint string, secrets []string, payload any) error {
+	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
 	if err != nil {
 		return err
 	}
 	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	for i := 1; i < 4; i++ {
-		err := doRequest(s.client, request)
-		if err != nil {
-			slog.Info("webhook sending", "error", err.Error())
-			time.Sleep(time.Second * time.Duration(i))
-			continue
-		}
-		return nil
+	if len(secrets) > 0 {
+		request.Header.Set(SignatureHeader, Sign(jsonData, time.Now(), secrets...))
 	}
-	return fmt.Errorf("attempts to send a webhook ended")
+	return doRequest(client, request)
 }
 
 func doRequest(client *http.Client, request *http.Request) error {
@@ -1,10 +1,13 @@
 package webhook
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/txsociety/spice-harvester/pkg/core"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/signal"
 	"syscall"
@@ -57,3 +60,42 @@ func getNotification(resp http.ResponseWriter, req *http.Request) {
 	fmt.Printf("Notification: %s\n", res)
 	resp.WriteHeader(http.StatusOK)
 }
+
+func TestSignedDelivery(t *testing.T) {
+	var (
+		header string
+		body   []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(SignatureHeader)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, []string{"new-secret", "old-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Send(context.Background(), core.EventEnvelope{ID: "event", Type: core.InvoicePaidEvent, SchemaVersion: core.EventSchemaVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"new-secret", "old-secret"} {
+		if err := Verify(header, body, DefaultTolerance, secret); err != nil {
+			t.Errorf("verify with %v: %v", secret, err)
+		}
+	}
+	if err := Verify(header, body, DefaultTolerance, "unknown-secret"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("unknown secret: expected invalid signature, got %v", err)
+	}
+	if err := Verify(header, []byte(`{"type":"invoice.expired"}`), DefaultTolerance, "new-secret"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("forged body: expected invalid signature, got %v", err)
+	}
+	old := Sign(body, time.Now().Add(-time.Hour), "new-secret")
+	if err := Verify(old, body, DefaultTolerance, "new-secret"); !errors.Is(err, ErrTimestampExpired) {
+		t.Errorf("old timestamp: expected expired, got %v", err)
+	}
+	if err := Verify("", body, DefaultTolerance, "new-secret"); !errors.Is(err, ErrNoSignature) {
+		t.Errorf("no header: expected no signature, got %v", err)
+	}
+}